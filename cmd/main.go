@@ -3,22 +3,22 @@ package main
 import (
 	"log"
 
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/badger"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/hashcash"
 	"github.com/jleipus/learn-blockchain/internal/cli"
+	"github.com/jleipus/learn-blockchain/internal/config"
 )
 
 func main() {
-	hashcash.SetVerbose()
-	powFactory := hashcash.New()
+	rootCmd := cli.NewRootCmd(func(cfg config.Config) (blockchain.Storage, blockchain.ProofOfWorkFactory, error) {
+		storage, err := badger.NewStorage(cfg.StoragePath())
+		if err != nil {
+			return nil, nil, err
+		}
 
-	storage, err := badger.NewStorage("blockchain.db")
-	if err != nil {
-		panic(err)
-	}
-	defer storage.Close()
-
-	rootCmd := cli.NewRootCmd(storage, powFactory)
+		return storage, hashcash.NewWithParams(storage, cfg.PoWParams()), nil
+	})
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
@@ -1,24 +1,39 @@
 package badger
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 
 	badger "github.com/dgraph-io/badger/v4"
 	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/utxo"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 )
 
 const (
 	blocksPrefix  = "blocks_"
+	headersPrefix = "headers_" // Headers of blocks whose transactions are not stored yet
+	metaPrefix    = "meta_"
+	heightPrefix  = "height_"
+	txIndexPrefix = "txindex_"
+	mempoolPrefix = "mempool_"
 	walletsPrefix = "wallets_"
+	watchPrefix   = "watch_"            // Addresses watched without a key
+	walletEncKey  = "wallet_encryption" // Not under walletsPrefix, so it is not listed as a wallet
+	hdChainKey    = "wallet_hdchain"
 	tipKey        = "tip"
-	utxoPrefix    = "utxo"
+	utxoPrefix    = "utxo_outpoints_"
+	utxoIdxPrefix = "utxo_pubkeyhash_" // Index of the outpoints locked with a public key hash
+	undoPrefix    = "undo_"
+	utxoTipKey    = "utxo_tip"
 )
 
 type badgerStorage struct {
-	db *badger.DB
+	db  *badger.DB
+	txn *badger.Txn // Set when the storage is used inside Update
 }
 
 func NewStorage(path string) (blockchain.Storage, error) {
@@ -75,13 +90,113 @@ func (bs *badgerStorage) AddBlock(block block.Block) error {
 	return bs.blocksSet(hash[:], blockData)
 }
 
-func (bs *badgerStorage) AddWallet(address string, wallet wallet.Wallet) error {
-	walletData, err := wallet.Serialize()
+func (bs *badgerStorage) GetHeader(hash block.Hash) (*block.Header, error) {
+	headerData, err := bs.get(append([]byte(headersPrefix), hash[:]...))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		b, err := bs.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		return &b.Header, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := &block.Header{}
+	err = header.Deserialize(headerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+func (bs *badgerStorage) AddHeader(hash block.Hash, header block.Header) error {
+	return bs.set(append([]byte(headersPrefix), hash[:]...), header.Serialize())
+}
+
+func (bs *badgerStorage) DeleteHeader(hash block.Hash) error {
+	err := bs.delete(append([]byte(headersPrefix), hash[:]...))
 	if err != nil {
 		return err
 	}
 
-	return bs.walletsSet([]byte(address), walletData)
+	return bs.delete(append([]byte(metaPrefix), hash[:]...))
+}
+
+func (bs *badgerStorage) GetBlockMeta(hash block.Hash) (*block.Meta, error) {
+	metaData, err := bs.metaGet(hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &block.Meta{}
+	err = meta.Deserialize(metaData)
+	if err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (bs *badgerStorage) SetBlockMeta(hash block.Hash, meta block.Meta) error {
+	return bs.metaSet(hash[:], meta.Serialize())
+}
+
+func (bs *badgerStorage) GetBlockHashByHeight(height int) (block.Hash, error) {
+	hashData, err := bs.get(heightKey(height))
+	if err != nil {
+		return block.Hash{}, err
+	}
+
+	var hash block.Hash
+	copy(hash[:], hashData)
+	return hash, nil
+}
+
+func (bs *badgerStorage) SetBlockHashByHeight(height int, hash block.Hash) error {
+	return bs.set(heightKey(height), hash[:])
+}
+
+func (bs *badgerStorage) DeleteBlockHashByHeight(height int) error {
+	return bs.delete(heightKey(height))
+}
+
+func (bs *badgerStorage) GetTxLocation(txID transaction.TxID) (*block.TxLocation, error) {
+	locData, err := bs.get(append([]byte(txIndexPrefix), txID[:]...))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, block.ErrTxNotIndexed
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	loc := &block.TxLocation{}
+	if len(locData) != len(loc.BlockHash)+4 {
+		return nil, errors.New("invalid transaction location length")
+	}
+
+	copy(loc.BlockHash[:], locData)
+	loc.Index = int(binary.BigEndian.Uint32(locData[len(loc.BlockHash):]))
+
+	return loc, nil
+}
+
+// SetTxLocation stores the location as the block hash followed by the big-endian transaction index.
+func (bs *badgerStorage) SetTxLocation(txID transaction.TxID, loc block.TxLocation) error {
+	locData := binary.BigEndian.AppendUint32(loc.BlockHash[:], uint32(loc.Index)) //nolint:gosec // Indexes are small
+	return bs.set(append([]byte(txIndexPrefix), txID[:]...), locData)
+}
+
+func (bs *badgerStorage) DeleteTxLocation(txID transaction.TxID) error {
+	return bs.delete(append([]byte(txIndexPrefix), txID[:]...))
+}
+
+func (bs *badgerStorage) AddWallet(address string, data []byte) error {
+	return bs.walletsSet([]byte(address), data)
 }
 
 func (bs *badgerStorage) GetAddresses() ([]string, error) {
@@ -97,35 +212,172 @@ func (bs *badgerStorage) GetAddresses() ([]string, error) {
 	return addresses, nil
 }
 
-func (bs *badgerStorage) GetWallet(address string) (*wallet.Wallet, error) {
+func (bs *badgerStorage) GetWallet(address string) ([]byte, error) {
 	walletData, err := bs.walletsGet([]byte(address))
 	if errors.Is(err, badger.ErrKeyNotFound) {
-		return nil, errors.New("wallet not found")
+		return nil, wallet.ErrWalletNotFound
 	}
 
+	return walletData, err
+}
+
+func (bs *badgerStorage) AddWatchAddress(address string) error {
+	return bs.set(append([]byte(watchPrefix), address...), nil)
+}
+
+func (bs *badgerStorage) GetWatchAddresses() ([]string, error) {
+	addresses := make([]string, 0)
+	err := bs.getAll(watchPrefix, func(key, _ []byte) error {
+		addresses = append(addresses, string(key))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	w := &wallet.Wallet{}
-	err = w.Deserialize(walletData)
+	return addresses, nil
+}
+
+func (bs *badgerStorage) GetWalletEncryption() ([]byte, error) {
+	encryption, err := bs.get([]byte(walletEncKey))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+
+	return encryption, err
+}
+
+func (bs *badgerStorage) GetHDChain() ([]byte, error) {
+	data, err := bs.get([]byte(hdChainKey))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (bs *badgerStorage) SetHDChain(data []byte) error {
+	return bs.set([]byte(hdChainKey), data)
+}
+
+// ReplaceWallets stores the encryption parameters, the HD chain and the wallets in one transaction,
+// so that the wallets are never stored with parameters they are not encrypted with.
+func (bs *badgerStorage) ReplaceWallets(encryption, hdChain []byte, wallets map[string][]byte) error {
+	return bs.update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(walletEncKey), encryption); err != nil {
+			return err
+		}
+
+		if hdChain != nil {
+			if err := txn.Set([]byte(hdChainKey), hdChain); err != nil {
+				return err
+			}
+		}
+
+		for address, data := range wallets {
+			if err := txn.Set(append([]byte(walletsPrefix), address...), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (bs *badgerStorage) GetUTXOTip() (block.Hash, error) {
+	tip, err := bs.get([]byte(utxoTipKey))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return block.Hash{}, nil
+	}
+
 	if err != nil {
-		return nil, err
+		return block.Hash{}, err
 	}
 
-	return w, nil
+	var hash block.Hash
+	copy(hash[:], tip)
+	return hash, nil
+}
+
+func (bs *badgerStorage) SetUTXOTip(hash block.Hash) error {
+	return bs.set([]byte(utxoTipKey), hash[:])
 }
 
-func (bs *badgerStorage) GetUTXOs() (map[transaction.TxID][]transaction.TxOutput, error) {
-	utxos := make(map[transaction.TxID][]transaction.TxOutput)
+func (bs *badgerStorage) GetUTXOs() (map[transaction.Outpoint]utxo.Entry, error) {
+	utxos := make(map[transaction.Outpoint]utxo.Entry)
 	err := bs.getAll(utxoPrefix, func(key, value []byte) error {
-		txID := transaction.TxID{}
-		copy(txID[:], key)
-		outputs, err := transaction.DeserializeOutputs(value)
+		outpoint, err := decodeOutpoint(key)
 		if err != nil {
 			return err
 		}
-		utxos[txID] = outputs
+
+		entry := utxo.Entry{}
+		if err := entry.Deserialize(value); err != nil {
+			return err
+		}
+
+		utxos[outpoint] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return utxos, nil
+}
+
+func (bs *badgerStorage) GetUTXO(outpoint transaction.Outpoint) (*utxo.Entry, error) {
+	entryData, err := bs.utxosGet(encodeOutpoint(outpoint))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, utxo.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &utxo.Entry{}
+	err = entry.Deserialize(entryData)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// GetUTXOsByPubKeyHash looks up the outputs locked with the public key hash in the address index,
+// so only the outputs of the address are read.
+func (bs *badgerStorage) GetUTXOsByPubKeyHash(pubKeyHash []byte) (map[transaction.Outpoint]utxo.Entry, error) {
+	utxos := make(map[transaction.Outpoint]utxo.Entry)
+	prefix := utxoIndexKey(pubKeyHash, nil)
+
+	err := bs.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)[len(prefix):]
+			outpoint, err := decodeOutpoint(key)
+			if err != nil {
+				return err
+			}
+
+			item, err := txn.Get(append([]byte(utxoPrefix), key...))
+			if err != nil {
+				return fmt.Errorf("failed to get indexed UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
+			}
+
+			entry := utxo.Entry{}
+			err = item.Value(entry.Deserialize)
+			if err != nil {
+				return err
+			}
+
+			utxos[outpoint] = entry
+		}
 		return nil
 	})
 	if err != nil {
@@ -135,15 +387,118 @@ func (bs *badgerStorage) GetUTXOs() (map[transaction.TxID][]transaction.TxOutput
 	return utxos, nil
 }
 
-func (bs *badgerStorage) SetUTXOs(txID transaction.TxID, outputs []transaction.TxOutput) error {
-	data, err := transaction.SerializeOutputs(outputs)
+// SetUTXO stores the output together with its entry in the address index.
+func (bs *badgerStorage) SetUTXO(outpoint transaction.Outpoint, entry utxo.Entry) error {
+	key := encodeOutpoint(outpoint)
+
+	return bs.update(func(txn *badger.Txn) error {
+		// An overwritten output may be indexed under another address
+		if err := deleteUTXO(txn, key); err != nil {
+			return err
+		}
+
+		if err := txn.Set(append([]byte(utxoPrefix), key...), entry.Serialize()); err != nil {
+			return err
+		}
+
+		return txn.Set(utxoIndexKey(entry.Output.PubKeyHash, key), nil)
+	})
+}
+
+// DeleteUTXO removes the output and its entry in the address index.
+func (bs *badgerStorage) DeleteUTXO(outpoint transaction.Outpoint) error {
+	return bs.update(func(txn *badger.Txn) error {
+		return deleteUTXO(txn, encodeOutpoint(outpoint))
+	})
+}
+
+// deleteUTXO removes the output with the given encoded outpoint and its index entry, if it is stored.
+func deleteUTXO(txn *badger.Txn, key []byte) error {
+	utxoKey := append([]byte(utxoPrefix), key...)
+
+	item, err := txn.Get(utxoKey)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := utxo.Entry{}
+	err = item.Value(entry.Deserialize)
 	if err != nil {
 		return err
 	}
-	return bs.utxosSet(txID[:], data)
+
+	err = txn.Delete(utxoIndexKey(entry.Output.PubKeyHash, key))
+	if err != nil {
+		return err
+	}
+
+	return txn.Delete(utxoKey)
+}
+
+func (bs *badgerStorage) GetUndo(hash block.Hash) (*utxo.Undo, error) {
+	undoData, err := bs.get(append([]byte(undoPrefix), hash[:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	undo := &utxo.Undo{}
+	err = undo.Deserialize(undoData)
+	if err != nil {
+		return nil, err
+	}
+
+	return undo, nil
+}
+
+func (bs *badgerStorage) SetUndo(hash block.Hash, undo utxo.Undo) error {
+	return bs.set(append([]byte(undoPrefix), hash[:]...), undo.Serialize())
+}
+
+func (bs *badgerStorage) GetPendingTxs() (map[transaction.TxID]*transaction.Tx, error) {
+	txs := make(map[transaction.TxID]*transaction.Tx)
+	err := bs.getAll(mempoolPrefix, func(key, value []byte) error {
+		tx := &transaction.Tx{}
+		if err := tx.Deserialize(value); err != nil {
+			return err
+		}
+		txs[transaction.TxID(key)] = tx
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txs, nil
+}
+
+func (bs *badgerStorage) AddPendingTx(tx transaction.Tx) error {
+	return bs.mempoolSet(tx.ID[:], tx.Serialize())
+}
+
+func (bs *badgerStorage) DeletePendingTx(txID transaction.TxID) error {
+	return bs.delete(append([]byte(mempoolPrefix), txID[:]...))
+}
+
+// Update runs fn in a single badger transaction, which is committed if fn returns nil and discarded otherwise.
+// Calling Update on the storage passed to fn runs in the same transaction.
+func (bs *badgerStorage) Update(fn func(blockchain.Storage) error) error {
+	if bs.txn != nil {
+		return fn(bs)
+	}
+
+	return bs.db.Update(func(txn *badger.Txn) error {
+		return fn(&badgerStorage{db: bs.db, txn: txn})
+	})
 }
 
 func (bs *badgerStorage) Close() error {
+	if bs.txn != nil {
+		return errors.New("cannot close storage inside a transaction")
+	}
+
 	return bs.db.Close()
 }
 
@@ -155,6 +510,14 @@ func (bs *badgerStorage) blocksSet(key, value []byte) error {
 	return bs.set(append([]byte(blocksPrefix), key...), value)
 }
 
+func (bs *badgerStorage) metaGet(key []byte) ([]byte, error) {
+	return bs.get(append([]byte(metaPrefix), key...))
+}
+
+func (bs *badgerStorage) metaSet(key, value []byte) error {
+	return bs.set(append([]byte(metaPrefix), key...), value)
+}
+
 func (bs *badgerStorage) walletsGet(key []byte) ([]byte, error) {
 	return bs.get(append([]byte(walletsPrefix), key...))
 }
@@ -167,13 +530,31 @@ func (bs *badgerStorage) utxosGet(key []byte) ([]byte, error) {
 	return bs.get(append([]byte(utxoPrefix), key...))
 }
 
-func (bs *badgerStorage) utxosSet(key, value []byte) error {
-	return bs.set(append([]byte(utxoPrefix), key...), value)
+func (bs *badgerStorage) mempoolSet(key, value []byte) error {
+	return bs.set(append([]byte(mempoolPrefix), key...), value)
+}
+
+// view runs fn in the storage's transaction, or in a new read-only transaction.
+func (bs *badgerStorage) view(fn func(txn *badger.Txn) error) error {
+	if bs.txn != nil {
+		return fn(bs.txn)
+	}
+
+	return bs.db.View(fn)
+}
+
+// update runs fn in the storage's transaction, or in a new read-write transaction.
+func (bs *badgerStorage) update(fn func(txn *badger.Txn) error) error {
+	if bs.txn != nil {
+		return fn(bs.txn)
+	}
+
+	return bs.db.Update(fn)
 }
 
 func (bs *badgerStorage) get(key []byte) ([]byte, error) {
 	value := make([]byte, 0)
-	return value, bs.db.View(
+	return value, bs.view(
 		func(tx *badger.Txn) error {
 			item, err := tx.Get(key)
 			if err != nil {
@@ -191,7 +572,7 @@ func (bs *badgerStorage) get(key []byte) ([]byte, error) {
 }
 
 func (bs *badgerStorage) getAll(prefix string, handle func([]byte, []byte) error) error {
-	return bs.db.View(func(txn *badger.Txn) error {
+	return bs.view(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		p := []byte(prefix)
@@ -216,8 +597,50 @@ func (bs *badgerStorage) getAll(prefix string, handle func([]byte, []byte) error
 }
 
 func (bs *badgerStorage) set(key, value []byte) error {
-	return bs.db.Update(
+	return bs.update(
 		func(txn *badger.Txn) error {
 			return txn.Set(key, value)
 		})
 }
+
+func (bs *badgerStorage) delete(key []byte) error {
+	return bs.update(
+		func(txn *badger.Txn) error {
+			return txn.Delete(key)
+		})
+}
+
+// heightKey returns the key of the height index entry for the given height.
+func heightKey(height int) []byte {
+	return binary.BigEndian.AppendUint64([]byte(heightPrefix), uint64(height)) //nolint:gosec // Heights are not negative
+}
+
+// utxoIndexKey returns the address index key of an encoded outpoint locked with the public key hash.
+// The hash is prefixed with its length, so that the keys of one hash are never a prefix of another's.
+// Without an outpoint it returns the prefix of all index keys of the hash.
+func utxoIndexKey(pubKeyHash, outpoint []byte) []byte {
+	key := make([]byte, 0, len(utxoIdxPrefix)+1+len(pubKeyHash)+len(outpoint))
+	key = append(key, utxoIdxPrefix...)
+	key = append(key, byte(len(pubKeyHash)))
+	key = append(key, pubKeyHash...)
+
+	return append(key, outpoint...)
+}
+
+// encodeOutpoint encodes an outpoint as the transaction ID followed by the big-endian output index.
+func encodeOutpoint(outpoint transaction.Outpoint) []byte {
+	return binary.BigEndian.AppendUint32(outpoint.TxID[:], uint32(outpoint.Vout)) //nolint:gosec // Output indexes are small
+}
+
+// decodeOutpoint decodes an outpoint encoded by encodeOutpoint.
+func decodeOutpoint(key []byte) (transaction.Outpoint, error) {
+	var txID transaction.TxID
+	if len(key) != len(txID)+4 {
+		return transaction.Outpoint{}, errors.New("invalid outpoint key length")
+	}
+
+	copy(txID[:], key)
+	vout := binary.BigEndian.Uint32(key[len(txID):])
+
+	return transaction.Outpoint{TxID: txID, Vout: int(vout)}, nil
+}
@@ -1,6 +1,10 @@
 package badger_test
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
 	"os"
 	"testing"
 
@@ -8,6 +12,7 @@ import (
 	"github.com/jleipus/learn-blockchain/internal/blockchain/badger"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/utxo"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -48,16 +53,68 @@ func TestSetAndGetTip(t *testing.T) {
 	})
 }
 
+func TestUpdate(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	t.Run("commit", func(t *testing.T) {
+		hash := block.Hash{'c', 'o', 'm', 'm', 'i', 't'}
+
+		err := db.Update(func(s blockchain.Storage) error {
+			if err := s.SetTip(hash); err != nil {
+				return err
+			}
+
+			// Changes are visible inside the transaction
+			tip, err := s.GetTip()
+			require.NoError(t, err)
+			assert.Equal(t, hash, tip)
+
+			return s.SetUTXOTip(hash)
+		})
+		require.NoError(t, err)
+
+		tip, err := db.GetTip()
+		require.NoError(t, err)
+		assert.Equal(t, hash, tip)
+
+		utxoTip, err := db.GetUTXOTip()
+		require.NoError(t, err)
+		assert.Equal(t, hash, utxoTip)
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		tip, err := db.GetTip()
+		require.NoError(t, err)
+
+		failure := errors.New("failure")
+		err = db.Update(func(s blockchain.Storage) error {
+			if err := s.SetTip(block.Hash{'d', 'i', 's', 'c', 'a', 'r', 'd'}); err != nil {
+				return err
+			}
+
+			return failure
+		})
+		require.ErrorIs(t, err, failure)
+
+		current, err := db.GetTip()
+		require.NoError(t, err)
+		assert.Equal(t, tip, current)
+	})
+}
+
 func TestAddAndGetBlock(t *testing.T) {
 	db, cleanup := setupTestStorage(t)
 	t.Cleanup(cleanup)
 
 	b := &block.Block{
-		Timestamp:     1234567890,
-		Transactions:  nil,
-		PrevBlockHash: block.Hash{'0', '0', '0'},
-		Hash:          block.Hash{'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'},
-		PoW:           []byte{'p', 'o', 'w'},
+		Header: block.Header{
+			PrevBlockHash: block.Hash{'0', '0', '0'},
+			Timestamp:     1234567890,
+			Nonce:         42,
+		},
+		Transactions: nil,
+		Hash:         block.Hash{'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'},
 	}
 
 	err := db.AddBlock(*b)
@@ -75,6 +132,125 @@ func TestAddAndGetBlock(t *testing.T) {
 	})
 }
 
+func TestAddAndGetHeader(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	header := block.Header{
+		Version:       block.HeaderVersion,
+		PrevBlockHash: block.Hash{'0', '0', '0'},
+		Timestamp:     1234567890,
+		Nonce:         42,
+	}
+	hash := block.Hash{'h', 'e', 'a', 'd', 'e', 'r'}
+
+	err := db.AddHeader(hash, header)
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		retrievedHeader, err := db.GetHeader(hash)
+		require.NoError(t, err)
+		assert.Equal(t, &header, retrievedHeader)
+
+		_, err = db.GetBlock(hash)
+		assert.Error(t, err, "only the header is stored")
+	})
+
+	t.Run("stored block", func(t *testing.T) {
+		b := block.Block{Header: header, Hash: block.Hash{'b', 'l', 'o', 'c', 'k'}}
+		b.Nonce = 7
+		require.NoError(t, db.AddBlock(b))
+
+		retrievedHeader, err := db.GetHeader(b.Hash)
+		require.NoError(t, err)
+		assert.Equal(t, &b.Header, retrievedHeader)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := db.GetHeader(block.Hash{'n', 'o', 't', 'f', 'o', 'u', 'n', 'd'})
+		assert.Error(t, err)
+	})
+}
+
+func TestSetAndGetBlockMeta(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	hash := block.Hash{'1', '2', '3'}
+	meta := block.Meta{Height: 42, Work: big.NewInt(123456789)}
+
+	err := db.SetBlockMeta(hash, meta)
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		retrievedMeta, err := db.GetBlockMeta(hash)
+		require.NoError(t, err)
+		assert.Equal(t, &meta, retrievedMeta)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := db.GetBlockMeta(block.Hash{'n', 'o', 't', 'f', 'o', 'u', 'n', 'd'})
+		assert.Error(t, err)
+	})
+}
+
+func TestSetGetAndDeleteBlockHashByHeight(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	hash := block.Hash{'1', '2', '3'}
+
+	err := db.SetBlockHashByHeight(7, hash)
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		retrievedHash, err := db.GetBlockHashByHeight(7)
+		require.NoError(t, err)
+		assert.Equal(t, hash, retrievedHash)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := db.GetBlockHashByHeight(8)
+		assert.Error(t, err)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, db.DeleteBlockHashByHeight(7))
+
+		_, err := db.GetBlockHashByHeight(7)
+		assert.Error(t, err)
+	})
+}
+
+func TestSetGetAndDeleteTxLocation(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	txID := transaction.TxID{'t', 'x', 'i', 'd'}
+	loc := block.TxLocation{BlockHash: block.Hash{'1', '2', '3'}, Index: 4}
+
+	err := db.SetTxLocation(txID, loc)
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		retrievedLoc, err := db.GetTxLocation(txID)
+		require.NoError(t, err)
+		assert.Equal(t, &loc, retrievedLoc)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := db.GetTxLocation(transaction.TxID{'n', 'o', 't', 'f', 'o', 'u', 'n', 'd'})
+		assert.ErrorIs(t, err, block.ErrTxNotIndexed)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, db.DeleteTxLocation(txID))
+
+		_, err := db.GetTxLocation(txID)
+		assert.ErrorIs(t, err, block.ErrTxNotIndexed)
+	})
+}
+
 func TestAddAndGetWallet(t *testing.T) {
 	db, cleanup := setupTestStorage(t)
 	t.Cleanup(cleanup)
@@ -90,20 +266,25 @@ func TestAddAndGetWallet(t *testing.T) {
 	wlt2, err := collection.GetWallet(address2)
 	require.NoError(t, err)
 
-	err = db.AddWallet(address1, *wlt1)
+	data1, err := wlt1.Serialize()
 	require.NoError(t, err)
-	err = db.AddWallet(address2, *wlt2)
+	data2, err := wlt2.Serialize()
+	require.NoError(t, err)
+
+	err = db.AddWallet(address1, data1)
+	require.NoError(t, err)
+	err = db.AddWallet(address2, data2)
 	require.NoError(t, err)
 
 	t.Run("ok", func(t *testing.T) {
-		retrievedWallet, err := db.GetWallet(address1)
+		retrievedData, err := db.GetWallet(address1)
 		require.NoError(t, err)
-		assert.Equal(t, wlt1, retrievedWallet)
+		assert.Equal(t, data1, retrievedData)
 	})
 
 	t.Run("not found", func(t *testing.T) {
 		_, err := db.GetWallet("nonexistent")
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, wallet.ErrWalletNotFound)
 	})
 
 	t.Run("get addresses", func(t *testing.T) {
@@ -114,29 +295,212 @@ func TestAddAndGetWallet(t *testing.T) {
 		assert.Contains(t, addresses, address2)
 		assert.Len(t, addresses, 2)
 	})
+
+	t.Run("encryption", func(t *testing.T) {
+		encryption, err := db.GetWalletEncryption()
+		require.NoError(t, err)
+		assert.Nil(t, encryption)
+
+		require.NoError(t, db.ReplaceWallets([]byte("params"), nil, map[string][]byte{address1: []byte("encrypted")}))
+
+		encryption, err = db.GetWalletEncryption()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("params"), encryption)
+
+		retrievedData, err := db.GetWallet(address1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("encrypted"), retrievedData)
+
+		addresses, err := db.GetAddresses()
+		require.NoError(t, err)
+		assert.Len(t, addresses, 2)
+	})
+
+	t.Run("watch addresses", func(t *testing.T) {
+		require.NoError(t, db.AddWatchAddress("watched"))
+
+		watched, err := db.GetWatchAddresses()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"watched"}, watched)
+
+		addresses, err := db.GetAddresses()
+		require.NoError(t, err)
+		assert.NotContains(t, addresses, "watched")
+	})
 }
 
-func TestGetAndSetUTXOs(t *testing.T) {
+func TestSetGetAndDeleteUTXOs(t *testing.T) {
 	db, cleanup := setupTestStorage(t)
 	t.Cleanup(cleanup)
 
 	txID := transaction.TxID{'t', 'x', 'i', 'd'}
-	outputs := []transaction.TxOutput{
-		{Value: 100, PubKeyHash: []byte("pubkey1")},
-		{Value: 200, PubKeyHash: []byte("pubkey2")},
+	outpoint0 := transaction.Outpoint{TxID: txID, Vout: 0}
+	outpoint1 := transaction.Outpoint{TxID: txID, Vout: 1}
+	entry0 := utxo.Entry{Output: transaction.TxOutput{Value: 100, PubKeyHash: []byte("pubkey1")}, Height: 3, Coinbase: true}
+	entry1 := utxo.Entry{Output: transaction.TxOutput{Value: 200, PubKeyHash: []byte("pubkey2")}, Height: 3}
+
+	require.NoError(t, db.SetUTXO(outpoint0, entry0))
+	require.NoError(t, db.SetUTXO(outpoint1, entry1))
+
+	t.Run("ok", func(t *testing.T) {
+		retrievedEntry, err := db.GetUTXO(outpoint1)
+		require.NoError(t, err)
+		assert.Equal(t, &entry1, retrievedEntry)
+
+		utxos, err := db.GetUTXOs()
+		require.NoError(t, err)
+		assert.Equal(t, map[transaction.Outpoint]utxo.Entry{outpoint0: entry0, outpoint1: entry1}, utxos)
+	})
+
+	t.Run("by public key hash", func(t *testing.T) {
+		utxos, err := db.GetUTXOsByPubKeyHash([]byte("pubkey1"))
+		require.NoError(t, err)
+		assert.Equal(t, map[transaction.Outpoint]utxo.Entry{outpoint0: entry0}, utxos)
+
+		utxos, err = db.GetUTXOsByPubKeyHash([]byte("pubkey"))
+		require.NoError(t, err)
+		assert.Empty(t, utxos)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		moved := entry1
+		moved.Output.PubKeyHash = []byte("pubkey3")
+		require.NoError(t, db.SetUTXO(outpoint1, moved))
+
+		utxos, err := db.GetUTXOsByPubKeyHash([]byte("pubkey2"))
+		require.NoError(t, err)
+		assert.Empty(t, utxos)
+
+		utxos, err = db.GetUTXOsByPubKeyHash([]byte("pubkey3"))
+		require.NoError(t, err)
+		assert.Equal(t, map[transaction.Outpoint]utxo.Entry{outpoint1: moved}, utxos)
+
+		require.NoError(t, db.SetUTXO(outpoint1, entry1))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, db.DeleteUTXO(outpoint0))
+
+		_, err := db.GetUTXO(outpoint0)
+		require.ErrorIs(t, err, utxo.ErrNotFound)
+
+		utxos, err := db.GetUTXOs()
+		require.NoError(t, err)
+		assert.Equal(t, map[transaction.Outpoint]utxo.Entry{outpoint1: entry1}, utxos)
+
+		utxos, err = db.GetUTXOsByPubKeyHash([]byte("pubkey1"))
+		require.NoError(t, err)
+		assert.Empty(t, utxos)
+	})
+}
+
+// BenchmarkFindUnspentTxOutputs looks up the outputs of one address among many outputs of other addresses.
+// With the address index the time should not grow with the size of the UTXO set.
+func BenchmarkFindUnspentTxOutputs(b *testing.B) {
+	const (
+		walletOutputs = 10
+		batchSize     = 1000
+	)
+
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("utxos=%d", size), func(b *testing.B) {
+			db, err := badger.NewStorage(b.TempDir())
+			require.NoError(b, err)
+			b.Cleanup(func() { db.Close() })
+
+			pubKeyHash := []byte("wallet")
+
+			// Badger limits the size of a transaction, so the outputs are written in batches
+			for first := 0; first < size; first += batchSize {
+				err := db.Update(func(s blockchain.Storage) error {
+					for i := first; i < min(first+batchSize, size); i++ {
+						var txID transaction.TxID
+						binary.BigEndian.PutUint64(txID[:], uint64(i))
+
+						owner := []byte(fmt.Sprintf("other%d", i%100))
+						if i%(size/walletOutputs) == 0 {
+							owner = pubKeyHash
+						}
+
+						entry := utxo.Entry{Output: transaction.TxOutput{Value: 1, PubKeyHash: owner}, Height: i}
+						if err := s.SetUTXO(transaction.Outpoint{TxID: txID}, entry); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				require.NoError(b, err)
+			}
+
+			utxoSet := utxo.NewUTXOSet(db)
+
+			for b.Loop() {
+				outputs, err := utxoSet.FindUnspentTxOutputs(pubKeyHash)
+				require.NoError(b, err)
+				require.Len(b, outputs, walletOutputs)
+			}
+		})
 	}
+}
 
-	err := db.SetUTXOs(txID, outputs)
+func TestSetAndGetUndo(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	hash := block.Hash{'1', '2', '3'}
+	undo := utxo.Undo{Spent: [][]utxo.SpentOutput{
+		nil,
+		{{
+			Outpoint: transaction.Outpoint{TxID: transaction.TxID{'t', 'x'}, Vout: 1},
+			Entry:    utxo.Entry{Output: transaction.TxOutput{Value: 5, PubKeyHash: []byte("pubkey")}, Height: 2},
+		}},
+	}}
+
+	err := db.SetUndo(hash, undo)
 	require.NoError(t, err)
 
 	t.Run("ok", func(t *testing.T) {
-		retrievedOutputs, err := db.GetUTXOs()
+		retrievedUndo, err := db.GetUndo(hash)
 		require.NoError(t, err)
-		assert.Equal(t, map[transaction.TxID][]transaction.TxOutput{txID: outputs}, retrievedOutputs)
+		assert.Equal(t, undo.Spent[1], retrievedUndo.Spent[1])
+		assert.Len(t, retrievedUndo.Spent, 2)
 	})
 
 	t.Run("not found", func(t *testing.T) {
-		_, err := db.GetUTXOs()
-		assert.NoError(t, err)
+		_, err := db.GetUndo(block.Hash{'n', 'o', 't', 'f', 'o', 'u', 'n', 'd'})
+		assert.Error(t, err)
+	})
+}
+
+func TestAddGetAndDeletePendingTxs(t *testing.T) {
+	db, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+
+	tx := transaction.Tx{
+		ID: transaction.TxID{'t', 'x', 'i', 'd'},
+		Vin: []transaction.TxInput{
+			{TxID: transaction.TxID{'p', 'r', 'e', 'v'}, Vout: 1, Signature: []byte("sig"), PubKey: []byte("pubkey")},
+		},
+		Vout: []transaction.TxOutput{
+			{Value: 100, PubKeyHash: []byte("pubkey1")},
+		},
+	}
+
+	err := db.AddPendingTx(tx)
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		txs, err := db.GetPendingTxs()
+		require.NoError(t, err)
+		assert.Equal(t, map[transaction.TxID]*transaction.Tx{tx.ID: &tx}, txs)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := db.DeletePendingTx(tx.ID)
+		require.NoError(t, err)
+
+		txs, err := db.GetPendingTxs()
+		require.NoError(t, err)
+		assert.Empty(t, txs)
 	})
 }
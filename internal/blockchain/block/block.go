@@ -3,6 +3,8 @@ package block
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"math/big"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
 	"github.com/jleipus/learn-blockchain/internal/merkel"
@@ -10,6 +12,9 @@ import (
 
 type Hash [32]byte
 
+// ErrTxNotIndexed is returned by Storage.GetTxLocation for a transaction that is not in the transaction index.
+var ErrTxNotIndexed = errors.New("transaction is not indexed")
+
 // Storage is an interface for a storage system that can store and retrieve blocks.
 type Storage interface {
 	// GetTip retrieves the hash of the last block in the blockchain.
@@ -21,22 +26,74 @@ type Storage interface {
 	GetBlock(hash Hash) (*Block, error)
 	// AddBlock adds a new block to the blockchain.
 	AddBlock(block Block) error
+	// GetHeader retrieves the header of a stored block, or of a block whose header was added on its own.
+	GetHeader(hash Hash) (*Header, error)
+	// AddHeader stores the header of a block whose transactions are not stored yet.
+	AddHeader(hash Hash, header Header) error
+	// DeleteHeader removes a header that was added on its own, together with its chain metadata.
+	DeleteHeader(hash Hash) error
+	// GetBlockMeta retrieves the chain metadata of a stored block.
+	GetBlockMeta(hash Hash) (*Meta, error)
+	// SetBlockMeta stores the chain metadata of a block.
+	SetBlockMeta(hash Hash, meta Meta) error
+	// GetBlockHashByHeight retrieves the hash of the block at the given height of the active chain.
+	GetBlockHashByHeight(height int) (Hash, error)
+	// SetBlockHashByHeight stores the hash of the block at the given height of the active chain.
+	SetBlockHashByHeight(height int, hash Hash) error
+	// DeleteBlockHashByHeight removes the block at the given height from the active chain.
+	DeleteBlockHashByHeight(height int) error
+	// GetTxLocation retrieves where a transaction of the active chain is stored, or ErrTxNotIndexed if it is not.
+	GetTxLocation(txID transaction.TxID) (*TxLocation, error)
+	// SetTxLocation stores where a transaction of the active chain is stored.
+	SetTxLocation(txID transaction.TxID, loc TxLocation) error
+	// DeleteTxLocation removes a transaction from the transaction index.
+	DeleteTxLocation(txID transaction.TxID) error
+}
+
+// TxLocation tells where a transaction is stored: the block that includes it and its position in the block.
+type TxLocation struct {
+	// BlockHash is the hash of the block that includes the transaction.
+	BlockHash Hash
+	// Index is the position of the transaction in the block's transactions.
+	Index int
+}
+
+// Meta holds the position of a stored block in the block tree.
+// It is derived from the block and its ancestors, so it is not part of the block itself.
+type Meta struct {
+	// Height is the number of blocks between this block and the genesis block.
+	Height int
+	// Work is the total amount of work done to produce this block and all of its ancestors.
+	Work *big.Int
+}
+
+// Serialize serializes the metadata into a byte slice using gob encoding.
+func (m *Meta) Serialize() []byte {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(m)
+	if err != nil {
+		// Error will only occur if the input contains unsupported types.
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// Deserialize deserializes a byte slice into Meta using gob encoding.
+func (m *Meta) Deserialize(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	return decoder.Decode(m)
 }
 
 // Block represents a block in the blockchain.
+// It consists of a header, which is covered by the block hash, and the transactions.
 type Block struct {
-	// Timestamp is the time when the block was created.
-	Timestamp int64
+	Header
 	// Transactions is a slice of transactions included in the block.
 	Transactions []*transaction.Tx
-	// PrevBlockHash is the hash of the previous block in the chain.
-	PrevBlockHash Hash
-	// Hash is the hash of the current block.
+	// Hash is the hash of the block's header.
 	Hash Hash
-	// PoW is the proof of work data for the block.
-	// It contains any additional data needed to verify the block's validity.
-	// e.g., nonce, difficulty target, etc.
-	PoW []byte
 }
 
 // HashTransactions computes the hash of all transactions in the block.
@@ -50,6 +107,14 @@ func (b *Block) HashTransactions() []byte {
 	return mTree.Root.GetData()
 }
 
+// ComputeMerkleRoot computes the Merkle root of the block's transactions for the header.
+func (b *Block) ComputeMerkleRoot() Hash {
+	var root Hash
+	copy(root[:], b.HashTransactions())
+
+	return root
+}
+
 // Serialize serializes the block into a byte slice using gob encoding.
 func (b *Block) Serialize() []byte {
 	var result bytes.Buffer
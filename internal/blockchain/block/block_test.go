@@ -45,11 +45,14 @@ func getBlock() *block.Block {
 	copy(blockHash[:], []byte("current-block-hash"))
 
 	return &block.Block{
-		Timestamp:     time.Now().Unix(),
-		Transactions:  []*transaction.Tx{tx1, tx2},
-		PrevBlockHash: prevHash,
-		Hash:          blockHash,
-		PoW:           []byte("proof-of-work-data"),
+		Header: block.Header{
+			Version:       block.HeaderVersion,
+			PrevBlockHash: prevHash,
+			Timestamp:     time.Now().Unix(),
+			Nonce:         42,
+		},
+		Transactions: []*transaction.Tx{tx1, tx2},
+		Hash:         blockHash,
 	}
 }
 
@@ -111,11 +114,12 @@ func TestSerializeDeserialize(t *testing.T) {
 
 	t.Run("with basic data", func(t *testing.T) {
 		b := &block.Block{
-			Timestamp:     1234567890,
-			Transactions:  nil,
-			PrevBlockHash: block.Hash{},
-			Hash:          block.Hash{},
-			PoW:           []byte("test-pow"),
+			Header: block.Header{
+				Timestamp: 1234567890,
+				Nonce:     42,
+			},
+			Transactions: nil,
+			Hash:         block.Hash{},
 		}
 		serialized := b.Serialize()
 		require.NotEmpty(t, serialized)
@@ -0,0 +1,71 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// HeaderVersion is the version of the header layout produced by this implementation.
+	HeaderVersion uint32 = 1
+	// HeaderSize is the length of a serialized header in bytes.
+	HeaderSize = 4 + 32 + 32 + 8 + 4 + 8
+)
+
+// ErrInvalidHeader is returned when a serialized header has the wrong length.
+var ErrInvalidHeader = errors.New("invalid header length")
+
+// Header holds the fields of a block that are covered by its hash.
+// The transactions are committed to through the Merkle root, so the hash can be computed
+// and verified without them.
+type Header struct {
+	// Version is the version of the header layout.
+	Version uint32
+	// PrevBlockHash is the hash of the previous block in the chain.
+	PrevBlockHash Hash
+	// MerkleRoot is the root of the Merkle tree of the block's transactions.
+	MerkleRoot Hash
+	// Timestamp is the time when the block was created.
+	Timestamp int64
+	// Bits is the compact encoding of the target the proof of work has to meet.
+	Bits uint32
+	// Nonce is the value varied by the proof of work to find a valid hash.
+	Nonce uint64
+}
+
+// Serialize encodes the header in a fixed big-endian layout of HeaderSize bytes:
+// version, previous block hash, Merkle root, timestamp, bits and nonce.
+func (h *Header) Serialize() []byte {
+	data := make([]byte, 0, HeaderSize)
+
+	data = binary.BigEndian.AppendUint32(data, h.Version)
+	data = append(data, h.PrevBlockHash[:]...)
+	data = append(data, h.MerkleRoot[:]...)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Timestamp))
+	data = binary.BigEndian.AppendUint32(data, h.Bits)
+	data = binary.BigEndian.AppendUint64(data, h.Nonce)
+
+	return data
+}
+
+// Deserialize decodes a header from its fixed layout.
+func (h *Header) Deserialize(d []byte) error {
+	if len(d) != HeaderSize {
+		return ErrInvalidHeader
+	}
+
+	h.Version = binary.BigEndian.Uint32(d[0:4])
+	copy(h.PrevBlockHash[:], d[4:36])
+	copy(h.MerkleRoot[:], d[36:68])
+	h.Timestamp = int64(binary.BigEndian.Uint64(d[68:76]))
+	h.Bits = binary.BigEndian.Uint32(d[76:80])
+	h.Nonce = binary.BigEndian.Uint64(d[80:88])
+
+	return nil
+}
+
+// Hash computes the hash of the serialized header.
+func (h *Header) Hash() Hash {
+	return sha256.Sum256(h.Serialize())
+}
@@ -0,0 +1,45 @@
+package block_test
+
+import (
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderSerializeDeserialize(t *testing.T) {
+	h := getBlock().Header
+	h.MerkleRoot = block.Hash{'r', 'o', 'o', 't'}
+	h.Bits = 0x1f00ffff
+
+	serialized := h.Serialize()
+	require.Len(t, serialized, block.HeaderSize)
+
+	var deserialized block.Header
+	require.NoError(t, deserialized.Deserialize(serialized))
+	assert.Equal(t, h, deserialized)
+
+	t.Run("invalid length", func(t *testing.T) {
+		var invalid block.Header
+		assert.ErrorIs(t, invalid.Deserialize(serialized[1:]), block.ErrInvalidHeader)
+	})
+}
+
+func TestHeaderHash(t *testing.T) {
+	b := getBlock()
+	b.MerkleRoot = b.ComputeMerkleRoot()
+	hash := b.Header.Hash()
+
+	t.Run("covers header fields", func(t *testing.T) {
+		modified := b.Header
+		modified.Nonce++
+		assert.NotEqual(t, hash, modified.Hash())
+	})
+
+	t.Run("commits to transactions only through the merkle root", func(t *testing.T) {
+		b.Transactions = append(b.Transactions, getTransaction("tx3"))
+		assert.Equal(t, hash, b.Header.Hash())
+		assert.NotEqual(t, b.MerkleRoot, b.ComputeMerkleRoot())
+	})
+}
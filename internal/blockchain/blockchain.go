@@ -1,35 +1,65 @@
 package blockchain
 
 import (
-	"bytes"
-	"crypto/ecdsa"
+	"context"
 	"errors"
 	"fmt"
 	"iter"
+	"maps"
+	"math"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mempool"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/utxo"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 )
 
-const (
-	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+var (
+	// ErrUnknownParent is returned when a block's previous block is not stored.
+	ErrUnknownParent = errors.New("unknown parent block")
+	// ErrStaleTip is returned when mining is aborted because another block became the tip.
+	ErrStaleTip = errors.New("tip changed while mining")
 )
 
+// MainGenesisData is the coinbase data of the genesis block of the main network.
+const MainGenesisData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+
 // Blockchain represents a blockchain structure that holds blocks and manages transactions.
 type Blockchain struct {
-	storage    block.Storage
-	powFactory ProofOfWorkFactory
-	wallets    *wallet.Collection
-	utxoSet    *utxo.UTXOSet
+	storage     Storage
+	powFactory  ProofOfWorkFactory
+	wallets     *wallet.Collection
+	utxoSet     *utxo.UTXOSet
+	mempool     *mempool.Mempool
+	checkpoints Checkpoints
+	genesisData string
+	lock        func(fn func()) // Taken by mining and sync while they use the blockchain, see SetLock
+
+	tipMu      sync.Mutex
+	tipChanged chan struct{} // Closed and replaced whenever the tip changes
+	tipMoved   bool          // Set on views when the tip was changed inside the storage transaction
 }
 
-// CreateBlockchain initializes a new blockchain with a genesis block.
+// CreateBlockchain initializes a new blockchain of the main network with a genesis block.
 // It requires a storage implementation to persist the blockchain data and a proof-of-work factory to create the genesis block.
-func CreateBlockchain(storage block.Storage, powFactory ProofOfWorkFactory, address string) error {
+// Mining the genesis block stops when the context is cancelled.
+// The genesis block, the tip and the UTXO set are stored in a single storage transaction.
+func CreateBlockchain(ctx context.Context, storage Storage, powFactory ProofOfWorkFactory, address string) error {
+	return CreateBlockchainWithGenesisData(ctx, storage, powFactory, address, MainGenesisData)
+}
+
+// CreateBlockchainWithGenesisData initializes a new blockchain whose genesis block has the given coinbase data.
+// The data tells networks apart, see SetGenesisData.
+func CreateBlockchainWithGenesisData(
+	ctx context.Context,
+	storage Storage,
+	powFactory ProofOfWorkFactory,
+	address, genesisData string,
+) error {
 	tip, err := storage.GetTip()
 	if err != nil {
 		return fmt.Errorf("failed to get tip of blockchain: %w", err)
@@ -39,27 +69,48 @@ func CreateBlockchain(storage block.Storage, powFactory ProofOfWorkFactory, addr
 		return errors.New("blockchain already exists")
 	}
 
-	cbtx, err := transaction.NewCoinbaseTX(address, genesisCoinbaseData)
+	cbtx, err := transaction.NewCoinbaseTX(address, genesisData)
 	if err != nil {
 		return fmt.Errorf("failed to create coinbase transaction: %w", err)
 	}
 
-	genesis := newGenesisBlock(cbtx, powFactory)
-
-	err = storage.AddBlock(*genesis)
+	genesis, err := newGenesisBlock(ctx, cbtx, powFactory)
 	if err != nil {
-		return fmt.Errorf("failed to add genesis block: %w", err)
+		return fmt.Errorf("failed to mine genesis block: %w", err)
 	}
 
-	err = storage.SetTip(genesis.Hash)
-	if err != nil {
-		return fmt.Errorf("failed to set tip of blockchain: %w", err)
-	}
+	return storage.Update(func(s Storage) error {
+		err := s.AddBlock(*genesis)
+		if err != nil {
+			return fmt.Errorf("failed to add genesis block: %w", err)
+		}
 
-	return nil
+		err = s.SetBlockMeta(genesis.Hash, block.Meta{Height: 0, Work: powFactory.Work(genesis)})
+		if err != nil {
+			return fmt.Errorf("failed to set genesis block meta: %w", err)
+		}
+
+		err = s.SetTip(genesis.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to set tip of blockchain: %w", err)
+		}
+
+		err = indexBlock(s, genesis, 0)
+		if err != nil {
+			return err
+		}
+
+		err = utxo.NewUTXOSet(s).Update(*genesis, 0)
+		if err != nil {
+			return fmt.Errorf("failed to update UTXO set: %w", err)
+		}
+
+		return nil
+	})
 }
 
 // LoadBlockchain loads an existing blockchain from storage.
+// It checks that the stored chain state is consistent and rebuilds the UTXO set if it does not match the tip.
 func LoadBlockchain(
 	storage Storage,
 	powFactory ProofOfWorkFactory,
@@ -74,37 +125,175 @@ func LoadBlockchain(
 		return nil, errors.New("blockchain does not exist")
 	}
 
+	bc := NewBlockchain(storage, powFactory, wallets)
+
+	err = bc.checkConsistency(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// checkConsistency checks that the tip is stored and that the block indexes and the UTXO set were last updated to it.
+// An index that is out of sync, e.g. one written by an older version, is rebuilt from the blocks.
+func (bc *Blockchain) checkConsistency(tip block.Hash) error {
+	tipBlock, tipMeta, err := bc.getBlockWithMeta(tip)
+	if err != nil {
+		return fmt.Errorf("tip of blockchain is not stored: %w", err)
+	}
+
+	if bc.checkIndexes(tipBlock, tipMeta) != nil {
+		err = bc.reindexBlocks()
+		if err != nil {
+			return fmt.Errorf("failed to recover block indexes: %w", err)
+		}
+	}
+
+	utxoTip, err := bc.utxoSet.Tip()
+	if err != nil {
+		return fmt.Errorf("failed to get tip of UTXO set: %w", err)
+	}
+
+	if utxoTip == tip {
+		return nil
+	}
+
+	err = bc.ReindexUTXOSet()
+	if err != nil {
+		return fmt.Errorf("failed to recover UTXO set: %w", err)
+	}
+
+	return nil
+}
+
+// atomically runs fn on a view of the blockchain whose storage changes are committed
+// in a single storage transaction if fn returns nil, and discarded otherwise.
+// Everyone waiting for the tip to change is woken up once the new tip is committed.
+func (bc *Blockchain) atomically(fn func(view *Blockchain) error) error {
+	var view *Blockchain
+
+	err := bc.storage.Update(func(s Storage) error {
+		view = &Blockchain{
+			storage:     s,
+			powFactory:  bc.powFactory,
+			wallets:     bc.wallets,
+			utxoSet:     utxo.NewUTXOSet(s),
+			mempool:     mempool.New(s),
+			checkpoints: bc.checkpoints,
+			genesisData: bc.genesisData,
+		}
+
+		return fn(view)
+	})
+	if err != nil {
+		return err
+	}
+
+	if view.tipMoved {
+		bc.tipMu.Lock()
+		close(bc.tipChanged)
+		bc.tipChanged = make(chan struct{})
+		bc.tipMu.Unlock()
+	}
+
+	return nil
+}
+
+// NewBlockchain wraps the given storage without requiring a genesis block to be present.
+// It is used by nodes that start with an empty storage and receive the chain from their peers.
+func NewBlockchain(
+	storage Storage,
+	powFactory ProofOfWorkFactory,
+	wallets *wallet.Collection,
+) *Blockchain {
 	return &Blockchain{
-		storage:    storage,
-		powFactory: powFactory,
-		wallets:    wallets,
-		utxoSet:    utxo.NewUTXOSet(storage),
-	}, nil
+		storage:     storage,
+		powFactory:  powFactory,
+		wallets:     wallets,
+		utxoSet:     utxo.NewUTXOSet(storage),
+		mempool:     mempool.New(storage),
+		checkpoints: maps.Clone(DefaultCheckpoints),
+		genesisData: MainGenesisData,
+		tipChanged:  make(chan struct{}),
+	}
+}
+
+// SetGenesisData sets the coinbase data the genesis block must have, MainGenesisData by default.
+// A genesis block with other data, e.g. one received from a peer of another network, is rejected.
+func (bc *Blockchain) SetGenesisData(data string) {
+	bc.genesisData = data
+}
+
+// SetLock sets the lock that mining and sync take while they read or change the blockchain.
+// They do not hold it while they search for a proof of work or wait for a source, so that others sharing
+// the blockchain, e.g. a node receiving blocks, keep going in the meantime. Callers of MineBlock, Mine
+// and Sync must not hold the lock, everything else that uses a shared blockchain must. There is none by default.
+func (bc *Blockchain) SetLock(lock func(fn func())) {
+	bc.lock = lock
+}
+
+// locked runs fn while holding the lock set by SetLock, if any.
+func (bc *Blockchain) locked(fn func() error) error {
+	if bc.lock == nil {
+		return fn()
+	}
+
+	var err error
+	bc.lock(func() {
+		err = fn()
+	})
+
+	return err
 }
 
 // newBlock creates a new block with the given transactions and previous block hash.
 func newBlock(
+	ctx context.Context,
 	transactions []*transaction.Tx,
 	prevBlockHash block.Hash,
 	powFactory ProofOfWorkFactory,
-) *block.Block {
+) (*block.Block, error) {
 	block := &block.Block{
-		Timestamp:     time.Now().Unix(),
-		Transactions:  transactions,
-		PrevBlockHash: prevBlockHash,
+		Header: block.Header{
+			Version:       block.HeaderVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+		},
+		Transactions: transactions,
 	}
+	block.MerkleRoot = block.ComputeMerkleRoot()
 
-	hash, powData := powFactory.Produce(block)
-
+	hash, err := powFactory.Produce(ctx, block)
+	if err != nil {
+		return nil, err
+	}
 	block.Hash = hash
-	block.PoW = powData
 
-	return block
+	return block, nil
 }
 
 // newGenesisBlock creates a new genesis block with the given coinbase transaction.
-func newGenesisBlock(coinbase *transaction.Tx, powFactory ProofOfWorkFactory) *block.Block {
-	return newBlock([]*transaction.Tx{coinbase}, block.Hash{}, powFactory)
+func newGenesisBlock(
+	ctx context.Context,
+	coinbase *transaction.Tx,
+	powFactory ProofOfWorkFactory,
+) (*block.Block, error) {
+	return newBlock(ctx, []*transaction.Tx{coinbase}, block.Hash{}, powFactory)
+}
+
+// GetBlockByHeight retrieves the block at the given height of the active chain.
+func (bc *Blockchain) GetBlockByHeight(height int) (*block.Block, error) {
+	if height < 0 || height > bc.GetBestHeight() {
+		return nil, fmt.Errorf("no block at height %d, the best height is %d", height, bc.GetBestHeight())
+	}
+
+	hash, err := bc.storage.GetBlockHashByHeight(height)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hash of block at height %d: %w", height, err)
+	}
+
+	return bc.storage.GetBlock(hash)
 }
 
 // GetBlock retrieves a block by its hash from the blockchain storage.
@@ -112,76 +301,235 @@ func (bc *Blockchain) GetBlock(hash block.Hash) (*block.Block, error) {
 	return bc.storage.GetBlock(hash)
 }
 
-// MineBlock mines a new block with the provided transactions and adds it to the blockchain.
-func (bc *Blockchain) MineBlock(transactions []*transaction.Tx) (*block.Block, error) {
-	for _, tx := range transactions {
-		ok, err := bc.verifyTransaction(tx)
+// HasBlock checks whether a block with the given hash is present in the storage.
+func (bc *Blockchain) HasBlock(hash block.Hash) bool {
+	b, err := bc.storage.GetBlock(hash)
+	return err == nil && b != nil
+}
+
+// GetBestHeight returns the height of the tip of the blockchain.
+// The genesis block has height 0, an empty blockchain has height -1.
+func (bc *Blockchain) GetBestHeight() int {
+	tip, err := bc.storage.GetTip()
+	if err != nil || tip == *new(block.Hash) {
+		return -1
+	}
+
+	meta, err := bc.storage.GetBlockMeta(tip)
+	if err != nil {
+		return -1
+	}
+
+	return meta.Height
+}
+
+// setTip stores the new tip. It is called on views, see atomically.
+func (bc *Blockchain) setTip(hash block.Hash) error {
+	err := bc.storage.SetTip(hash)
+	if err != nil {
+		return err
+	}
+
+	bc.tipMoved = true
+
+	return nil
+}
+
+// tipChangedSignal returns a channel that is closed when the tip changes.
+func (bc *Blockchain) tipChangedSignal() <-chan struct{} {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	return bc.tipChanged
+}
+
+// GetBlockHashes returns the hashes of all blocks in the blockchain, starting from the tip.
+func (bc *Blockchain) GetBlockHashes() []block.Hash {
+	var hashes []block.Hash
+	for _, b := range bc.Blocks() {
+		hashes = append(hashes, b.Hash)
+	}
+
+	return hashes
+}
+
+// AddBlock stores a block that was produced elsewhere, e.g. received from a peer.
+// The chain with the most accumulated work is chosen as the active chain:
+// if the block extends the tip it becomes the new tip, if it completes a side chain with more work
+// than the active chain, the blockchain is reorganized onto the side chain.
+// A block with an empty previous hash is accepted as the genesis block of an empty blockchain.
+// Storing the block and switching the tip happen atomically: if connecting any block fails, nothing is stored.
+func (bc *Blockchain) AddBlock(b *block.Block) error {
+	if bc.HasBlock(b.Hash) {
+		return nil
+	}
+
+	meta := block.Meta{Height: 0, Work: bc.powFactory.Work(b)}
+	if b.PrevBlockHash != *new(block.Hash) {
+		parentMeta, err := bc.storage.GetBlockMeta(b.PrevBlockHash)
 		if err != nil {
-			return nil, fmt.Errorf("failed to verify transaction %x: %w", tx.ID, err)
+			return fmt.Errorf("%w: %x", ErrUnknownParent, b.PrevBlockHash)
 		}
-		if !ok {
-			return nil, fmt.Errorf("invalid transaction: %x", tx.ID)
+
+		meta.Height = parentMeta.Height + 1
+		meta.Work.Add(meta.Work, parentMeta.Work)
+	}
+
+	// Blocks whose header was validated during sync were checked against the checkpoints already
+	if _, err := bc.storage.GetBlockMeta(b.Hash); err != nil {
+		err = bc.checkCheckpoints(bc.checkpoints, b.Hash, meta.Height)
+		if err != nil {
+			return err
 		}
 	}
 
-	tip, err := bc.storage.GetTip()
+	err := bc.checkBlock(b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tip of blockchain: %w", err)
+		return err
 	}
 
-	if tip == *new(block.Hash) {
-		return nil, errors.New("tip is empty")
+	return bc.atomically(func(view *Blockchain) error {
+		tip, err := view.storage.GetTip()
+		if err != nil {
+			return fmt.Errorf("failed to get tip of blockchain: %w", err)
+		}
+
+		if b.PrevBlockHash == *new(block.Hash) && tip != *new(block.Hash) {
+			return errors.New("blockchain already has a genesis block")
+		}
+
+		err = view.storeBlock(b, meta)
+		if err != nil {
+			return err
+		}
+
+		if !view.hasMoreWork(meta, tip) {
+			return nil // Side chain with less work, keep it in case it overtakes the active chain
+		}
+
+		if b.PrevBlockHash == tip {
+			return view.connectBlock(b)
+		}
+
+		return view.reorganize(b)
+	})
+}
+
+// MineBlock mines a new block with the provided transactions and adds it to the blockchain.
+// Mining is aborted when the context is cancelled or another block becomes the tip in the meantime,
+// in which case nothing is stored. The lock set by SetLock is not held while the proof of work is searched for.
+// The block, the new tip and the UTXO changes are committed in a single storage transaction.
+func (bc *Blockchain) MineBlock(ctx context.Context, transactions []*transaction.Tx) (*block.Block, error) {
+	var (
+		tipChanged <-chan struct{}
+		tip        block.Hash
+	)
+	err := bc.locked(func() error {
+		for _, tx := range transactions {
+			ok, err := bc.VerifyTransaction(tx)
+			if err != nil {
+				return fmt.Errorf("failed to verify transaction %x: %w", tx.ID, err)
+			}
+			if !ok {
+				return fmt.Errorf("invalid transaction: %x", tx.ID)
+			}
+		}
+
+		tipChanged = bc.tipChangedSignal()
+
+		var err error
+		tip, err = bc.storage.GetTip()
+		if err != nil {
+			return fmt.Errorf("failed to get tip of blockchain: %w", err)
+		}
+
+		if tip == *new(block.Hash) {
+			return errors.New("tip is empty")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	b := newBlock(transactions, tip, bc.powFactory)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel(ErrStaleTip)
+		case <-ctx.Done():
+		}
+	}()
 
-	err = bc.storage.AddBlock(*b)
+	b, err := newBlock(ctx, transactions, tip, bc.powFactory)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add block: %w", err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("mining aborted: %w", context.Cause(ctx))
+		}
+		return nil, fmt.Errorf("failed to mine block: %w", err)
 	}
 
-	err = bc.storage.SetTip(b.Hash)
+	err = bc.locked(func() error {
+		return bc.addMinedBlock(b, tip, tipChanged)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to set tip of blockchain: %w", err)
+		return nil, err
 	}
 
 	return b, nil
 }
 
-// findTransaction finds a transaction by its ID.
-func (bc *Blockchain) findTransaction(id transaction.TxID) (*transaction.Tx, error) {
-	for _, block := range bc.Blocks() {
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID[:], id[:]) {
-				return tx, nil
-			}
-		}
+// addMinedBlock checks a block mined on top of tip and makes it the new tip,
+// unless another block became the tip while it was mined.
+func (bc *Blockchain) addMinedBlock(b *block.Block, tip block.Hash, tipChanged <-chan struct{}) error {
+	select {
+	case <-tipChanged:
+		return fmt.Errorf("mining aborted: %w", ErrStaleTip)
+	default:
+	}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	tipMeta, err := bc.storage.GetBlockMeta(tip)
+	if err != nil {
+		return fmt.Errorf("failed to get tip block meta: %w", err)
 	}
 
-	return nil, errors.New("transaction not found")
-}
+	err = bc.checkBlock(b)
+	if err != nil {
+		return err
+	}
 
-// signTransaction signs inputs of a Transaction.
-func (bc *Blockchain) signTransaction(tx *transaction.Tx, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[transaction.TxID]*transaction.Tx)
+	err = bc.checkCheckpoints(bc.checkpoints, b.Hash, tipMeta.Height+1)
+	if err != nil {
+		return err
+	}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.findTransaction(vin.TxID)
+	meta := block.Meta{Height: tipMeta.Height + 1, Work: bc.powFactory.Work(b)}
+	meta.Work.Add(meta.Work, tipMeta.Work)
+
+	return bc.atomically(func(view *Blockchain) error {
+		currentTip, err := view.storage.GetTip()
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to get tip of blockchain: %w", err)
+		}
+
+		if currentTip != tip {
+			return fmt.Errorf("mining aborted: %w", ErrStaleTip)
+		}
+
+		err = view.storeBlock(b, meta)
+		if err != nil {
+			return err
 		}
-		prevTXs[prevTX.ID] = prevTX
-	}
 
-	tx.Sign(privKey, prevTXs)
+		return view.connectBlock(b)
+	})
 }
 
-// verifyTransaction verifies transaction input signatures.
-func (bc *Blockchain) verifyTransaction(tx *transaction.Tx) (bool, error) {
+// VerifyTransaction verifies transaction input signatures.
+func (bc *Blockchain) VerifyTransaction(tx *transaction.Tx) (bool, error) {
 	if tx.IsCoinbase() {
 		return true, nil // Coinbase transactions are always valid
 	}
@@ -199,7 +547,18 @@ func (bc *Blockchain) verifyTransaction(tx *transaction.Tx) (bool, error) {
 }
 
 // NewUTXOTransaction creates a new transaction with unspent transaction outputs (UTXO).
-func (bc *Blockchain) NewUTXOTransaction(fromAddress, toAddress string, amount int32) (*transaction.Tx, error) {
+// The fee is left unspent by the outputs, so it can be claimed by the miner of the block.
+// The change is sent to a new address of the change chain of HD wallets, back to the sender otherwise.
+// It fails with wallet.ErrLocked while the wallets are encrypted and locked,
+// and with wallet.ErrWatchOnly for an address that is only watched.
+func (bc *Blockchain) NewUTXOTransaction(
+	fromAddress, toAddress string,
+	amount, fee int32,
+) (*transaction.Tx, error) {
+	if fee < 0 {
+		return nil, fmt.Errorf("fee must not be negative: %d", fee)
+	}
+
 	wlt, err := bc.wallets.GetWallet(fromAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get wallet for address %s: %w", fromAddress, err)
@@ -210,33 +569,93 @@ func (bc *Blockchain) NewUTXOTransaction(fromAddress, toAddress string, amount i
 		return nil, fmt.Errorf("failed to hash public key: %w", err)
 	}
 
-	acc, validOutputs, err := bc.utxoSet.FindSpendableOutputIndexes(pubKeyHash, amount)
+	unsigned, err := bc.newUnsignedTransaction(pubKeyHash, toAddress, amount, fee, func() (string, error) {
+		return bc.wallets.ChangeAddress(fromAddress)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return unsigned.Sign(wlt)
+}
+
+// NewUnsignedTransaction creates a transaction like NewUTXOTransaction but leaves it unsigned,
+// so that it can be signed where the key of the sender is kept. The sender's wallet is not needed,
+// the sender may be a watch-only address or no address of the wallets at all. The change is sent back to the sender.
+func (bc *Blockchain) NewUnsignedTransaction(
+	fromAddress, toAddress string,
+	amount, fee int32,
+) (*transaction.UnsignedTx, error) {
+	if fee < 0 {
+		return nil, fmt.Errorf("fee must not be negative: %d", fee)
+	}
+
+	if err := wallet.ValidateAddress(fromAddress); err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", fromAddress, err)
+	}
+
+	pubKeyHash, err := wallet.GetHashFromAddress([]byte(fromAddress))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key hash from address: %w", err)
+	}
+
+	return bc.newUnsignedTransaction(pubKeyHash, toAddress, amount, fee, func() (string, error) {
+		return fromAddress, nil
+	})
+}
+
+// newUnsignedTransaction spends enough unspent outputs locked with the public key hash to pay the amount and the fee.
+// changeAddress is only called if there is change.
+func (bc *Blockchain) newUnsignedTransaction(
+	pubKeyHash []byte,
+	toAddress string,
+	amount, fee int32,
+	changeAddress func() (string, error),
+) (*transaction.UnsignedTx, error) {
+	pendingSpent, err := bc.mempool.SpentOutpoints()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outputs spent by pending transactions: %w", err)
+	}
+
+	if int64(amount)+int64(fee) > math.MaxInt32 {
+		return nil, fmt.Errorf("amount and fee add up to more than %d", math.MaxInt32)
+	}
+	total := amount + fee
+
+	acc, validOutputs, err := bc.utxoSet.FindSpendableOutputIndexes(pubKeyHash, total, pendingSpent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find spendable outputs: %w", err)
 	}
-	if acc < amount {
-		return nil, fmt.Errorf("not enough funds: %d < %d", acc, amount)
+	if acc < total {
+		return nil, fmt.Errorf("not enough funds: %d < %d", acc, total)
 	}
 
-	// Build a list of inputs
-	var inputs []transaction.TxInput
+	// Build a list of inputs, the public key is set when they are signed
+	var (
+		inputs  []transaction.TxInput
+		prevTXs []*transaction.Tx
+	)
 	for txID, outs := range validOutputs {
+		prevTx, err := bc.findTransaction(txID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find previous transaction %x: %w", txID, err)
+		}
+		prevTXs = append(prevTXs, prevTx)
+
 		for _, out := range outs {
-			input := transaction.TxInput{
-				TxID:      txID,
-				Vout:      out,
-				Signature: nil, // This will be filled later with the signature
-				PubKey:    wlt.PublicKey,
-			}
-			inputs = append(inputs, input)
+			inputs = append(inputs, transaction.TxInput{TxID: txID, Vout: out})
 		}
 	}
 
 	// Build a list of outputs
 	var outputs []transaction.TxOutput
 	outputs = append(outputs, transaction.NewTxOutput(amount, toAddress))
-	if acc > amount {
-		outputs = append(outputs, transaction.NewTxOutput(acc-amount, fromAddress)) // The change
+	if acc > total {
+		change, err := changeAddress()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get change address: %w", err)
+		}
+		outputs = append(outputs, transaction.NewTxOutput(acc-total, change)) // The change
 	}
 
 	tx := transaction.Tx{
@@ -246,27 +665,25 @@ func (bc *Blockchain) NewUTXOTransaction(fromAddress, toAddress string, amount i
 	}
 	tx.ID = tx.Hash()
 
-	bc.signTransaction(&tx, wlt.PrivateKey)
-
-	return &tx, nil
+	return &transaction.UnsignedTx{Tx: &tx, PrevTXs: prevTXs}, nil
 }
 
-// findUnspentTxOutputs returns a list of transaction outputs.
-func (bc *Blockchain) findUnspentTxOutputs() map[transaction.TxID][]transaction.TxOutput {
-	unspentTxOs := make(map[transaction.TxID][]transaction.TxOutput)
-	spentTxOs := make(map[transaction.TxID][]int) // Map of transaction ID to slice of output indexes
-
-	for _, b := range bc.Blocks() {
-		for _, tx := range b.Transactions {
-			for outID, out := range tx.Vout {
-				// Was the output spent?
-				if outputIDs, ok := spentTxOs[tx.ID]; ok {
-					if slices.Contains(outputIDs, outID) {
-						continue // Skip this output if it was spent
-					}
+// findUnspentTxOutputs collects the unspent outputs of the active chain by walking it back from the tip.
+func (bc *Blockchain) findUnspentTxOutputs() map[transaction.Outpoint]utxo.Entry {
+	unspent := make(map[transaction.Outpoint]utxo.Entry)
+	spent := make(map[transaction.Outpoint]struct{})
+
+	tipHeight := bc.GetBestHeight()
+	for i, b := range bc.Blocks() {
+		// Later transactions may spend outputs of earlier ones in the same block
+		for _, tx := range slices.Backward(b.Transactions) {
+			for outIdx, out := range tx.Vout {
+				outpoint := transaction.Outpoint{TxID: tx.ID, Vout: outIdx}
+				if _, ok := spent[outpoint]; ok {
+					continue // Skip this output if it was spent
 				}
 
-				unspentTxOs[tx.ID] = append(unspentTxOs[tx.ID], out)
+				unspent[outpoint] = utxo.Entry{Output: out, Height: tipHeight - i, Coinbase: tx.IsCoinbase()}
 			}
 
 			if tx.IsCoinbase() {
@@ -274,30 +691,39 @@ func (bc *Blockchain) findUnspentTxOutputs() map[transaction.TxID][]transaction.
 			}
 
 			for _, in := range tx.Vin {
-				spentTxOs[in.TxID] = append(spentTxOs[in.TxID], in.Vout)
+				spent[in.Outpoint()] = struct{}{}
 			}
 		}
 	}
 
-	return unspentTxOs
+	return unspent
 }
 
+// ReindexUTXOSet rebuilds the UTXO set from the blocks of the active chain.
 func (bc *Blockchain) ReindexUTXOSet() error {
-	utxoSet := bc.findUnspentTxOutputs()
-	if err := bc.utxoSet.Set(utxoSet); err != nil {
-		return fmt.Errorf("failed to set UTXO set: %w", err)
-	}
-	return nil
-}
+	return bc.atomically(func(view *Blockchain) error {
+		tip, err := view.storage.GetTip()
+		if err != nil {
+			return fmt.Errorf("failed to get tip of blockchain: %w", err)
+		}
+
+		if err := view.utxoSet.Set(view.findUnspentTxOutputs(), tip); err != nil {
+			return fmt.Errorf("failed to set UTXO set: %w", err)
+		}
 
-func (bc *Blockchain) Update(b block.Block) error {
-	return bc.utxoSet.Update(b)
+		return nil
+	})
 }
 
 func (bc *Blockchain) FindUnspentTxOutputs(pubKeyHash []byte) ([]transaction.TxOutput, error) {
 	return bc.utxoSet.FindUnspentTxOutputs(pubKeyHash)
 }
 
+// ListUnspent returns the unspent outputs locked with the public key hash by their outpoint.
+func (bc *Blockchain) ListUnspent(pubKeyHash []byte) (map[transaction.Outpoint]utxo.Entry, error) {
+	return bc.utxoSet.FindUnspent(pubKeyHash)
+}
+
 type blockchainIterator struct {
 	currentIndex int
 	currentHash  block.Hash
@@ -0,0 +1,522 @@
+package blockchain_test
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingPoWFactory never finds a proof of work, it waits until mining is aborted.
+type blockingPoWFactory struct {
+	started chan struct{}
+}
+
+func (f blockingPoWFactory) Produce(ctx context.Context, _ *block.Block) (block.Hash, error) {
+	f.started <- struct{}{}
+	<-ctx.Done()
+
+	return block.Hash{}, ctx.Err()
+}
+
+func (blockingPoWFactory) Validate(_ *block.Block) bool { return true }
+func (blockingPoWFactory) Work(_ *block.Block) *big.Int { return big.NewInt(1) }
+
+func TestMineBlockAborts(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, mock.NewPoWFactory(), address))
+
+	pow := blockingPoWFactory{started: make(chan struct{}, 1)}
+	bc, err := blockchain.LoadBlockchain(storage, pow, wallets)
+	require.NoError(t, err)
+	require.NoError(t, bc.ReindexUTXOSet())
+
+	genesis := bc.GetBlockHashes()[0]
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+
+		errs := make(chan error)
+		go func() {
+			_, err := bc.MineBlock(ctx, []*transaction.Tx{coinbase(t, address, "cancelled")})
+			errs <- err
+		}()
+
+		<-pow.started
+		cancel()
+
+		require.ErrorIs(t, <-errs, context.Canceled)
+		assert.Equal(t, []block.Hash{genesis}, bc.GetBlockHashes())
+	})
+
+	t.Run("new tip", func(t *testing.T) {
+		errs := make(chan error)
+		go func() {
+			_, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address, "stale")})
+			errs <- err
+		}()
+
+		<-pow.started
+		competing := newTestBlock(genesis, block.Hash{'n'}, coinbase(t, address, "competing"))
+		require.NoError(t, bc.AddBlock(competing))
+
+		require.ErrorIs(t, <-errs, blockchain.ErrStaleTip)
+		assert.Equal(t, []block.Hash{competing.Hash, genesis}, bc.GetBlockHashes())
+	})
+
+	t.Run("new tip while the lock is not held", func(t *testing.T) {
+		var mu sync.Mutex
+		bc.SetLock(func(fn func()) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn()
+		})
+		t.Cleanup(func() { bc.SetLock(nil) })
+
+		tip := bc.GetBlockHashes()[0]
+
+		errs := make(chan error)
+		go func() {
+			_, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address, "unlocked")})
+			errs <- err
+		}()
+
+		<-pow.started
+		require.True(t, mu.TryLock(), "the lock is held while searching for the proof of work")
+		competing := newTestBlock(tip, block.Hash{'u'}, coinbase(t, address, "competing while unlocked"))
+		require.NoError(t, bc.AddBlock(competing))
+		mu.Unlock()
+
+		require.ErrorIs(t, <-errs, blockchain.ErrStaleTip)
+		assert.Equal(t, competing.Hash, bc.GetBlockHashes()[0])
+	})
+}
+
+func TestLoadBlockchainRecoversUTXOSet(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	tx, err := bc.NewUTXOTransaction(address1, address2, 4, 0)
+	require.NoError(t, err)
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "mined"), tx})
+	require.NoError(t, err)
+
+	// Simulate a UTXO set that was left behind the tip
+	genesis := bc.GetBlockHashes()[1]
+	for vout := range tx.Vout {
+		require.NoError(t, storage.DeleteUTXO(transaction.Outpoint{TxID: tx.ID, Vout: vout}))
+	}
+	require.NoError(t, storage.SetUTXOTip(genesis))
+
+	bc, err = blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	utxoTip, err := storage.GetUTXOTip()
+	require.NoError(t, err)
+	assert.Equal(t, bc.GetBlockHashes()[0], utxoTip)
+	assert.Equal(t, 16, balance(t, bc, address1))
+	assert.Equal(t, 4, balance(t, bc, address2))
+}
+
+func TestSpendOutputAfterEarlierOutputIsSpent(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+	miner, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	// Output 0 pays wallet 2, output 1 is the change of wallet 1
+	tx1, err := bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.NoError(t, err)
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, miner, "b1"), tx1})
+	require.NoError(t, err)
+
+	// Spending output 0 must not move the change to index 0
+	tx2, err := bc.NewUTXOTransaction(address2, address1, 3, 0)
+	require.NoError(t, err)
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, miner, "b2"), tx2})
+	require.NoError(t, err)
+
+	tx3, err := bc.NewUTXOTransaction(address1, address2, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, spentVout(t, tx3, tx1.ID))
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, miner, "b3"), tx3})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, balance(t, bc, address1))
+	assert.Equal(t, 10, balance(t, bc, address2))
+
+	require.NoError(t, bc.ReindexUTXOSet())
+	assert.Equal(t, 0, balance(t, bc, address1))
+	assert.Equal(t, 10, balance(t, bc, address2))
+}
+
+// spentVout returns the index of the output of txID that tx spends.
+func spentVout(t *testing.T, tx *transaction.Tx, txID transaction.TxID) int {
+	t.Helper()
+
+	for _, in := range tx.Vin {
+		if in.TxID == txID {
+			return in.Vout
+		}
+	}
+
+	require.Failf(t, "output not spent", "transaction %x spends no output of %x", tx.ID, txID)
+
+	return -1
+}
+
+func TestGetBlockByHeight(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	b1, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address, "b1")})
+	require.NoError(t, err)
+	b2, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address, "b2")})
+	require.NoError(t, err)
+
+	hashes := bc.GetBlockHashes()
+	for height, hash := range []block.Hash{hashes[2], b1.Hash, b2.Hash} {
+		b, err := bc.GetBlockByHeight(height)
+		require.NoError(t, err)
+		assert.Equal(t, hash, b.Hash)
+	}
+
+	t.Run("out of range", func(t *testing.T) {
+		_, err := bc.GetBlockByHeight(3)
+		require.Error(t, err)
+		_, err = bc.GetBlockByHeight(-1)
+		require.Error(t, err)
+	})
+
+	t.Run("side chain", func(t *testing.T) {
+		s2 := newTestBlock(b1.Hash, block.Hash{'s', '2'}, coinbase(t, address, "s2"))
+		s3 := newTestBlock(s2.Hash, block.Hash{'s', '3'}, coinbase(t, address, "s3"))
+		require.NoError(t, bc.AddBlock(s2))
+		require.NoError(t, bc.AddBlock(s3))
+
+		b, err := bc.GetBlockByHeight(2)
+		require.NoError(t, err)
+		assert.Equal(t, s2.Hash, b.Hash)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		_, err := bc.Rollback(b1.Hash)
+		require.NoError(t, err)
+
+		_, err = bc.GetBlockByHeight(2)
+		require.Error(t, err)
+		_, err = storage.GetBlockHashByHeight(3)
+		require.Error(t, err)
+	})
+
+	t.Run("recovery", func(t *testing.T) {
+		require.NoError(t, storage.DeleteBlockHashByHeight(1))
+
+		bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+		require.NoError(t, err)
+
+		b, err := bc.GetBlockByHeight(1)
+		require.NoError(t, err)
+		assert.Equal(t, b1.Hash, b.Hash)
+	})
+}
+
+func TestGetTransaction(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	genesis := bc.GetBlockHashes()[0]
+
+	tx, err := bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.NoError(t, err)
+	b1, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "b1"), tx})
+	require.NoError(t, err)
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "b2")})
+	require.NoError(t, err)
+
+	t.Run("confirmed", func(t *testing.T) {
+		info, err := bc.GetTransaction(tx.ID)
+		require.NoError(t, err)
+		assert.Equal(t, tx.ID, info.Tx.ID)
+		assert.Equal(t, b1.Hash, info.BlockHash)
+		assert.Equal(t, 2, info.Confirmations)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := bc.GetTransaction(transaction.TxID{'x'})
+		require.Error(t, err)
+	})
+
+	t.Run("pending after rollback", func(t *testing.T) {
+		_, err := bc.Rollback(genesis)
+		require.NoError(t, err)
+
+		info, err := bc.GetTransaction(tx.ID)
+		require.NoError(t, err)
+		assert.Equal(t, tx.ID, info.Tx.ID)
+		assert.Equal(t, block.Hash{}, info.BlockHash)
+		assert.Equal(t, 0, info.Confirmations)
+
+		_, err = storage.GetTxLocation(tx.ID)
+		require.Error(t, err)
+	})
+
+	t.Run("recovery", func(t *testing.T) {
+		genesisBlock, err := bc.GetBlock(genesis)
+		require.NoError(t, err)
+		cbID := genesisBlock.Transactions[0].ID
+		require.NoError(t, storage.DeleteTxLocation(cbID))
+
+		bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+		require.NoError(t, err)
+
+		info, err := bc.GetTransaction(cbID)
+		require.NoError(t, err)
+		assert.Equal(t, genesis, info.BlockHash)
+		assert.Equal(t, 1, info.Confirmations)
+	})
+}
+
+func TestNewUTXOTransactionLockedWallet(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	require.NoError(t, wallets.Encrypt("passphrase"))
+
+	_, err = bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.ErrorIs(t, err, wallet.ErrLocked)
+
+	require.NoError(t, wallets.Unlock("passphrase", 0))
+	_, err = bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.NoError(t, err)
+}
+
+func TestNewUTXOTransactionWatchOnly(t *testing.T) {
+	storageA := mock.NewStorage()
+	address, err := wallet.NewCollection(storageA).AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storageA, powFactory, address))
+
+	storageB := mock.NewStorage()
+	walletsB := wallet.NewCollection(storageB)
+	bcB := blockchain.NewBlockchain(storageB, powFactory, walletsB)
+	_, err = bcB.Sync(t.Context(), blockchain.NewStorageSource(storageA))
+	require.NoError(t, err)
+
+	require.NoError(t, walletsB.AddWatchAddress(address))
+	assert.Equal(t, 10, balance(t, bcB, address))
+
+	_, err = bcB.NewUTXOTransaction(address, address, 3, 0)
+	require.ErrorIs(t, err, wallet.ErrWatchOnly)
+}
+
+func TestNewUnsignedTransaction(t *testing.T) {
+	coldStorage := mock.NewStorage()
+	coldWallets := wallet.NewCollection(coldStorage)
+	cold, err := coldWallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), coldStorage, powFactory, cold))
+
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	recipient, err := wallets.AddWallet()
+	require.NoError(t, err)
+	bc := blockchain.NewBlockchain(storage, powFactory, wallets)
+	_, err = bc.Sync(t.Context(), blockchain.NewStorageSource(coldStorage))
+	require.NoError(t, err)
+
+	_, err = bc.NewUnsignedTransaction(cold, recipient, 11, 0)
+	require.ErrorContains(t, err, "not enough funds")
+	_, err = bc.NewUnsignedTransaction(cold, recipient, math.MaxInt32, 1)
+	require.ErrorContains(t, err, "add up to more than")
+
+	unsigned, err := bc.NewUnsignedTransaction(cold, recipient, 6, 1)
+	require.NoError(t, err)
+	require.ErrorIs(t, bc.SubmitTransaction(unsigned.Tx), blockchain.ErrInvalidTransaction)
+
+	// Only the serialized transaction is moved to the wallet that has the key
+	var received transaction.UnsignedTx
+	require.NoError(t, received.Deserialize(unsigned.Serialize()))
+	fee, err := received.Fee()
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fee)
+
+	_, err = blockchain.SignTransaction(wallets, &received)
+	require.ErrorIs(t, err, wallet.ErrWalletNotFound)
+	signed, err := blockchain.SignTransaction(coldWallets, &received)
+	require.NoError(t, err)
+
+	require.NoError(t, bc.SubmitTransaction(signed))
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, recipient, "b1"), signed})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, balance(t, bc, cold)) // The change goes back to the sender
+	assert.Equal(t, 16, balance(t, bc, recipient))
+}
+
+func TestRestoreWallets(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	storageA := mock.NewStorage()
+	walletsA := wallet.NewCollection(storageA)
+	require.NoError(t, walletsA.SetSeed(mnemonic))
+
+	receive := make([]string, 3)
+	for i := range receive {
+		receive[i], err = walletsA.AddWallet()
+		require.NoError(t, err)
+	}
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storageA, powFactory, receive[0]))
+	bcA, err := blockchain.LoadBlockchain(storageA, powFactory, walletsA)
+	require.NoError(t, err)
+
+	// The second receive address stays unused, the change goes to the first change address
+	tx, err := bcA.NewUTXOTransaction(receive[0], receive[2], 3, 0)
+	require.NoError(t, err)
+	require.Len(t, tx.Vout, 2)
+	change := wallet.AddressFromPubKeyHash(tx.Vout[1].PubKeyHash)
+	assert.NotContains(t, receive, change)
+	_, err = bcA.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, receive[2], "a1"), tx})
+	require.NoError(t, err)
+
+	restore := func(t *testing.T, gapLimit int) (*blockchain.Blockchain, *wallet.Collection, []string) {
+		t.Helper()
+
+		storage := mock.NewStorage()
+		wallets := wallet.NewCollection(storage)
+		bc := blockchain.NewBlockchain(storage, powFactory, wallets)
+		_, err := bc.Sync(t.Context(), blockchain.NewStorageSource(storageA))
+		require.NoError(t, err)
+
+		addresses, err := bc.RestoreWallets(mnemonic, gapLimit)
+		require.NoError(t, err)
+
+		return bc, wallets, addresses
+	}
+
+	t.Run("gap limit", func(t *testing.T) {
+		bcB, walletsB, addresses := restore(t, wallet.DefaultGapLimit)
+		assert.Equal(t, append(slices.Clone(receive), change), addresses)
+
+		assert.Equal(t, 13, balance(t, bcB, receive[2]))
+		assert.Equal(t, 7, balance(t, bcB, change))
+
+		_, err := bcB.NewUTXOTransaction(change, receive[0], 7, 0)
+		require.NoError(t, err)
+
+		// New addresses continue after the restored ones
+		next, err := walletsB.AddWallet()
+		require.NoError(t, err)
+		expected, err := walletsA.AddWallet()
+		require.NoError(t, err)
+		assert.Equal(t, expected, next)
+
+		_, err = bcB.RestoreWallets(mnemonic, wallet.DefaultGapLimit)
+		require.ErrorIs(t, err, wallet.ErrHDChainExists)
+	})
+
+	t.Run("gap reached", func(t *testing.T) {
+		_, _, addresses := restore(t, 1)
+		assert.Equal(t, []string{receive[0], change}, addresses)
+	})
+}
+
+func TestImportWallet(t *testing.T) {
+	storageA := mock.NewStorage()
+	walletsA := wallet.NewCollection(storageA)
+	address, err := walletsA.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storageA, powFactory, address))
+
+	wlt, err := walletsA.GetWallet(address)
+	require.NoError(t, err)
+	key, err := wlt.EncodePrivateKey(wallet.KeyFormatBase58)
+	require.NoError(t, err)
+
+	storageB := mock.NewStorage()
+	bcB := blockchain.NewBlockchain(storageB, powFactory, wallet.NewCollection(storageB))
+	_, err = bcB.Sync(t.Context(), blockchain.NewStorageSource(storageA))
+	require.NoError(t, err)
+
+	imported, err := wallet.DecodePrivateKey(key)
+	require.NoError(t, err)
+	importedAddress, unspent, err := bcB.ImportWallet(imported)
+	require.NoError(t, err)
+
+	assert.Equal(t, address, importedAddress)
+	require.Len(t, unspent, 1)
+
+	// The outputs paid to the key before the import can be spent at once
+	_, err = bcB.NewUTXOTransaction(address, address, 10, 0)
+	require.NoError(t, err)
+
+	_, _, err = bcB.ImportWallet(imported)
+	require.ErrorIs(t, err, wallet.ErrWalletExists)
+}
@@ -0,0 +1,144 @@
+package hashcash
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+)
+
+const (
+	maxAdjustment = 4 // Maximum factor the target may change by in one retarget
+)
+
+// Chain gives access to the ancestors of a block, which determine the difficulty the block must meet.
+type Chain interface {
+	// GetHeader retrieves the header of a block by its hash.
+	// The ancestors only need their headers stored, so that headers can be validated before their blocks are received.
+	GetHeader(hash block.Hash) (*block.Header, error)
+	// GetBlockMeta retrieves the chain metadata of a stored block.
+	GetBlockMeta(hash block.Hash) (*block.Meta, error)
+}
+
+// Params configures the difficulty of the proof of work and how it is produced.
+type Params struct {
+	// InitialBits is the number of leading zero bits the genesis block hash must have.
+	// The difficulty never drops below it.
+	InitialBits uint32
+	// RetargetInterval is the number of blocks after which the difficulty is recomputed, at least 2.
+	RetargetInterval int
+	// TargetBlockTime is the desired time between two blocks, at least a second.
+	TargetBlockTime time.Duration
+	// Workers is the number of goroutines searching for a nonce, runtime.NumCPU() if zero.
+	Workers int
+}
+
+// DefaultParams are the difficulty parameters used by New.
+var DefaultParams = Params{ //nolint:gochecknoglobals // Default configuration
+	InitialBits:      18,
+	RetargetInterval: 10,
+	TargetBlockTime:  10 * time.Second,
+}
+
+// Validate checks that the parameters can be used to compute difficulties.
+// The retarget divides by the number of whole seconds in an interval, which must not be zero.
+func (p Params) Validate() error {
+	if p.InitialBits >= sha256Length {
+		return fmt.Errorf("initial bits %d must be less than %d", p.InitialBits, sha256Length)
+	}
+	if p.RetargetInterval < 2 { //nolint:mnd // An interval spans at least one block time
+		return fmt.Errorf("retarget interval %d must be at least 2", p.RetargetInterval)
+	}
+	if p.TargetBlockTime < time.Second {
+		return errors.New("target block time must be at least a second")
+	}
+
+	return nil
+}
+
+// expectedBits returns the compact target the block must claim.
+// The genesis block uses the initial target. Every RetargetInterval blocks the target is recomputed
+// from the time the previous interval took, all other blocks keep the target of their parent.
+func (pow *hashCashPoW) expectedBits(b *block.Block) (uint32, error) {
+	if b.PrevBlockHash == *new(block.Hash) {
+		return targetToCompact(pow.maxTarget), nil
+	}
+
+	parent, err := pow.chain.GetHeader(b.PrevBlockHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get parent header %x: %w", b.PrevBlockHash, err)
+	}
+
+	parentMeta, err := pow.chain.GetBlockMeta(b.PrevBlockHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get parent block meta %x: %w", b.PrevBlockHash, err)
+	}
+
+	if (parentMeta.Height+1)%pow.params.RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+	for range pow.params.RetargetInterval - 1 {
+		first, err = pow.chain.GetHeader(first.PrevBlockHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get ancestor header: %w", err)
+		}
+	}
+
+	return pow.retarget(parent.Bits, parent.Timestamp-first.Timestamp), nil
+}
+
+// retarget scales the target by the ratio of the actual to the expected duration of an interval.
+// The interval spans RetargetInterval blocks, so RetargetInterval-1 block times.
+func (pow *hashCashPoW) retarget(bits uint32, actualTimespan int64) uint32 {
+	expectedTimespan := int64(pow.params.RetargetInterval-1) * int64(pow.params.TargetBlockTime/time.Second)
+
+	actualTimespan = max(actualTimespan, expectedTimespan/maxAdjustment, 1)
+	actualTimespan = min(actualTimespan, expectedTimespan*maxAdjustment)
+
+	target := compactToTarget(bits)
+	target.Mul(target, big.NewInt(actualTimespan))
+	target.Div(target, big.NewInt(expectedTimespan))
+
+	if target.Cmp(pow.maxTarget) > 0 {
+		target.Set(pow.maxTarget)
+	}
+
+	return targetToCompact(target)
+}
+
+// compactToTarget decodes a target from its compact form: the high byte is the length of the target in bytes,
+// the lower three bytes are its most significant bytes.
+func compactToTarget(bits uint32) *big.Int {
+	size := bits >> 24                             //nolint:mnd // High byte holds the size
+	target := big.NewInt(int64(bits & 0x007fffff)) //nolint:mnd // Lower bytes hold the mantissa
+
+	if size <= 3 { //nolint:mnd // Mantissa is three bytes long
+		return target.Rsh(target, uint(8*(3-size)))
+	}
+
+	return target.Lsh(target, uint(8*(size-3)))
+}
+
+// targetToCompact encodes a target in its compact form, keeping its three most significant bytes.
+func targetToCompact(target *big.Int) uint32 {
+	size := uint32((target.BitLen() + 7) / 8) //nolint:mnd // Length in bytes
+
+	var mantissa uint32
+	if size <= 3 { //nolint:mnd // Mantissa is three bytes long
+		mantissa = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+
+	// The high bit of the mantissa is a sign bit, so it is moved into the next byte
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+
+	return size<<24 | mantissa
+}
@@ -1,23 +1,22 @@
 package hashcash
 
 import (
-	"crypto/sha256"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
-	"github.com/jleipus/learn-blockchain/internal/utils"
 )
 
 const (
-	targetBits   uint32 = 18 // Target difficulty bits
-	maxNonce     uint64 = math.MaxUint64
-	sha256Length uint32 = 256
-	nonceLength  int    = 8
+	sha256Length      uint32 = 256
+	stopCheckInterval uint64 = 1024 // Number of hashes a worker computes between checks whether to stop
 )
 
 var verbose = false //nolint:gochecknoglobals // Verbose flag for printing mining progress
@@ -27,15 +26,31 @@ func SetVerbose() {
 }
 
 type hashCashPoW struct {
-	target *big.Int
+	chain     Chain
+	params    Params
+	maxTarget *big.Int
 }
 
-func New() blockchain.ProofOfWorkFactory {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(sha256Length-targetBits))
+// New creates a proof of work factory with the default difficulty parameters.
+// The chain is used to look up the ancestors of a block when computing its difficulty.
+func New(chain Chain) blockchain.ProofOfWorkFactory {
+	return NewWithParams(chain, DefaultParams)
+}
+
+// NewWithParams creates a proof of work factory with the given difficulty parameters.
+// It panics if the parameters are invalid, see Params.Validate.
+func NewWithParams(chain Chain, params Params) blockchain.ProofOfWorkFactory {
+	if err := params.Validate(); err != nil {
+		panic(fmt.Errorf("invalid proof of work parameters: %w", err))
+	}
+
+	maxTarget := big.NewInt(1)
+	maxTarget.Lsh(maxTarget, uint(sha256Length-params.InitialBits))
 
 	pow := &hashCashPoW{
-		target: target,
+		chain:     chain,
+		params:    params,
+		maxTarget: maxTarget,
 	}
 
 	return pow
@@ -43,84 +58,152 @@ func New() blockchain.ProofOfWorkFactory {
 
 var TimeNow = time.Now // Allow mocking time for testing
 
-func (pow *hashCashPoW) Produce(b *block.Block) (block.Hash, []byte) {
-	var hashInt big.Int
-	var hash block.Hash
-	nonce := uint64(0)
+var MaxNonce = uint64(math.MaxUint64) //nolint:gochecknoglobals // Allow limiting the nonce space for testing
+
+// Produce sets the difficulty of the block and searches for a nonce that makes its header hash meet the target.
+// The nonce space is split between the workers. If no nonce in it is valid,
+// the timestamp is bumped to get a new header and the search starts over.
+// The search stops when the context is cancelled.
+func (pow *hashCashPoW) Produce(ctx context.Context, b *block.Block) (block.Hash, error) {
+	bits, err := pow.expectedBits(b)
+	if err != nil {
+		return block.Hash{}, fmt.Errorf("failed to compute difficulty: %w", err)
+	}
+	b.Bits = bits
+	target := compactToTarget(bits)
 
 	start := TimeNow()
 
-	var err error
-	for nonce < maxNonce {
-		hash, err = calculateHash(b, nonce)
-		if err != nil {
-			panic(err)
+	for {
+		header, hash, ok := pow.search(ctx, b.Header, target)
+		if err := ctx.Err(); err != nil && !ok {
+			return block.Hash{}, err
 		}
 
-		if verbose {
-			//nolint:forbidigo // Print the hash and elapsed time
-			fmt.Printf("\rMining: %x (%s)", hash, time.Since(start))
+		if ok {
+			if verbose {
+				//nolint:forbidigo // Print the hash and elapsed time
+				fmt.Printf("Completed mining: %x (%s)\n", hash, time.Since(start))
+			}
+
+			b.Header = header
+			return hash, nil
 		}
 
-		hashInt.SetBytes(hash[:])
+		b.Timestamp++
+	}
+}
+
+// search looks for a nonce that makes the header hash meet the target, each worker in its own part of the nonce space.
+// The first worker to find one stops the others, cancelling the context stops all of them.
+func (pow *hashCashPoW) search(
+	ctx context.Context,
+	header block.Header,
+	target *big.Int,
+) (block.Header, block.Hash, bool) {
+	workers := uint64(pow.params.Workers)
+	if workers == 0 {
+		workers = uint64(runtime.NumCPU())
+	}
+	if MaxNonce < workers {
+		workers = MaxNonce + 1
+	}
+	chunk := MaxNonce/workers + 1
 
-		if hashInt.Cmp(pow.target) == -1 {
+	type result struct {
+		header block.Header
+		hash   block.Hash
+	}
+
+	var (
+		found   atomic.Bool
+		wg      sync.WaitGroup
+		results = make(chan result, workers)
+	)
+
+	for i := range workers {
+		first := i * chunk
+		last := first + chunk - 1
+		if i == workers-1 || last > MaxNonce {
+			last = MaxNonce
+		}
+		if first > last {
 			break
 		}
 
-		nonce++
-	}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-	if verbose {
-		//nolint:forbidigo // Print the hash and elapsed time
-		fmt.Printf("\rCompleted mining: %x (%s)\n", hash, time.Since(start))
+			header, hash, ok := searchRange(ctx, header, target, first, last, &found)
+			if ok {
+				results <- result{header, hash}
+			}
+		}()
 	}
 
-	powData := make([]byte, nonceLength)
-	binary.BigEndian.PutUint64(powData, nonce)
+	wg.Wait()
+	close(results)
+
+	r, ok := <-results
 
-	return hash, powData
+	return r.header, r.hash, ok
 }
 
-func (pow *hashCashPoW) Validate(block *block.Block) bool {
+// searchRange tries the nonces from first to last, until one is valid, another worker found one
+// or the context is cancelled.
+func searchRange(
+	ctx context.Context,
+	header block.Header,
+	target *big.Int,
+	first, last uint64,
+	found *atomic.Bool,
+) (block.Header, block.Hash, bool) {
 	var hashInt big.Int
 
-	powData := block.PoW
-
-	if len(powData) < nonceLength {
-		panic(fmt.Sprintf("Invalid PoW length: expected %d, got %d", nonceLength, len(powData)))
-	}
-	nonce := binary.BigEndian.Uint64(powData[:nonceLength])
+	for nonce := first; ; nonce++ {
+		if (nonce-first)%stopCheckInterval == 0 && (found.Load() || ctx.Err() != nil) {
+			return block.Header{}, block.Hash{}, false
+		}
 
-	hash, err := calculateHash(block, nonce)
-	if err != nil {
-		panic(err)
-	}
+		header.Nonce = nonce
+		hash := header.Hash()
+		hashInt.SetBytes(hash[:])
 
-	hashInt.SetBytes(hash[:])
+		if hashInt.Cmp(target) == -1 {
+			found.Store(true)
+			return header, hash, true
+		}
 
-	return hashInt.Cmp(pow.target) == -1
+		if nonce == last {
+			return block.Header{}, block.Hash{}, false
+		}
+	}
 }
 
-func calculateHash(b *block.Block, nonce uint64) (block.Hash, error) {
-	var data []byte
-
-	txHash := b.HashTransactions()
+// Validate checks that the block claims the difficulty expected from its ancestors
+// and that its header hash meets the claimed target.
+func (pow *hashCashPoW) Validate(block *block.Block) bool {
+	var hashInt big.Int
 
-	timestampHex, err := utils.IntToHex(b.Timestamp)
-	if err != nil {
-		return block.Hash{}, err
+	hash := block.Header.Hash()
+	if hash != block.Hash {
+		return false
 	}
 
-	nonceHex, err := utils.IntToHex(nonce)
-	if err != nil {
-		return block.Hash{}, err
+	expectedBits, err := pow.expectedBits(block)
+	if err != nil || block.Bits != expectedBits {
+		return false
 	}
 
-	data = append(data, b.PrevBlockHash[:]...)
-	data = append(data, txHash[:]...)
-	data = append(data, timestampHex...)
-	data = append(data, nonceHex...)
+	hashInt.SetBytes(hash[:])
+
+	return hashInt.Cmp(compactToTarget(block.Bits)) == -1
+}
 
-	return sha256.Sum256(data), nil
+// Work returns the expected number of hashes needed to find a hash below the block's target.
+func (pow *hashCashPoW) Work(b *block.Block) *big.Int {
+	// 2^256 / (target + 1)
+	work := new(big.Int).Lsh(big.NewInt(1), uint(sha256Length))
+	return work.Div(work, new(big.Int).Add(compactToTarget(b.Bits), big.NewInt(1)))
 }
@@ -1,24 +1,35 @@
 package hashcash_test
 
 import (
+	"context"
 	"encoding/hex"
+	"fmt"
 	"math/big"
+	"runtime"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/hashcash"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestMain(t *testing.T) {
-	pow := hashcash.New()
+	// A single worker makes the found nonce deterministic
+	params := hashcash.DefaultParams
+	params.Workers = 1
+	pow := hashcash.NewWithParams(mock.NewStorage(), params)
 
 	b := &block.Block{
-		Timestamp:     1234567890,
-		Transactions:  nil,
-		PrevBlockHash: block.Hash{'0', '0', '0'},
+		Header: block.Header{
+			Version:   block.HeaderVersion,
+			Timestamp: 1234567890,
+		},
+		Transactions: nil,
 	}
 
 	hashcash.TimeNow = func() time.Time {
@@ -26,22 +37,17 @@ func TestMain(t *testing.T) {
 	}
 
 	t.Run("produce", func(t *testing.T) {
-		hash, nonce := pow.Produce(b)
-
-		var hashInt big.Int
-		hashInt.SetBytes(hash[:])
-
-		expectedHash, err := hex.DecodeString("00003f60dc9c6a8c1840af11ebd071b0fe06df237854a49416dc1e8d9ec7d170")
+		hash, err := pow.Produce(t.Context(), b)
 		require.NoError(t, err)
 
-		expectedNonce, err := hex.DecodeString("0000000000005e6c")
+		expectedHash, err := hex.DecodeString("00000c76605dbf6232a7c28853ed803cb7487b7982035d2fe8e2eafc841cff61")
 		require.NoError(t, err)
 
 		assert.Equal(t, expectedHash, hash[:])
-		assert.Equal(t, expectedNonce, nonce)
+		assert.Equal(t, uint64(0x2a9d2), b.Nonce)
+		assert.Equal(t, hash, b.Header.Hash())
 
 		b.Hash = hash
-		b.PoW = nonce
 	})
 
 	t.Run("validate", func(t *testing.T) {
@@ -51,35 +57,185 @@ func TestMain(t *testing.T) {
 
 	t.Run("validate invalid hash", func(t *testing.T) {
 		invalidBlock := &block.Block{
-			Timestamp:     1234567890,
-			Transactions:  nil,
-			PrevBlockHash: block.Hash{'0', '0', '0'},
-			Hash:          block.Hash{'1', '2', '3', '4', '5', '6', '7', '8', '9', '1'}, // Invalid hash
-			PoW:           []byte{'p', 'o', 'w', '1', '2', '3', '4', '5', '6', '7', '8', '9', '0'},
+			Header: block.Header{
+				PrevBlockHash: block.Hash{'0', '0', '0'},
+				Timestamp:     1234567890,
+				Nonce:         1234567890,
+			},
+			Transactions: nil,
+			Hash:         block.Hash{'1', '2', '3', '4', '5', '6', '7', '8', '9', '1'}, // Invalid hash
 		}
 
 		valid := pow.Validate(invalidBlock)
 		assert.False(t, valid)
 	})
 
-	t.Run("validate nonce too short", func(t *testing.T) {
-		invalidBlock := &block.Block{
-			Timestamp:     1234567890,
-			Transactions:  nil,
-			PrevBlockHash: block.Hash{'0', '0', '0'},
-			Hash:          block.Hash{'1', '2', '3', '4', '5', '6', '7', '8', '9', '1'}, // Invalid hash
-			PoW:           []byte{'p', 'o', 'w'},
+	t.Run("validate modified header", func(t *testing.T) {
+		modified := *b
+		modified.Nonce++
+
+		valid := pow.Validate(&modified)
+		assert.False(t, valid)
+	})
+}
+
+func TestRetarget(t *testing.T) {
+	params := hashcash.Params{InitialBits: 4, RetargetInterval: 4, TargetBlockTime: 10 * time.Second}
+
+	// mineChain mines a genesis block followed by blocks with the given time between them.
+	mineChain := func(pow blockchain.ProofOfWorkFactory, storage block.Storage, blockTimes ...int64) []*block.Block {
+		t.Helper()
+
+		var blocks []*block.Block
+		prev := &block.Block{}
+		for height, blockTime := range append([]int64{0}, blockTimes...) {
+			b := &block.Block{Header: block.Header{PrevBlockHash: prev.Hash, Timestamp: prev.Timestamp + blockTime}}
+			var err error
+			b.Hash, err = pow.Produce(t.Context(), b)
+			require.NoError(t, err)
+
+			require.NoError(t, storage.AddBlock(*b))
+			require.NoError(t, storage.SetBlockMeta(b.Hash, block.Meta{Height: height, Work: pow.Work(b)}))
+
+			blocks = append(blocks, b)
+			prev = b
 		}
 
-		defer func() {
-			if r := recover(); r != nil {
-				assert.Equal(t, "Invalid PoW length: expected 8, got 3", r.(string))
-			}
-		}()
+		return blocks
+	}
 
-		valid := pow.Validate(invalidBlock)
-		assert.False(t, valid)
+	t.Run("fast blocks raise the difficulty", func(t *testing.T) {
+		storage := mock.NewStorage()
+		pow := hashcash.NewWithParams(storage, params)
+
+		blocks := mineChain(pow, storage, 1, 1, 1, 1)
+
+		for _, b := range blocks[:4] {
+			assert.Equal(t, blocks[0].Bits, b.Bits)
+		}
+		assert.Equal(t, 1, pow.Work(blocks[4]).Cmp(pow.Work(blocks[3])))
+		assert.True(t, pow.Validate(blocks[4]))
+	})
+
+	t.Run("slow blocks do not lower the difficulty below the initial one", func(t *testing.T) {
+		storage := mock.NewStorage()
+		pow := hashcash.NewWithParams(storage, params)
+
+		blocks := mineChain(pow, storage, 100, 100, 100, 100)
+
+		assert.Equal(t, blocks[0].Bits, blocks[4].Bits)
+	})
+
+	t.Run("block claiming a lower difficulty", func(t *testing.T) {
+		storage := mock.NewStorage()
+		pow := hashcash.NewWithParams(storage, params)
+		blocks := mineChain(pow, storage, 1, 1, 1)
+
+		// A miner that skips the retarget keeps the easier difficulty of the parent
+		lenient := hashcash.NewWithParams(storage, hashcash.Params{
+			InitialBits:      params.InitialBits,
+			RetargetInterval: 100,
+			TargetBlockTime:  params.TargetBlockTime,
+		})
+		b := &block.Block{Header: block.Header{PrevBlockHash: blocks[3].Hash, Timestamp: blocks[3].Timestamp + 1}}
+		var err error
+		b.Hash, err = lenient.Produce(t.Context(), b)
+		require.NoError(t, err)
 
-		t.Errorf("Test failed, panic was expected")
+		assert.True(t, lenient.Validate(b))
+		assert.False(t, pow.Validate(b))
 	})
 }
+
+func TestParamsValidate(t *testing.T) {
+	require.NoError(t, hashcash.DefaultParams.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(p *hashcash.Params)
+	}{
+		{name: "zero retarget interval", modify: func(p *hashcash.Params) { p.RetargetInterval = 0 }},
+		{name: "retarget interval of one block", modify: func(p *hashcash.Params) { p.RetargetInterval = 1 }},
+		{name: "target block time below a second", modify: func(p *hashcash.Params) { p.TargetBlockTime = time.Millisecond }},
+		{name: "initial bits beyond the hash", modify: func(p *hashcash.Params) { p.InitialBits = 256 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := hashcash.DefaultParams
+			tt.modify(&params)
+
+			require.Error(t, params.Validate())
+			assert.Panics(t, func() { hashcash.NewWithParams(mock.NewStorage(), params) })
+		})
+	}
+}
+
+func TestProduceInParallel(t *testing.T) {
+	params := hashcash.Params{InitialBits: 16, RetargetInterval: 10, TargetBlockTime: 10 * time.Second, Workers: 4}
+	pow := hashcash.NewWithParams(mock.NewStorage(), params)
+
+	b := &block.Block{Header: block.Header{Version: block.HeaderVersion, Timestamp: 1234567890}}
+	var err error
+	b.Hash, err = pow.Produce(t.Context(), b)
+	require.NoError(t, err)
+
+	assert.Equal(t, b.Header.Hash(), b.Hash)
+	assert.True(t, pow.Validate(b))
+}
+
+func TestProduceExhaustsNonces(t *testing.T) {
+	defer func(maxNonce uint64) { hashcash.MaxNonce = maxNonce }(hashcash.MaxNonce)
+	hashcash.MaxNonce = 3
+
+	params := hashcash.Params{InitialBits: 8, RetargetInterval: 10, TargetBlockTime: 10 * time.Second, Workers: 2}
+	pow := hashcash.NewWithParams(mock.NewStorage(), params)
+
+	b := &block.Block{Header: block.Header{Version: block.HeaderVersion, Timestamp: 1234567890}}
+	var err error
+	b.Hash, err = pow.Produce(t.Context(), b)
+	require.NoError(t, err)
+
+	// Only four nonces fit in the nonce space, so the timestamp is bumped until one of them is valid
+	assert.LessOrEqual(t, b.Nonce, uint64(3))
+	assert.GreaterOrEqual(t, b.Timestamp, int64(1234567890))
+	assert.True(t, pow.Validate(b))
+}
+
+func BenchmarkProduce(b *testing.B) {
+	workerCounts := []int{1, 2, 4, runtime.NumCPU()}
+	slices.Sort(workerCounts)
+
+	for _, workers := range slices.Compact(workerCounts) {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			params := hashcash.Params{InitialBits: 16, RetargetInterval: 10, TargetBlockTime: 10 * time.Second, Workers: workers}
+			pow := hashcash.NewWithParams(mock.NewStorage(), params)
+
+			// The work of a block is the expected number of hashes needed to produce it
+			hashes := new(big.Int)
+			for i := range b.N {
+				blk := &block.Block{Header: block.Header{Version: block.HeaderVersion, Timestamp: int64(i)}}
+				if _, err := pow.Produce(b.Context(), blk); err != nil {
+					b.Fatal(err)
+				}
+				hashes.Add(hashes, pow.Work(blk))
+			}
+
+			hashRate, _ := new(big.Float).Quo(new(big.Float).SetInt(hashes), big.NewFloat(b.Elapsed().Seconds())).Float64()
+			b.ReportMetric(hashRate, "hashes/s")
+		})
+	}
+}
+
+func TestProduceCancelled(t *testing.T) {
+	// The difficulty is far too high to find a nonce before the deadline
+	params := hashcash.Params{InitialBits: 64, RetargetInterval: 10, TargetBlockTime: 10 * time.Second, Workers: 2}
+	pow := hashcash.NewWithParams(mock.NewStorage(), params)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	b := &block.Block{Header: block.Header{Version: block.HeaderVersion, Timestamp: 1234567890}}
+	_, err := pow.Produce(ctx, b)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
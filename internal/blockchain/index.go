@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+// TxInfo is a transaction together with its place in the blockchain.
+type TxInfo struct {
+	// Tx is the transaction itself.
+	Tx *transaction.Tx
+	// BlockHash is the hash of the block of the active chain that includes the transaction.
+	// It is empty for pending transactions.
+	BlockHash block.Hash
+	// Confirmations is the number of blocks from the including block up to the tip, 0 for pending transactions.
+	Confirmations int
+}
+
+// BlockInfo is a block together with its place in the blockchain.
+type BlockInfo struct {
+	// Block is the block itself.
+	Block *block.Block
+	// Height is the number of blocks between the block and the genesis block.
+	Height int
+	// Confirmations is the number of blocks from the block up to the tip, 0 for blocks of side chains.
+	Confirmations int
+}
+
+// GetBlockInfo looks up a stored block by its hash.
+func (bc *Blockchain) GetBlockInfo(hash block.Hash) (*BlockInfo, error) {
+	b, meta, err := bc.getBlockWithMeta(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BlockInfo{Block: b, Height: meta.Height}
+
+	active, err := bc.storage.GetBlockHashByHeight(meta.Height)
+	if err == nil && active == hash {
+		info.Confirmations = bc.GetBestHeight() - meta.Height + 1
+	}
+
+	return info, nil
+}
+
+// GetTransaction looks up a transaction of the active chain or a pending transaction by its ID.
+func (bc *Blockchain) GetTransaction(id transaction.TxID) (*TxInfo, error) {
+	loc, err := bc.storage.GetTxLocation(id)
+	if err != nil {
+		tx, poolErr := bc.mempool.Get(id)
+		if poolErr != nil {
+			return nil, fmt.Errorf("transaction %x not found", id)
+		}
+
+		return &TxInfo{Tx: tx}, nil
+	}
+
+	tx, err := bc.transactionAt(*loc)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := bc.storage.GetBlockMeta(loc.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block meta %x: %w", loc.BlockHash, err)
+	}
+
+	return &TxInfo{
+		Tx:            tx,
+		BlockHash:     loc.BlockHash,
+		Confirmations: bc.GetBestHeight() - meta.Height + 1,
+	}, nil
+}
+
+// findTransaction finds a transaction of the active chain by its ID.
+func (bc *Blockchain) findTransaction(id transaction.TxID) (*transaction.Tx, error) {
+	loc, err := bc.storage.GetTxLocation(id)
+	if err != nil {
+		return nil, fmt.Errorf("transaction not found: %w", err)
+	}
+
+	return bc.transactionAt(*loc)
+}
+
+// transactionAt returns the transaction stored at the given location.
+func (bc *Blockchain) transactionAt(loc block.TxLocation) (*transaction.Tx, error) {
+	b, err := bc.storage.GetBlock(loc.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block %x: %w", loc.BlockHash, err)
+	}
+
+	if loc.Index < 0 || loc.Index >= len(b.Transactions) {
+		return nil, fmt.Errorf("block %x has no transaction at index %d", loc.BlockHash, loc.Index)
+	}
+
+	return b.Transactions[loc.Index], nil
+}
+
+// indexBlock adds a block of the active chain at the given height to the height and transaction indexes.
+func indexBlock(storage block.Storage, b *block.Block, height int) error {
+	err := storage.SetBlockHashByHeight(height, b.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to index block %x by height: %w", b.Hash, err)
+	}
+
+	for i, tx := range b.Transactions {
+		err := storage.SetTxLocation(tx.ID, block.TxLocation{BlockHash: b.Hash, Index: i})
+		if err != nil {
+			return fmt.Errorf("failed to index transaction %x: %w", tx.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock removes a block that is disconnected from the active chain from the height and transaction indexes.
+func unindexBlock(storage block.Storage, b *block.Block, height int) error {
+	err := storage.DeleteBlockHashByHeight(height)
+	if err != nil {
+		return fmt.Errorf("failed to remove block %x from the height index: %w", b.Hash, err)
+	}
+
+	for _, tx := range b.Transactions {
+		// indexBlock overwrites the entry of a repeated ID, so the entry may already be gone
+		// or point to another block. An earlier transaction with the same ID is left without one.
+		loc, err := storage.GetTxLocation(tx.ID)
+		if errors.Is(err, block.ErrTxNotIndexed) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get the location of transaction %x: %w", tx.ID, err)
+		}
+
+		if loc.BlockHash != b.Hash {
+			continue
+		}
+
+		err = storage.DeleteTxLocation(tx.ID)
+		if err != nil {
+			return fmt.Errorf("failed to remove transaction %x from the index: %w", tx.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// checkIndexes checks that the height and transaction indexes were last updated to the tip.
+func (bc *Blockchain) checkIndexes(tip *block.Block, tipMeta *block.Meta) error {
+	hash, err := bc.storage.GetBlockHashByHeight(tipMeta.Height)
+	if err != nil || hash != tip.Hash {
+		return errors.New("height index does not match the tip")
+	}
+
+	for i, tx := range tip.Transactions {
+		loc, err := bc.storage.GetTxLocation(tx.ID)
+		if err != nil || loc.BlockHash != tip.Hash || loc.Index != i {
+			return errors.New("transaction index does not match the tip")
+		}
+	}
+
+	return nil
+}
+
+// reindexBlocks rebuilds the height and transaction indexes from the blocks of the active chain.
+func (bc *Blockchain) reindexBlocks() error {
+	return bc.atomically(func(view *Blockchain) error {
+		indexed := make(map[transaction.TxID]struct{})
+
+		tipHeight := view.GetBestHeight()
+		for i, b := range view.Blocks() {
+			err := view.storage.SetBlockHashByHeight(tipHeight-i, b.Hash)
+			if err != nil {
+				return fmt.Errorf("failed to index block %x by height: %w", b.Hash, err)
+			}
+
+			for txIdx, tx := range b.Transactions {
+				// Walking back from the tip, the latest transaction with a given ID is indexed first
+				if _, ok := indexed[tx.ID]; ok {
+					continue
+				}
+				indexed[tx.ID] = struct{}{}
+
+				err := view.storage.SetTxLocation(tx.ID, block.TxLocation{BlockHash: b.Hash, Index: txIdx})
+				if err != nil {
+					return fmt.Errorf("failed to index transaction %x: %w", tx.ID, err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
@@ -32,7 +32,7 @@ func TestMain(t *testing.T) {
 	storage, cleanup := setupTestStorage(t)
 	t.Cleanup(cleanup)
 
-	powFactory := hashcash.New()
+	powFactory := hashcash.New(storage)
 
 	wallets := wallet.NewCollection(storage)
 
@@ -58,7 +58,7 @@ func TestMain(t *testing.T) {
 
 	var bc *blockchain.Blockchain
 	t.Run("create blockchain", func(t *testing.T) {
-		err := blockchain.CreateBlockchain(storage, powFactory, address1)
+		err := blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1)
 		require.NoError(t, err, "failed to create blockchain")
 		bc, err = blockchain.LoadBlockchain(storage, powFactory, wallets)
 		require.NoError(t, err, "failed to load blockchain")
@@ -85,31 +85,25 @@ func TestMain(t *testing.T) {
 	})
 
 	t.Run("create transactions 1", func(t *testing.T) {
-		tx, err := bc.NewUTXOTransaction(address1, address2, 7)
+		tx, err := bc.NewUTXOTransaction(address1, address2, 7, 0)
 		require.NoError(t, err)
 
 		cbTx, err := transaction.NewCoinbaseTX(address1, "")
 		require.NoError(t, err, "failed to create coinbase transaction")
 
-		b, err := bc.MineBlock([]*transaction.Tx{tx, cbTx})
+		_, err = bc.MineBlock(t.Context(), []*transaction.Tx{cbTx, tx})
 		require.NoError(t, err)
-
-		err = bc.Update(*b)
-		require.NoError(t, err, "failed to update UTXO set after mining block")
 	})
 
 	t.Run("create transactions 2", func(t *testing.T) {
-		tx, err := bc.NewUTXOTransaction(address2, address3, 5)
+		tx, err := bc.NewUTXOTransaction(address2, address3, 5, 0)
 		require.NoError(t, err)
 
 		cbTx, err := transaction.NewCoinbaseTX(address2, "")
 		require.NoError(t, err, "failed to create coinbase transaction")
 
-		b, err := bc.MineBlock([]*transaction.Tx{tx, cbTx})
+		_, err = bc.MineBlock(t.Context(), []*transaction.Tx{cbTx, tx})
 		require.NoError(t, err)
-
-		err = bc.Update(*b)
-		require.NoError(t, err, "failed to update UTXO set after mining block")
 	})
 
 	t.Run("print blockchain", func(t *testing.T) {
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+// ErrInvalidTransaction is returned when a transaction does not pass validation.
+var ErrInvalidTransaction = errors.New("invalid transaction")
+
+// ValidateTransaction checks that a transaction can be included in a block on top of the current tip.
+// Every input must spend a distinct output from the UTXO set and be signed by the output's owner,
+// and the outputs may not exceed the inputs.
+func (bc *Blockchain) ValidateTransaction(tx *transaction.Tx) error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("%w: coinbase transactions are only valid in blocks", ErrInvalidTransaction)
+	}
+
+	if tx.ID != tx.Hash() {
+		return fmt.Errorf("%w: transaction ID %x does not match its contents", ErrInvalidTransaction, tx.ID)
+	}
+
+	spent := make(map[transaction.Outpoint]struct{}, len(tx.Vin))
+	prevTXs := make(map[transaction.TxID]*transaction.Tx)
+	for _, vin := range tx.Vin {
+		op := vin.Outpoint()
+		if _, ok := spent[op]; ok {
+			return fmt.Errorf("%w: output %x:%d is spent twice", ErrInvalidTransaction, vin.TxID, vin.Vout)
+		}
+		spent[op] = struct{}{}
+
+		prevTX, err := bc.findSpentTransaction(vin, nil)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidTransaction, err)
+		}
+		prevTXs[prevTX.ID] = prevTX
+	}
+
+	if _, err := tx.Fee(prevTXs); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTransaction, err)
+	}
+
+	if !tx.Verify(prevTXs) {
+		return fmt.Errorf("%w: invalid signature", ErrInvalidTransaction)
+	}
+
+	return nil
+}
+
+// SubmitTransaction validates a transaction and adds it to the pool of pending transactions.
+func (bc *Blockchain) SubmitTransaction(tx *transaction.Tx) error {
+	err := bc.ValidateTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	return bc.mempool.Add(tx)
+}
+
+// GetPendingTransaction returns a pending transaction by its ID.
+func (bc *Blockchain) GetPendingTransaction(txID transaction.TxID) (*transaction.Tx, error) {
+	return bc.mempool.Get(txID)
+}
+
+// PendingTransactions returns all transactions that are waiting to be included in a block.
+func (bc *Blockchain) PendingTransactions() ([]*transaction.Tx, error) {
+	return bc.mempool.Transactions()
+}
+
+// DropTransaction removes a transaction from the pool of pending transactions.
+func (bc *Blockchain) DropTransaction(txID transaction.TxID) error {
+	return bc.mempool.Remove(txID)
+}
+
+// restorePending returns the transactions of disconnected blocks, given starting from the old tip, to the pool
+// and drops the pending transactions that are no longer valid on the new active chain.
+// Transactions are restored oldest first. Pending transactions may only spend outputs of the UTXO set,
+// so a transaction that spends an output of another disconnected transaction is dropped,
+// like the ones that conflict with the new active chain.
+func (bc *Blockchain) restorePending(disconnected []*block.Block) error {
+	pending, err := bc.mempool.Transactions()
+	if err != nil {
+		return fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	for _, tx := range pending {
+		if bc.ValidateTransaction(tx) == nil {
+			continue
+		}
+
+		err := bc.mempool.Remove(tx.ID)
+		if err != nil {
+			return fmt.Errorf("failed to remove pending transaction %x: %w", tx.ID, err)
+		}
+	}
+
+	for _, b := range slices.Backward(disconnected) {
+		for _, tx := range b.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+
+			_ = bc.SubmitTransaction(tx) // Invalid transactions are dropped
+		}
+	}
+
+	return nil
+}
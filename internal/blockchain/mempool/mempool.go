@@ -0,0 +1,159 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+var (
+	ErrTxExists   = errors.New("transaction is already pending")
+	ErrTxNotFound = errors.New("transaction is not pending")
+	ErrConflict   = errors.New("transaction spends an output that a pending transaction already spends")
+)
+
+// Storage is an interface for a storage system that can store and retrieve pending transactions.
+type Storage interface {
+	// GetPendingTxs returns all pending transactions by their ID.
+	GetPendingTxs() (map[transaction.TxID]*transaction.Tx, error)
+	// AddPendingTx stores a pending transaction.
+	AddPendingTx(tx transaction.Tx) error
+	// DeletePendingTx removes a pending transaction.
+	DeletePendingTx(txID transaction.TxID) error
+}
+
+// Mempool holds validated transactions that are waiting to be included in a block.
+// It does not validate transactions itself, but makes sure that no two pending transactions spend the same output.
+type Mempool struct {
+	storage Storage
+}
+
+// New creates a Mempool backed by the given storage.
+func New(storage Storage) *Mempool {
+	return &Mempool{storage: storage}
+}
+
+// Add adds a transaction to the pool.
+// It fails if the transaction is already pending or spends an output that a pending transaction spends.
+func (m *Mempool) Add(tx *transaction.Tx) error {
+	pending, err := m.storage.GetPendingTxs()
+	if err != nil {
+		return fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	if _, ok := pending[tx.ID]; ok {
+		return ErrTxExists
+	}
+
+	spent := spentOutpoints(slices.Collect(maps.Values(pending)))
+	for _, vin := range tx.Vin {
+		if _, ok := spent[vin.Outpoint()]; ok {
+			return fmt.Errorf("%w: %x:%d", ErrConflict, vin.TxID, vin.Vout)
+		}
+	}
+
+	return m.storage.AddPendingTx(*tx)
+}
+
+// Get returns a pending transaction by its ID.
+func (m *Mempool) Get(txID transaction.TxID) (*transaction.Tx, error) {
+	pending, err := m.storage.GetPendingTxs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	tx, ok := pending[txID]
+	if !ok {
+		return nil, ErrTxNotFound
+	}
+
+	return tx, nil
+}
+
+// Transactions returns all pending transactions.
+func (m *Mempool) Transactions() ([]*transaction.Tx, error) {
+	pending, err := m.storage.GetPendingTxs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	return slices.Collect(maps.Values(pending)), nil
+}
+
+// SpentOutpoints returns the outputs spent by pending transactions.
+func (m *Mempool) SpentOutpoints() (map[transaction.Outpoint]struct{}, error) {
+	txs, err := m.Transactions()
+	if err != nil {
+		return nil, err
+	}
+
+	return spentOutpoints(txs), nil
+}
+
+// Remove removes a pending transaction from the pool.
+func (m *Mempool) Remove(txID transaction.TxID) error {
+	pending, err := m.storage.GetPendingTxs()
+	if err != nil {
+		return fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	if _, ok := pending[txID]; !ok {
+		return ErrTxNotFound
+	}
+
+	return m.storage.DeletePendingTx(txID)
+}
+
+// RemoveBlock evicts the transactions included in a block,
+// together with any pending transaction that spends an output the block spends.
+func (m *Mempool) RemoveBlock(b block.Block) error {
+	pending, err := m.storage.GetPendingTxs()
+	if err != nil {
+		return fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	spent := spentOutpoints(b.Transactions)
+
+	for txID, tx := range pending {
+		evict := slices.ContainsFunc(b.Transactions, func(btx *transaction.Tx) bool {
+			return btx.ID == txID
+		})
+
+		for _, vin := range tx.Vin {
+			if _, ok := spent[vin.Outpoint()]; ok {
+				evict = true
+			}
+		}
+
+		if !evict {
+			continue
+		}
+
+		err := m.storage.DeletePendingTx(txID)
+		if err != nil {
+			return fmt.Errorf("failed to remove pending transaction %x: %w", txID, err)
+		}
+	}
+
+	return nil
+}
+
+// spentOutpoints collects the outputs spent by the given transactions.
+func spentOutpoints(txs []*transaction.Tx) map[transaction.Outpoint]struct{} {
+	spent := make(map[transaction.Outpoint]struct{})
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			spent[vin.Outpoint()] = struct{}{}
+		}
+	}
+
+	return spent
+}
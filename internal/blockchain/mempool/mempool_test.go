@@ -0,0 +1,91 @@
+package mempool_test
+
+import (
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mempool"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTransaction(id string, spends ...transaction.Outpoint) *transaction.Tx {
+	txID := transaction.TxID{}
+	copy(txID[:], []byte(id))
+
+	tx := &transaction.Tx{
+		ID: txID,
+		Vout: []transaction.TxOutput{
+			{Value: 1, PubKeyHash: []byte("test-pubkey-hash")},
+		},
+	}
+
+	for _, op := range spends {
+		tx.Vin = append(tx.Vin, transaction.TxInput{TxID: op.TxID, Vout: op.Vout})
+	}
+
+	return tx
+}
+
+func TestMempool(t *testing.T) {
+	pool := mempool.New(mock.NewStorage())
+
+	prev := transaction.TxID{'p', 'r', 'e', 'v'}
+	tx1 := getTransaction("tx1", transaction.Outpoint{TxID: prev, Vout: 0})
+	tx2 := getTransaction("tx2", transaction.Outpoint{TxID: prev, Vout: 1})
+
+	t.Run("add", func(t *testing.T) {
+		require.NoError(t, pool.Add(tx1))
+		require.NoError(t, pool.Add(tx2))
+
+		txs, err := pool.Transactions()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []*transaction.Tx{tx1, tx2}, txs)
+	})
+
+	t.Run("already pending", func(t *testing.T) {
+		assert.ErrorIs(t, pool.Add(tx1), mempool.ErrTxExists)
+	})
+
+	t.Run("conflicting spend", func(t *testing.T) {
+		conflicting := getTransaction("tx3", transaction.Outpoint{TxID: prev, Vout: 1})
+		assert.ErrorIs(t, pool.Add(conflicting), mempool.ErrConflict)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		tx, err := pool.Get(tx1.ID)
+		require.NoError(t, err)
+		assert.Equal(t, tx1, tx)
+
+		_, err = pool.Get(transaction.TxID{'n', 'o', 'n', 'e'})
+		assert.ErrorIs(t, err, mempool.ErrTxNotFound)
+	})
+
+	t.Run("spent outpoints", func(t *testing.T) {
+		spent, err := pool.SpentOutpoints()
+		require.NoError(t, err)
+		assert.Len(t, spent, 2)
+		assert.Contains(t, spent, transaction.Outpoint{TxID: prev, Vout: 0})
+		assert.Contains(t, spent, transaction.Outpoint{TxID: prev, Vout: 1})
+	})
+
+	t.Run("remove block", func(t *testing.T) {
+		// The block includes tx1 and a transaction that conflicts with tx2
+		conflicting := getTransaction("tx4", transaction.Outpoint{TxID: prev, Vout: 1})
+		b := block.Block{Transactions: []*transaction.Tx{tx1, conflicting}}
+
+		require.NoError(t, pool.RemoveBlock(b))
+
+		txs, err := pool.Transactions()
+		require.NoError(t, err)
+		assert.Empty(t, txs)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, pool.Add(tx1))
+		require.NoError(t, pool.Remove(tx1.ID))
+		assert.ErrorIs(t, pool.Remove(tx1.ID), mempool.ErrTxNotFound)
+	})
+}
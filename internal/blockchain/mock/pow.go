@@ -1,6 +1,9 @@
 package mock
 
 import (
+	"context"
+	"math/big"
+
 	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
 	"github.com/jleipus/learn-blockchain/internal/utils"
@@ -14,8 +17,12 @@ func NewPoWFactory() blockchain.ProofOfWorkFactory {
 	return &mockPoWFactory{}
 }
 
-func (m *mockPoWFactory) Produce(_ *block.Block) (block.Hash, []byte) {
-	// Mock implementation: return the counter as the hash and empty powData
+func (m *mockPoWFactory) Produce(ctx context.Context, _ *block.Block) (block.Hash, error) {
+	if err := ctx.Err(); err != nil {
+		return block.Hash{}, err
+	}
+
+	// Mock implementation: return the counter as the hash
 	m.counter++
 	counterHex, err := utils.IntToHex(m.counter)
 	if err != nil {
@@ -24,10 +31,15 @@ func (m *mockPoWFactory) Produce(_ *block.Block) (block.Hash, []byte) {
 
 	var hash block.Hash
 	copy(hash[:], counterHex)
-	return hash, []byte{}
+	return hash, nil
 }
 
 func (m *mockPoWFactory) Validate(_ *block.Block) bool {
 	// Mock implementation: always return true
 	return true
 }
+
+func (m *mockPoWFactory) Work(_ *block.Block) *big.Int {
+	// Mock implementation: every block counts as one unit of work
+	return big.NewInt(1)
+}
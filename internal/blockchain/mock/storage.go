@@ -2,26 +2,45 @@ package mock
 
 import (
 	"errors"
+	"maps"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/utxo"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 )
 
 type mockStorage struct {
-	tip     block.Hash
-	blocks  map[block.Hash]block.Block
-	wallets map[string]wallet.Wallet
-	utxos   map[transaction.TxID][]transaction.TxOutput
+	tip              block.Hash
+	utxoTip          block.Hash
+	blocks           map[block.Hash]block.Block
+	headers          map[block.Hash]block.Header
+	metas            map[block.Hash]block.Meta
+	heights          map[int]block.Hash
+	txIndex          map[transaction.TxID]block.TxLocation
+	wallets          map[string][]byte
+	watched          map[string]struct{}
+	walletEncryption []byte
+	hdChain          []byte
+	utxos            map[transaction.Outpoint]utxo.Entry
+	undos            map[block.Hash]utxo.Undo
+	pending          map[transaction.TxID]transaction.Tx
 }
 
 func NewStorage() blockchain.Storage {
 	return &mockStorage{
 		tip:     block.Hash{},
 		blocks:  make(map[block.Hash]block.Block),
-		wallets: make(map[string]wallet.Wallet),
-		utxos:   make(map[transaction.TxID][]transaction.TxOutput),
+		headers: make(map[block.Hash]block.Header),
+		metas:   make(map[block.Hash]block.Meta),
+		heights: make(map[int]block.Hash),
+		txIndex: make(map[transaction.TxID]block.TxLocation),
+		wallets: make(map[string][]byte),
+		watched: make(map[string]struct{}),
+		utxos:   make(map[transaction.Outpoint]utxo.Entry),
+		undos:   make(map[block.Hash]utxo.Undo),
+		pending: make(map[transaction.TxID]transaction.Tx),
 	}
 }
 
@@ -47,8 +66,78 @@ func (m *mockStorage) AddBlock(block block.Block) error {
 	return nil
 }
 
-func (m *mockStorage) AddWallet(address string, wallet wallet.Wallet) error {
-	m.wallets[address] = wallet
+func (m *mockStorage) GetHeader(hash block.Hash) (*block.Header, error) {
+	if header, exists := m.headers[hash]; exists {
+		return &header, nil
+	}
+	if block, exists := m.blocks[hash]; exists {
+		return &block.Header, nil
+	}
+	return nil, errors.New("header not found")
+}
+
+func (m *mockStorage) AddHeader(hash block.Hash, header block.Header) error {
+	m.headers[hash] = header
+	return nil
+}
+
+func (m *mockStorage) DeleteHeader(hash block.Hash) error {
+	delete(m.headers, hash)
+	delete(m.metas, hash)
+	return nil
+}
+
+func (m *mockStorage) GetBlockMeta(hash block.Hash) (*block.Meta, error) {
+	meta, exists := m.metas[hash]
+	if !exists {
+		return nil, errors.New("block meta not found")
+	}
+	return &meta, nil
+}
+
+func (m *mockStorage) SetBlockMeta(hash block.Hash, meta block.Meta) error {
+	m.metas[hash] = meta
+	return nil
+}
+
+func (m *mockStorage) GetBlockHashByHeight(height int) (block.Hash, error) {
+	hash, exists := m.heights[height]
+	if !exists {
+		return block.Hash{}, errors.New("block height not found")
+	}
+	return hash, nil
+}
+
+func (m *mockStorage) SetBlockHashByHeight(height int, hash block.Hash) error {
+	m.heights[height] = hash
+	return nil
+}
+
+func (m *mockStorage) DeleteBlockHashByHeight(height int) error {
+	delete(m.heights, height)
+	return nil
+}
+
+func (m *mockStorage) GetTxLocation(txID transaction.TxID) (*block.TxLocation, error) {
+	loc, exists := m.txIndex[txID]
+	if !exists {
+		return nil, block.ErrTxNotIndexed
+	}
+	return &loc, nil
+}
+
+func (m *mockStorage) SetTxLocation(txID transaction.TxID, loc block.TxLocation) error {
+	m.txIndex[txID] = loc
+	return nil
+}
+
+func (m *mockStorage) DeleteTxLocation(txID transaction.TxID) error {
+	delete(m.txIndex, txID)
+	return nil
+}
+
+func (m *mockStorage) AddWallet(address string, data []byte) error {
+	m.wallets[address] = data
 	return nil
 }
 
@@ -60,19 +149,144 @@ func (m *mockStorage) GetAddresses() ([]string, error) {
 	return addresses, nil
 }
 
-func (m *mockStorage) GetWallet(address string) (*wallet.Wallet, error) {
-	if wallet, exists := m.wallets[address]; exists {
-		return &wallet, nil
+func (m *mockStorage) GetWallet(address string) ([]byte, error) {
+	if data, exists := m.wallets[address]; exists {
+		return data, nil
+	}
+	return nil, wallet.ErrWalletNotFound
+}
+
+func (m *mockStorage) AddWatchAddress(address string) error {
+	m.watched[address] = struct{}{}
+	return nil
+}
+
+func (m *mockStorage) GetWatchAddresses() ([]string, error) {
+	addresses := make([]string, 0, len(m.watched))
+	for address := range m.watched {
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func (m *mockStorage) GetWalletEncryption() ([]byte, error) {
+	return m.walletEncryption, nil
+}
+
+func (m *mockStorage) GetHDChain() ([]byte, error) {
+	return m.hdChain, nil
+}
+
+func (m *mockStorage) SetHDChain(data []byte) error {
+	m.hdChain = data
+	return nil
+}
+
+func (m *mockStorage) ReplaceWallets(encryption, hdChain []byte, wallets map[string][]byte) error {
+	m.walletEncryption = encryption
+	if hdChain != nil {
+		m.hdChain = hdChain
+	}
+	maps.Copy(m.wallets, wallets)
+	return nil
+}
+
+func (m *mockStorage) GetUTXOTip() (block.Hash, error) {
+	return m.utxoTip, nil
+}
+
+func (m *mockStorage) SetUTXOTip(hash block.Hash) error {
+	m.utxoTip = hash
+	return nil
+}
+
+func (m *mockStorage) GetUTXOs() (map[transaction.Outpoint]utxo.Entry, error) {
+	return maps.Clone(m.utxos), nil
+}
+
+func (m *mockStorage) GetUTXOsByPubKeyHash(pubKeyHash []byte) (map[transaction.Outpoint]utxo.Entry, error) {
+	utxos := make(map[transaction.Outpoint]utxo.Entry)
+	for outpoint, entry := range m.utxos {
+		if entry.Output.IsLockedWithKey(pubKeyHash) {
+			utxos[outpoint] = entry
+		}
+	}
+	return utxos, nil
+}
+
+func (m *mockStorage) GetUTXO(outpoint transaction.Outpoint) (*utxo.Entry, error) {
+	entry, exists := m.utxos[outpoint]
+	if !exists {
+		return nil, utxo.ErrNotFound
 	}
-	return nil, errors.New("wallet not found")
+	return &entry, nil
 }
 
-func (m *mockStorage) GetUTXOs() (map[transaction.TxID][]transaction.TxOutput, error) {
-	return m.utxos, nil
+func (m *mockStorage) SetUTXO(outpoint transaction.Outpoint, entry utxo.Entry) error {
+	m.utxos[outpoint] = entry
+	return nil
 }
 
-func (m *mockStorage) SetUTXOs(txID transaction.TxID, outputs []transaction.TxOutput) error {
-	m.utxos[txID] = outputs
+func (m *mockStorage) DeleteUTXO(outpoint transaction.Outpoint) error {
+	delete(m.utxos, outpoint)
+	return nil
+}
+
+func (m *mockStorage) GetUndo(hash block.Hash) (*utxo.Undo, error) {
+	undo, exists := m.undos[hash]
+	if !exists {
+		return nil, errors.New("undo record not found")
+	}
+	return &undo, nil
+}
+
+func (m *mockStorage) SetUndo(hash block.Hash, undo utxo.Undo) error {
+	m.undos[hash] = undo
+	return nil
+}
+
+func (m *mockStorage) GetPendingTxs() (map[transaction.TxID]*transaction.Tx, error) {
+	txs := make(map[transaction.TxID]*transaction.Tx, len(m.pending))
+	for txID, tx := range m.pending {
+		txs[txID] = &tx
+	}
+	return txs, nil
+}
+
+func (m *mockStorage) AddPendingTx(tx transaction.Tx) error {
+	m.pending[tx.ID] = tx
+	return nil
+}
+
+func (m *mockStorage) DeletePendingTx(txID transaction.TxID) error {
+	delete(m.pending, txID)
+	return nil
+}
+
+// Update runs fn on the storage and restores the previous state if fn returns an error.
+func (m *mockStorage) Update(fn func(blockchain.Storage) error) error {
+	snapshot := mockStorage{
+		tip:              m.tip,
+		utxoTip:          m.utxoTip,
+		blocks:           maps.Clone(m.blocks),
+		headers:          maps.Clone(m.headers),
+		metas:            maps.Clone(m.metas),
+		heights:          maps.Clone(m.heights),
+		txIndex:          maps.Clone(m.txIndex),
+		wallets:          maps.Clone(m.wallets),
+		watched:          maps.Clone(m.watched),
+		walletEncryption: m.walletEncryption,
+		hdChain:          m.hdChain,
+		utxos:            maps.Clone(m.utxos),
+		undos:            maps.Clone(m.undos),
+		pending:          maps.Clone(m.pending),
+	}
+
+	if err := fn(m); err != nil {
+		*m = snapshot
+		return err
+	}
+
 	return nil
 }
 
@@ -1,7 +1,11 @@
 package blockchain
 
 import (
+	"context"
+	"math/big"
+
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mempool"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/utxo"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 )
@@ -10,14 +14,23 @@ import (
 // It is responsible for producing a hash for a block and validating it.
 type ProofOfWorkFactory interface {
 	// Create creates a new proof-of-work instance for the given block.
-	Produce(block *block.Block) (hash block.Hash, powData []byte)
+	// It fills in the difficulty and nonce of the block's header and returns the resulting hash.
+	// It stops and returns the context's error when the context is cancelled.
+	Produce(ctx context.Context, block *block.Block) (hash block.Hash, err error)
 	// Validate validates the proof-of-work for the given block.
 	Validate(block *block.Block) bool
+	// Work returns the amount of work that was needed to produce the given block.
+	// It is used to choose the chain with the most accumulated work.
+	Work(block *block.Block) *big.Int
 }
 
 type Storage interface {
 	block.Storage
 	wallet.Storage
 	utxo.Storage
+	mempool.Storage
+	// Update runs fn with a storage whose changes are applied all at once if fn returns nil,
+	// and not at all if it returns an error.
+	Update(fn func(Storage) error) error
 	Close() error
 }
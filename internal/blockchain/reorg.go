@@ -0,0 +1,217 @@
+package blockchain
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+)
+
+// storeBlock stores a block together with its chain metadata.
+func (bc *Blockchain) storeBlock(b *block.Block, meta block.Meta) error {
+	err := bc.storage.AddBlock(*b)
+	if err != nil {
+		return fmt.Errorf("failed to add block: %w", err)
+	}
+
+	err = bc.storage.SetBlockMeta(b.Hash, meta)
+	if err != nil {
+		return fmt.Errorf("failed to set block meta: %w", err)
+	}
+
+	return nil
+}
+
+// hasMoreWork checks whether a block with the given metadata has more accumulated work than the tip.
+func (bc *Blockchain) hasMoreWork(meta block.Meta, tip block.Hash) bool {
+	if tip == *new(block.Hash) {
+		return true
+	}
+
+	tipMeta, err := bc.storage.GetBlockMeta(tip)
+	if err != nil {
+		return true // The tip has no metadata, prefer the block that has
+	}
+
+	return meta.Work.Cmp(tipMeta.Work) > 0
+}
+
+// connectBlock makes a block whose parent is the current tip the new tip,
+// and updates the block indexes and the UTXO set.
+// The block's inputs are checked against the UTXO set first.
+func (bc *Blockchain) connectBlock(b *block.Block) error {
+	err := bc.checkInputs(b)
+	if err != nil {
+		return err
+	}
+
+	err = bc.setTip(b.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to set tip of blockchain: %w", err)
+	}
+
+	meta, err := bc.storage.GetBlockMeta(b.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to get block meta %x: %w", b.Hash, err)
+	}
+
+	err = indexBlock(bc.storage, b, meta.Height)
+	if err != nil {
+		return err
+	}
+
+	err = bc.utxoSet.Update(*b, meta.Height)
+	if err != nil {
+		return fmt.Errorf("failed to update UTXO set for block %x: %w", b.Hash, err)
+	}
+
+	err = bc.mempool.RemoveBlock(*b)
+	if err != nil {
+		return fmt.Errorf("failed to evict transactions of block %x: %w", b.Hash, err)
+	}
+
+	return nil
+}
+
+// disconnectBlock removes the current tip from the active chain and the block indexes, and reverts its UTXO changes.
+func (bc *Blockchain) disconnectBlock(b *block.Block) error {
+	err := bc.utxoSet.Disconnect(*b)
+	if err != nil {
+		return fmt.Errorf("failed to revert UTXO set for block %x: %w", b.Hash, err)
+	}
+
+	meta, err := bc.storage.GetBlockMeta(b.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to get block meta %x: %w", b.Hash, err)
+	}
+
+	err = unindexBlock(bc.storage, b, meta.Height)
+	if err != nil {
+		return err
+	}
+
+	err = bc.setTip(b.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("failed to set tip of blockchain: %w", err)
+	}
+
+	return nil
+}
+
+// Rollback disconnects blocks from the tip of the active chain until the block with the given hash is the tip.
+// The transactions of the disconnected blocks are returned to the pending transactions, see restorePending,
+// and the blocks stay stored as a side chain, which becomes active again once a block extending it is added.
+// The disconnected blocks are returned starting from the old tip.
+func (bc *Blockchain) Rollback(to block.Hash) ([]*block.Block, error) {
+	var disconnect []*block.Block
+
+	err := bc.atomically(func(view *Blockchain) error {
+		disconnect = nil
+
+		found := false
+		for _, b := range view.Blocks() {
+			if b.Hash == to {
+				found = true
+				break
+			}
+
+			disconnect = append(disconnect, b)
+		}
+
+		if !found {
+			return fmt.Errorf("block %x is not in the active chain", to)
+		}
+
+		for _, b := range disconnect {
+			err := view.disconnectBlock(b)
+			if err != nil {
+				return err
+			}
+		}
+
+		return view.restorePending(disconnect)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return disconnect, nil
+}
+
+// reorganize switches the active chain to the side chain ending with newTip.
+// Blocks of the active chain are disconnected down to the fork point,
+// then the blocks of the side chain are connected on top of it.
+// It must run inside a storage transaction, so that a failure leaves the active chain untouched.
+func (bc *Blockchain) reorganize(newTip *block.Block) error {
+	tip, err := bc.storage.GetTip()
+	if err != nil {
+		return fmt.Errorf("failed to get tip of blockchain: %w", err)
+	}
+
+	disconnect, connect, err := bc.findFork(tip, newTip.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to find fork point: %w", err)
+	}
+
+	for _, b := range disconnect {
+		err = bc.disconnectBlock(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range slices.Backward(connect) {
+		err = bc.connectBlock(b)
+		if err != nil {
+			return err // The side chain is invalid, discarding the transaction keeps the previous active chain
+		}
+	}
+
+	return bc.restorePending(disconnect)
+}
+
+// findFork walks back from two blocks to their common ancestor.
+// It returns the blocks above the common ancestor on each branch, starting from the given blocks.
+func (bc *Blockchain) findFork(oldTip, newTip block.Hash) ([]*block.Block, []*block.Block, error) {
+	var oldBranch, newBranch []*block.Block
+
+	oldBlock, oldMeta, err := bc.getBlockWithMeta(oldTip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newBlock, newMeta, err := bc.getBlockWithMeta(newTip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for oldBlock.Hash != newBlock.Hash {
+		if oldMeta.Height >= newMeta.Height {
+			oldBranch = append(oldBranch, oldBlock)
+			oldBlock, oldMeta, err = bc.getBlockWithMeta(oldBlock.PrevBlockHash)
+		} else {
+			newBranch = append(newBranch, newBlock)
+			newBlock, newMeta, err = bc.getBlockWithMeta(newBlock.PrevBlockHash)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return oldBranch, newBranch, nil
+}
+
+func (bc *Blockchain) getBlockWithMeta(hash block.Hash) (*block.Block, *block.Meta, error) {
+	b, err := bc.storage.GetBlock(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get block %x: %w", hash, err)
+	}
+
+	meta, err := bc.storage.GetBlockMeta(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get block meta %x: %w", hash, err)
+	}
+
+	return b, meta, nil
+}
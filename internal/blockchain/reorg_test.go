@@ -0,0 +1,251 @@
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReorganize(t *testing.T) {
+	// Both chains share the PoW factory so that the mock produces unique block hashes.
+	powFactory := mock.NewPoWFactory()
+
+	storageA := mock.NewStorage()
+	walletsA := wallet.NewCollection(storageA)
+	address1, err := walletsA.AddWallet()
+	require.NoError(t, err)
+	address2, err := walletsA.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storageA, powFactory, address1))
+	bcA, err := blockchain.LoadBlockchain(storageA, powFactory, walletsA)
+	require.NoError(t, err)
+	require.NoError(t, bcA.ReindexUTXOSet())
+
+	genesis, err := bcA.GetBlock(bcA.GetBlockHashes()[0])
+	require.NoError(t, err)
+
+	storageB := mock.NewStorage()
+	bcB := blockchain.NewBlockchain(storageB, powFactory, wallet.NewCollection(storageB))
+	require.NoError(t, bcB.AddBlock(genesis))
+
+	// Chain A: genesis <- a1, which moves 7 coins from wallet 1 to wallet 2
+	tx, err := bcA.NewUTXOTransaction(address1, address2, 7, 0)
+	require.NoError(t, err)
+	a1, err := bcA.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "a1"), tx})
+	require.NoError(t, err)
+
+	// Chain B: genesis <- b1 <- b2, which only reward wallet 2
+	b1, err := bcB.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "b1")})
+	require.NoError(t, err)
+	b2, err := bcB.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "b2")})
+	require.NoError(t, err)
+
+	assert.Equal(t, 13, balance(t, bcA, address1))
+	assert.Equal(t, 7, balance(t, bcA, address2))
+
+	t.Run("side chain with equal work", func(t *testing.T) {
+		require.NoError(t, bcA.AddBlock(b1))
+
+		assert.Equal(t, a1.Hash, bcA.GetBlockHashes()[0])
+		assert.Equal(t, 1, bcA.GetBestHeight())
+		assert.Equal(t, 13, balance(t, bcA, address1))
+		assert.Equal(t, 7, balance(t, bcA, address2))
+	})
+
+	t.Run("side chain with more work", func(t *testing.T) {
+		require.NoError(t, bcA.AddBlock(b2))
+
+		assert.Equal(t, []block.Hash{b2.Hash, b1.Hash, genesis.Hash}, bcA.GetBlockHashes())
+		assert.Equal(t, 2, bcA.GetBestHeight())
+		assert.Equal(t, 10, balance(t, bcA, address1))
+		assert.Equal(t, 20, balance(t, bcA, address2))
+	})
+
+	t.Run("unknown parent", func(t *testing.T) {
+		orphan := newTestBlock(block.Hash{'x'}, block.Hash{'y'}, coinbase(t, address1, "orphan"))
+		err := bcA.AddBlock(orphan)
+		require.ErrorIs(t, err, blockchain.ErrUnknownParent)
+	})
+
+	t.Run("reorganize back", func(t *testing.T) {
+		a2 := newTestBlock(a1.Hash, block.Hash{'a', '2'}, coinbase(t, address1, "a2"))
+		a3 := newTestBlock(a2.Hash, block.Hash{'a', '3'}, coinbase(t, address1, "a3"))
+
+		require.NoError(t, bcA.AddBlock(a2))
+		assert.Equal(t, b2.Hash, bcA.GetBlockHashes()[0])
+
+		require.NoError(t, bcA.AddBlock(a3))
+		assert.Equal(t, []block.Hash{a3.Hash, a2.Hash, a1.Hash, genesis.Hash}, bcA.GetBlockHashes())
+		assert.Equal(t, 33, balance(t, bcA, address1))
+		assert.Equal(t, 7, balance(t, bcA, address2))
+	})
+}
+
+func TestReorganizeChainedTransactions(t *testing.T) {
+	powFactory := mock.NewPoWFactory()
+
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	genesis := bc.GetBlockHashes()[0]
+
+	// a1 spends the genesis output, a2 spends the change of a1
+	parent, err := bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.NoError(t, err)
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "a1"), parent})
+	require.NoError(t, err)
+	child, err := bc.NewUTXOTransaction(address1, address2, 5, 0)
+	require.NoError(t, err)
+	_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "a2"), child})
+	require.NoError(t, err)
+
+	b1 := newTestBlock(genesis, block.Hash{'b', '1'}, coinbase(t, address2, "b1"))
+	b2 := newTestBlock(b1.Hash, block.Hash{'b', '2'}, coinbase(t, address2, "b2"))
+	b3 := newTestBlock(b2.Hash, block.Hash{'b', '3'}, coinbase(t, address2, "b3"))
+	for _, b := range []*block.Block{b1, b2, b3} {
+		require.NoError(t, bc.AddBlock(b))
+	}
+	require.Equal(t, b3.Hash, bc.GetBlockHashes()[0])
+
+	// The child spends an output that is not in the UTXO set until the parent is confirmed again
+	pending, err := bc.PendingTransactions()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, parent.ID, pending[0].ID)
+}
+
+func TestRollback(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	powFactory := mock.NewPoWFactory()
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	genesis := bc.GetBlockHashes()[0]
+
+	// Block 1 spends the genesis output, block 2 spends the change of block 1
+	tx1, err := bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.NoError(t, err)
+	b1, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "b1"), tx1})
+	require.NoError(t, err)
+	tx2, err := bc.NewUTXOTransaction(address1, address2, 5, 0)
+	require.NoError(t, err)
+	b2, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "b2"), tx2})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, balance(t, bc, address1))
+	assert.Equal(t, 28, balance(t, bc, address2))
+
+	t.Run("not in active chain", func(t *testing.T) {
+		_, err := bc.Rollback(block.Hash{'x'})
+		require.Error(t, err)
+		assert.Equal(t, b2.Hash, bc.GetBlockHashes()[0])
+	})
+
+	t.Run("one block", func(t *testing.T) {
+		disconnected, err := bc.Rollback(b1.Hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*block.Block{b2}, disconnected)
+		assert.Equal(t, []block.Hash{b1.Hash, genesis}, bc.GetBlockHashes())
+		assert.Equal(t, 7, balance(t, bc, address1))
+		assert.Equal(t, 13, balance(t, bc, address2))
+
+		pending, err := bc.PendingTransactions()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, tx2.ID, pending[0].ID)
+	})
+
+	t.Run("to genesis", func(t *testing.T) {
+		_, err := bc.Rollback(genesis)
+		require.NoError(t, err)
+
+		assert.Equal(t, []block.Hash{genesis}, bc.GetBlockHashes())
+		assert.Equal(t, 10, balance(t, bc, address1))
+		assert.Equal(t, 0, balance(t, bc, address2))
+
+		// tx2 spends the change of tx1, which is pending again and not in the UTXO set
+		pending, err := bc.PendingTransactions()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, tx1.ID, pending[0].ID)
+	})
+
+	t.Run("extend disconnected blocks", func(t *testing.T) {
+		b3 := newTestBlock(b2.Hash, block.Hash{'b', '3'}, coinbase(t, address2, "b3"))
+		require.NoError(t, bc.AddBlock(b3))
+
+		assert.Equal(t, []block.Hash{b3.Hash, b2.Hash, b1.Hash, genesis}, bc.GetBlockHashes())
+		assert.Equal(t, 2, balance(t, bc, address1))
+		assert.Equal(t, 38, balance(t, bc, address2))
+
+		pending, err := bc.PendingTransactions()
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+}
+
+// newTestBlock creates a block with the given hash, which the mock proof of work accepts.
+func newTestBlock(prevBlockHash, hash block.Hash, transactions ...*transaction.Tx) *block.Block {
+	b := &block.Block{
+		Header: block.Header{
+			Version:       block.HeaderVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+		},
+		Transactions: transactions,
+		Hash:         hash,
+	}
+	b.MerkleRoot = b.ComputeMerkleRoot()
+
+	return b
+}
+
+func coinbase(t *testing.T, address, data string) *transaction.Tx {
+	t.Helper()
+
+	tx, err := transaction.NewCoinbaseTX(address, data)
+	require.NoError(t, err)
+
+	return tx
+}
+
+func balance(t *testing.T, bc *blockchain.Blockchain, address string) int {
+	t.Helper()
+
+	pubKeyHash, err := wallet.GetHashFromAddress([]byte(address))
+	require.NoError(t, err)
+
+	outputs, err := bc.FindUnspentTxOutputs(pubKeyHash)
+	require.NoError(t, err)
+
+	total := 0
+	for _, out := range outputs {
+		total += int(out.Value)
+	}
+
+	return total
+}
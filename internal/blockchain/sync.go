@@ -0,0 +1,431 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+)
+
+const (
+	// MaxHeadersPerRequest is the largest number of headers a source returns for a single request.
+	MaxHeadersPerRequest = 2000
+	syncWorkers          = 8  // Number of blocks downloaded in parallel
+	syncBatchSize        = 64 // Number of blocks downloaded before they are added to the blockchain
+)
+
+// ErrCheckpoint is returned when a block conflicts with a checkpoint.
+var ErrCheckpoint = errors.New("block conflicts with a checkpoint")
+
+// Checkpoints are the hashes of known good blocks by their height.
+// A block at a checkpoint height must be the checkpointed block, and no block may fork the chain
+// below a checkpoint whose block is already known.
+type Checkpoints map[int]block.Hash
+
+// DefaultCheckpoints are the checkpoints compiled into the binary.
+// There are none, as every blockchain made by create-blockchain starts from its own genesis block.
+// Networks pin their genesis block with the genesis_hash setting of the config file instead.
+var DefaultCheckpoints = Checkpoints{} //nolint:gochecknoglobals // Hard-coded checkpoints
+
+// Source is a chain that a blockchain can be synced from, e.g. a peer or an exported chain directory.
+type Source interface {
+	// GetHeaders returns the headers of the source's active chain that follow the first block of the locator
+	// the source knows, oldest first and at most MaxHeadersPerRequest of them.
+	// If it knows none of the locator's blocks, the headers start at its genesis block.
+	// The headers are returned as blocks without transactions.
+	GetHeaders(ctx context.Context, locator []block.Hash) ([]*block.Block, error)
+	// GetBlock returns a block of the source with its transactions.
+	GetBlock(ctx context.Context, hash block.Hash) (*block.Block, error)
+}
+
+// AddCheckpoints adds checkpoints, e.g. configured ones, to the checkpoints the blockchain enforces.
+func (bc *Blockchain) AddCheckpoints(checkpoints Checkpoints) {
+	for height, hash := range checkpoints {
+		bc.checkpoints[height] = hash
+	}
+}
+
+// Sync brings the blockchain up to date with the source, starting from an empty blockchain if needed.
+// The headers of the source's chain are validated first, so that a chain with invalid proof of work
+// or one that conflicts with a checkpoint is rejected before any block is downloaded.
+// The blocks are then downloaded in parallel, checked against their headers and added in order.
+// If the sync fails, the stored headers of the blocks that were not added are removed again.
+// The lock set by SetLock is not held while waiting for the source.
+// It returns the number of blocks that were added.
+func (bc *Blockchain) Sync(ctx context.Context, source Source) (int, error) {
+	return bc.sync(ctx, source, bc.checkpoints)
+}
+
+// SyncWithCheckpoints syncs like Sync, enforcing the given checkpoints in addition to those of the blockchain.
+// The given checkpoints only apply to the headers of this sync, which the blocks of the sync must match.
+func (bc *Blockchain) SyncWithCheckpoints(ctx context.Context, source Source, checkpoints Checkpoints) (int, error) {
+	enforced := maps.Clone(bc.checkpoints)
+	maps.Copy(enforced, checkpoints)
+
+	return bc.sync(ctx, source, enforced)
+}
+
+// sync syncs from the source, validating its headers against the given checkpoints.
+func (bc *Blockchain) sync(ctx context.Context, source Source, checkpoints Checkpoints) (int, error) {
+	headers, err := bc.syncHeaders(ctx, source, checkpoints)
+	if err != nil {
+		return 0, errors.Join(err, bc.removeHeaders(headers))
+	}
+
+	added, err := bc.syncBlocks(ctx, source, headers)
+	if err != nil {
+		return added, errors.Join(err, bc.removeHeaders(headers))
+	}
+
+	return added, nil
+}
+
+// syncBlocks downloads and adds the blocks of the validated headers that are not stored yet.
+func (bc *Blockchain) syncBlocks(ctx context.Context, source Source, headers []*block.Block) (int, error) {
+	var missing []*block.Block
+	err := bc.locked(func() error {
+		for _, header := range headers {
+			if !bc.HasBlock(header.Hash) {
+				missing = append(missing, header)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for start := 0; start < len(missing); start += syncBatchSize {
+		batch := missing[start:min(start+syncBatchSize, len(missing))]
+
+		blocks, err := fetchBlocks(ctx, source, batch)
+		if err != nil {
+			return added, err
+		}
+
+		err = bc.locked(func() error {
+			for _, b := range blocks {
+				err := bc.AddBlock(b)
+				if err != nil {
+					return fmt.Errorf("failed to add block %x: %w", b.Hash, err)
+				}
+				added++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return added, err
+		}
+	}
+
+	return added, nil
+}
+
+// syncHeaders downloads and validates the headers of the source's chain that follow the active chain.
+// On failure, the headers stored so far are returned with the error.
+func (bc *Blockchain) syncHeaders(ctx context.Context, source Source, checkpoints Checkpoints) ([]*block.Block, error) {
+	var locator []block.Hash
+	err := bc.locked(func() error {
+		var err error
+		locator, err = bc.locator()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []*block.Block
+	for {
+		batch, err := source.GetHeaders(ctx, locator)
+		if err != nil {
+			return headers, fmt.Errorf("failed to get headers: %w", err)
+		}
+
+		err = bc.locked(func() error {
+			for _, header := range batch {
+				if len(headers) > 0 && header.PrevBlockHash != headers[len(headers)-1].Hash {
+					return fmt.Errorf("header %x does not extend the previous header", header.Hash)
+				}
+
+				err := bc.addHeader(header, checkpoints)
+				if err != nil {
+					return fmt.Errorf("invalid header %x: %w", header.Hash, err)
+				}
+
+				headers = append(headers, header)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return headers, err
+		}
+
+		if len(batch) < MaxHeadersPerRequest {
+			return headers, nil
+		}
+
+		locator = []block.Hash{headers[len(headers)-1].Hash}
+	}
+}
+
+// addHeader validates a header received during sync and stores it with its chain metadata,
+// so that the headers following it can be validated before any block is downloaded.
+func (bc *Blockchain) addHeader(header *block.Block, checkpoints Checkpoints) error {
+	if _, err := bc.storage.GetBlockMeta(header.Hash); err == nil {
+		return nil // Already validated
+	}
+
+	meta := block.Meta{Height: 0, Work: bc.powFactory.Work(header)}
+	if header.PrevBlockHash != *new(block.Hash) {
+		parentMeta, err := bc.storage.GetBlockMeta(header.PrevBlockHash)
+		if err != nil {
+			return fmt.Errorf("%w: %x", ErrUnknownParent, header.PrevBlockHash)
+		}
+
+		meta.Height = parentMeta.Height + 1
+		meta.Work.Add(meta.Work, parentMeta.Work)
+	} else if bc.GetBestHeight() >= 0 {
+		return errors.New("source has a different genesis block")
+	}
+
+	err := bc.checkCheckpoints(checkpoints, header.Hash, meta.Height)
+	if err != nil {
+		return err
+	}
+
+	if !bc.powFactory.Validate(header) {
+		return fmt.Errorf("%w: proof of work is not valid", ErrInvalidBlock)
+	}
+
+	err = bc.checkTimestamp(header)
+	if err != nil {
+		return err
+	}
+
+	return bc.storage.Update(func(s Storage) error {
+		err := s.AddHeader(header.Hash, header.Header)
+		if err != nil {
+			return fmt.Errorf("failed to store header: %w", err)
+		}
+
+		err = s.SetBlockMeta(header.Hash, meta)
+		if err != nil {
+			return fmt.Errorf("failed to set block meta: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// removeHeaders removes the headers of a failed sync whose blocks were not added,
+// so that no chain metadata is left behind for blocks that are not stored.
+func (bc *Blockchain) removeHeaders(headers []*block.Block) error {
+	return bc.locked(func() error {
+		for _, header := range headers {
+			err := bc.storage.Update(func(s Storage) error {
+				if _, err := s.GetBlock(header.Hash); err == nil {
+					return nil
+				}
+
+				return s.DeleteHeader(header.Hash)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to remove header %x: %w", header.Hash, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkCheckpoints checks a block that is not known yet against the given checkpoints.
+func (bc *Blockchain) checkCheckpoints(checkpoints Checkpoints, hash block.Hash, height int) error {
+	if checkpoint, ok := checkpoints[height]; ok && checkpoint != hash {
+		return fmt.Errorf("%w: expected block %x at height %d", ErrCheckpoint, checkpoint, height)
+	}
+
+	for checkpointHeight, checkpoint := range checkpoints {
+		if height >= checkpointHeight {
+			continue
+		}
+
+		// The checkpointed chain below a known checkpoint is known too, so a new block below it is a fork
+		if _, err := bc.storage.GetBlockMeta(checkpoint); err == nil {
+			return fmt.Errorf("%w: block at height %d forks the chain below the checkpoint at height %d",
+				ErrCheckpoint, height, checkpointHeight)
+		}
+	}
+
+	return nil
+}
+
+// locator returns hashes of the active chain, from the tip back to the genesis block,
+// that a source uses to find where its chain forks from the active chain.
+// The first ten blocks are listed one by one, after that the step doubles with each hash.
+func (bc *Blockchain) locator() ([]block.Hash, error) {
+	var locator []block.Hash
+
+	step := 1
+	for height := bc.GetBestHeight(); height >= 0; height = max(height-step, 0) {
+		hash, err := bc.storage.GetBlockHashByHeight(height)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hash of block at height %d: %w", height, err)
+		}
+		locator = append(locator, hash)
+
+		if height == 0 {
+			break
+		}
+
+		if len(locator) >= 10 { //nolint:mnd // Recent blocks are listed one by one
+			step *= 2
+		}
+	}
+
+	return locator, nil
+}
+
+// GetHeaders returns the headers of the active chain that follow the first block of the locator that is known,
+// see Source.
+func (bc *Blockchain) GetHeaders(locator []block.Hash) ([]*block.Block, error) {
+	return headersAfter(bc.storage, locator)
+}
+
+// headersAfter returns the headers of the storage's active chain that follow the first block of the locator
+// that is part of it.
+func headersAfter(storage block.Storage, locator []block.Hash) ([]*block.Block, error) {
+	tip, err := storage.GetTip()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tip of blockchain: %w", err)
+	}
+
+	if tip == *new(block.Hash) {
+		return nil, nil
+	}
+
+	tipMeta, err := storage.GetBlockMeta(tip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tip block meta: %w", err)
+	}
+
+	start := 0
+	for _, hash := range locator {
+		meta, err := storage.GetBlockMeta(hash)
+		if err != nil {
+			continue
+		}
+
+		active, err := storage.GetBlockHashByHeight(meta.Height)
+		if err == nil && active == hash {
+			start = meta.Height + 1
+			break
+		}
+	}
+
+	var headers []*block.Block
+	for height := start; height <= tipMeta.Height && len(headers) < MaxHeadersPerRequest; height++ {
+		hash, err := storage.GetBlockHashByHeight(height)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hash of block at height %d: %w", height, err)
+		}
+
+		header, err := storage.GetHeader(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get header %x: %w", hash, err)
+		}
+
+		headers = append(headers, &block.Block{Header: *header, Hash: hash})
+	}
+
+	return headers, nil
+}
+
+// fetchBlocks downloads the blocks of the given headers in parallel
+// and checks that each one matches its header.
+func fetchBlocks(ctx context.Context, source Source, headers []*block.Block) ([]*block.Block, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	blocks := make([]*block.Block, len(headers))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(syncWorkers, len(headers)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				b, err := fetchBlock(ctx, source, headers[i])
+				if err != nil {
+					cancel(err) // The batch cannot be added anyway, stop the other downloads
+					continue
+				}
+				blocks[i] = b
+			}
+		}()
+	}
+
+	for i := range headers {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// fetchBlock downloads the block of a header and checks that its transactions match the header's Merkle root.
+func fetchBlock(ctx context.Context, source Source, header *block.Block) (*block.Block, error) {
+	b, err := source.GetBlock(ctx, header.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block %x: %w", header.Hash, err)
+	}
+
+	if b.Hash != header.Hash || b.Header != header.Header {
+		return nil, fmt.Errorf("%w: block %x does not match its header", ErrInvalidBlock, header.Hash)
+	}
+
+	if b.ComputeMerkleRoot() != header.MerkleRoot {
+		return nil, fmt.Errorf("%w: transactions of block %x do not match the merkle root", ErrInvalidBlock, header.Hash)
+	}
+
+	return b, nil
+}
+
+type storageSource struct {
+	storage block.Storage
+}
+
+// NewStorageSource creates a source that serves the active chain of another storage,
+// e.g. one opened from an exported chain directory.
+func NewStorageSource(storage block.Storage) Source {
+	return &storageSource{storage: storage}
+}
+
+func (s *storageSource) GetHeaders(ctx context.Context, locator []block.Hash) ([]*block.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return headersAfter(s.storage, locator)
+}
+
+func (s *storageSource) GetBlock(ctx context.Context, hash block.Hash) (*block.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetBlock(hash)
+}
@@ -0,0 +1,210 @@
+package blockchain_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tamperingSource serves the blocks of another source, replacing the transactions of one of them.
+type tamperingSource struct {
+	blockchain.Source
+	hash block.Hash
+	tx   *transaction.Tx
+}
+
+func (s tamperingSource) GetBlock(ctx context.Context, hash block.Hash) (*block.Block, error) {
+	b, err := s.Source.GetBlock(ctx, hash)
+	if err != nil || hash != s.hash {
+		return b, err
+	}
+
+	b.Transactions = []*transaction.Tx{s.tx}
+	return b, nil
+}
+
+// headerSource serves a chain of headers without their blocks, failing the given call of GetHeaders.
+type headerSource struct {
+	headers []*block.Block
+	failOn  int
+	calls   int
+}
+
+func (s *headerSource) GetHeaders(_ context.Context, locator []block.Hash) ([]*block.Block, error) {
+	s.calls++
+	if s.calls == s.failOn {
+		return nil, errors.New("connection lost")
+	}
+
+	start := 0
+	for i, header := range s.headers {
+		if len(locator) > 0 && header.Hash == locator[0] {
+			start = i + 1
+		}
+	}
+
+	return s.headers[start:min(start+blockchain.MaxHeadersPerRequest, len(s.headers))], nil
+}
+
+func (s *headerSource) GetBlock(context.Context, block.Hash) (*block.Block, error) {
+	return nil, errors.New("no blocks")
+}
+
+func TestSync(t *testing.T) {
+	powFactory := mock.NewPoWFactory()
+
+	storageA := mock.NewStorage()
+	walletsA := wallet.NewCollection(storageA)
+	address1, err := walletsA.AddWallet()
+	require.NoError(t, err)
+	address2, err := walletsA.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storageA, powFactory, address1))
+	bcA, err := blockchain.LoadBlockchain(storageA, powFactory, walletsA)
+	require.NoError(t, err)
+
+	tx, err := bcA.NewUTXOTransaction(address1, address2, 7, 0)
+	require.NoError(t, err)
+	_, err = bcA.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "a1"), tx})
+	require.NoError(t, err)
+	a2, err := bcA.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address2, "a2")})
+	require.NoError(t, err)
+
+	source := blockchain.NewStorageSource(storageA)
+
+	newEmptyBlockchain := func() *blockchain.Blockchain {
+		storage := mock.NewStorage()
+		return blockchain.NewBlockchain(storage, powFactory, wallet.NewCollection(storage))
+	}
+
+	t.Run("empty blockchain", func(t *testing.T) {
+		bcB := newEmptyBlockchain()
+
+		added, err := bcB.Sync(t.Context(), source)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, added)
+		assert.Equal(t, bcA.GetBlockHashes(), bcB.GetBlockHashes())
+		assert.Equal(t, 13, balance(t, bcB, address1))
+		assert.Equal(t, 17, balance(t, bcB, address2))
+
+		t.Run("up to date", func(t *testing.T) {
+			added, err := bcB.Sync(t.Context(), source)
+			require.NoError(t, err)
+			assert.Equal(t, 0, added)
+		})
+
+		t.Run("new blocks", func(t *testing.T) {
+			_, err := bcA.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "a3")})
+			require.NoError(t, err)
+
+			added, err := bcB.Sync(t.Context(), source)
+			require.NoError(t, err)
+
+			assert.Equal(t, 1, added)
+			assert.Equal(t, bcA.GetBlockHashes(), bcB.GetBlockHashes())
+		})
+	})
+
+	t.Run("block does not match its header", func(t *testing.T) {
+		storageB := mock.NewStorage()
+		bcB := blockchain.NewBlockchain(storageB, powFactory, wallet.NewCollection(storageB))
+
+		tampered := tamperingSource{Source: source, hash: a2.Hash, tx: coinbase(t, address1, "tampered")}
+		_, err := bcB.Sync(t.Context(), tampered)
+		require.ErrorIs(t, err, blockchain.ErrInvalidBlock)
+
+		assert.False(t, bcB.HasBlock(a2.Hash))
+		_, err = storageB.GetHeader(a2.Hash)
+		require.Error(t, err, "the header of the rejected block is removed")
+		_, err = storageB.GetBlockMeta(a2.Hash)
+		require.Error(t, err)
+	})
+
+	t.Run("genesis block of another network", func(t *testing.T) {
+		bcB := newEmptyBlockchain()
+		bcB.SetGenesisData("Another network")
+
+		added, err := bcB.Sync(t.Context(), source)
+		require.ErrorIs(t, err, blockchain.ErrInvalidBlock)
+
+		assert.Equal(t, 0, added)
+		assert.Equal(t, -1, bcB.GetBestHeight())
+	})
+
+	t.Run("headers request fails after the first batch", func(t *testing.T) {
+		failing := &headerSource{failOn: 2}
+		var prev block.Hash
+		for i := range blockchain.MaxHeadersPerRequest + 1 {
+			header := newTestBlock(prev, block.Hash{'h', byte(i >> 8), byte(i)})
+			failing.headers = append(failing.headers, header)
+			prev = header.Hash
+		}
+
+		storageB := mock.NewStorage()
+		bcB := blockchain.NewBlockchain(storageB, powFactory, wallet.NewCollection(storageB))
+
+		_, err := bcB.Sync(t.Context(), failing)
+		require.ErrorContains(t, err, "connection lost")
+		assert.Equal(t, 2, failing.calls)
+
+		for _, header := range failing.headers {
+			_, err := storageB.GetBlockMeta(header.Hash)
+			require.Error(t, err, "the headers of the first batch are removed")
+		}
+	})
+
+	t.Run("checkpoint mismatch", func(t *testing.T) {
+		storageB := mock.NewStorage()
+		bcB := blockchain.NewBlockchain(storageB, powFactory, wallet.NewCollection(storageB))
+		bcB.AddCheckpoints(blockchain.Checkpoints{1: {'x'}})
+
+		added, err := bcB.Sync(t.Context(), source)
+		require.ErrorIs(t, err, blockchain.ErrCheckpoint)
+
+		assert.Equal(t, 0, added)
+		assert.Equal(t, -1, bcB.GetBestHeight())
+
+		genesis, err := bcA.GetBlockByHeight(0)
+		require.NoError(t, err)
+		_, err = storageB.GetBlockMeta(genesis.Hash)
+		require.Error(t, err, "the headers validated before the mismatch are removed")
+	})
+
+	t.Run("checkpoints of one sync", func(t *testing.T) {
+		bcB := newEmptyBlockchain()
+
+		_, err := bcB.SyncWithCheckpoints(t.Context(), source, blockchain.Checkpoints{1: {'x'}})
+		require.ErrorIs(t, err, blockchain.ErrCheckpoint)
+
+		added, err := bcB.Sync(t.Context(), source)
+		require.NoError(t, err)
+		assert.Equal(t, bcA.GetBestHeight()+1, added)
+	})
+
+	t.Run("fork below checkpoint", func(t *testing.T) {
+		bcB := newEmptyBlockchain()
+		bcB.AddCheckpoints(blockchain.Checkpoints{2: a2.Hash})
+
+		_, err := bcB.Sync(t.Context(), source)
+		require.NoError(t, err)
+
+		genesis, err := bcB.GetBlockByHeight(0)
+		require.NoError(t, err)
+
+		fork := newTestBlock(genesis.Hash, block.Hash{'f', '1'}, coinbase(t, address2, "f1"))
+		require.ErrorIs(t, bcB.AddBlock(fork), blockchain.ErrCheckpoint)
+
+		assert.False(t, bcB.HasBlock(fork.Hash))
+		assert.Equal(t, bcA.GetBlockHashes(), bcB.GetBlockHashes())
+	})
+}
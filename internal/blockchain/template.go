@@ -0,0 +1,170 @@
+package blockchain
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+// DefaultMaxBlockSize is the default limit for the serialized size of a block's transactions, in bytes.
+const DefaultMaxBlockSize = 1 << 20
+
+// BlockTemplate holds the transactions selected for the next block.
+type BlockTemplate struct {
+	// Transactions are the transactions of the block, starting with the coinbase.
+	Transactions []*transaction.Tx
+	// Fees is the sum of the fees of the included transactions, claimed by the coinbase.
+	Fees int32
+	// Size is the serialized size of the transactions, in bytes.
+	Size int
+}
+
+// candidate is a pending transaction considered for inclusion in a block template.
+type candidate struct {
+	tx   *transaction.Tx
+	fee  int32
+	size int
+}
+
+// NewBlockTemplate selects pending transactions for the next block on top of the current tip.
+// Transactions are ordered by fee rate and added while the block stays within maxBlockSize bytes.
+// The coinbase pays the block subsidy and the collected fees to rewardAddress.
+// Pending transactions that are no longer valid, that spend an output already spent
+// by a selected transaction or whose fee would take the coinbase beyond an int32 are skipped.
+func (bc *Blockchain) NewBlockTemplate(rewardAddress string, maxBlockSize int) (*BlockTemplate, error) {
+	height := bc.GetBestHeight() + 1
+	if height == 0 {
+		return nil, errors.New("tip is empty")
+	}
+
+	pending, err := bc.mempool.Transactions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	candidates := make([]candidate, 0, len(pending))
+	for _, tx := range pending {
+		if bc.ValidateTransaction(tx) != nil {
+			continue
+		}
+
+		fee, err := bc.TransactionFee(tx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute fee of transaction %x: %w", tx.ID, err)
+		}
+
+		candidates = append(candidates, candidate{tx: tx, fee: fee, size: len(tx.Serialize())})
+	}
+
+	// Highest fee per byte first, fee_a/size_a > fee_b/size_b is compared without division
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(int64(b.fee)*int64(a.size), int64(a.fee)*int64(b.size))
+	})
+
+	// The fees are only known after the transactions are selected, so the size of the coinbase is reserved
+	// with the fee-less coinbase. Output values have a fixed width, so the fees do not change its size.
+	data := coinbaseData(height, rewardAddress)
+	cbTx, err := transaction.NewCoinbaseTX(rewardAddress, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coinbase transaction: %w", err)
+	}
+
+	template := &BlockTemplate{Size: len(cbTx.Serialize())}
+	if template.Size > maxBlockSize {
+		return nil, fmt.Errorf("coinbase transaction does not fit into %d bytes", maxBlockSize)
+	}
+
+	var txs []*transaction.Tx
+	spent := make(map[transaction.Outpoint]struct{})
+	for _, c := range candidates {
+		if template.Size+c.size > maxBlockSize {
+			continue // A smaller transaction may still fit
+		}
+
+		if int64(transaction.Subsidy)+int64(template.Fees)+int64(c.fee) > math.MaxInt32 {
+			continue // The coinbase could not pay the fees
+		}
+
+		if !spendOutpoints(c.tx, spent) {
+			continue
+		}
+
+		txs = append(txs, c.tx)
+		template.Size += c.size
+		template.Fees += c.fee
+	}
+
+	cbTx, err = transaction.NewCoinbaseTXWithFees(rewardAddress, data, template.Fees)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coinbase transaction: %w", err)
+	}
+
+	template.Transactions = append([]*transaction.Tx{cbTx}, txs...)
+
+	return template, nil
+}
+
+// Mine builds a block template paying rewardAddress and mines a block from it.
+func (bc *Blockchain) Mine(ctx context.Context, rewardAddress string, maxBlockSize int) (*block.Block, error) {
+	var template *BlockTemplate
+	err := bc.locked(func() error {
+		var err error
+		template, err = bc.NewBlockTemplate(rewardAddress, maxBlockSize)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build block template: %w", err)
+	}
+
+	return bc.MineBlock(ctx, template.Transactions)
+}
+
+// TransactionFee returns the difference between the values of a transaction's inputs and outputs.
+func (bc *Blockchain) TransactionFee(tx *transaction.Tx) (int32, error) {
+	prevTXs := make(map[transaction.TxID]*transaction.Tx)
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.Vin {
+			prevTX, err := bc.findTransaction(vin.TxID)
+			if err != nil {
+				return 0, fmt.Errorf("failed to find previous transaction %x: %w", vin.TxID, err)
+			}
+			prevTXs[prevTX.ID] = prevTX
+		}
+	}
+
+	return tx.Fee(prevTXs)
+}
+
+// spendOutpoints adds the outputs spent by a transaction to spent.
+// It returns false and leaves spent unchanged if the transaction spends an output that is already in spent
+// or spends an output more than once.
+func spendOutpoints(tx *transaction.Tx, spent map[transaction.Outpoint]struct{}) bool {
+	own := make(map[transaction.Outpoint]struct{}, len(tx.Vin))
+	for _, vin := range tx.Vin {
+		op := vin.Outpoint()
+		if _, ok := spent[op]; ok {
+			return false
+		}
+		if _, ok := own[op]; ok {
+			return false
+		}
+		own[op] = struct{}{}
+	}
+
+	maps.Copy(spent, own)
+
+	return true
+}
+
+// coinbaseData returns the coinbase data for a block at the given height.
+// Including the height keeps the IDs of coinbase transactions paying the same address unique.
+func coinbaseData(height int, address string) string {
+	return fmt.Sprintf("Height %d, reward to '%s'", height, address)
+}
@@ -0,0 +1,138 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockTemplate(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, mock.NewPoWFactory(), address1))
+	bc, err := blockchain.LoadBlockchain(storage, mock.NewPoWFactory(), wallets)
+	require.NoError(t, err)
+	require.NoError(t, bc.ReindexUTXOSet())
+
+	// Empty blocks only pay the subsidy, each with a coinbase of its own
+	var coinbases []*transaction.Tx
+	for range 3 {
+		b, err := bc.Mine(t.Context(), address1, blockchain.DefaultMaxBlockSize)
+		require.NoError(t, err)
+		require.Len(t, b.Transactions, 1)
+		coinbases = append(coinbases, b.Transactions[0])
+	}
+	assert.Equal(t, 40, balance(t, bc, address1))
+
+	low := spendWithFee(t, wallets, coinbases[0], address1, address2, 1)
+	high := spendWithFee(t, wallets, coinbases[1], address1, address2, 3)
+	medium := spendWithFee(t, wallets, coinbases[2], address1, address2, 2)
+	for _, tx := range []*transaction.Tx{low, high, medium} {
+		require.NoError(t, bc.SubmitTransaction(tx))
+	}
+
+	t.Run("ordered by fee rate", func(t *testing.T) {
+		template, err := bc.NewBlockTemplate(address1, blockchain.DefaultMaxBlockSize)
+		require.NoError(t, err)
+
+		require.Len(t, template.Transactions, 4)
+		assert.True(t, template.Transactions[0].IsCoinbase())
+		assert.Equal(t, []*transaction.Tx{high, medium, low}, template.Transactions[1:])
+		assert.Equal(t, int32(6), template.Fees)
+		assert.Equal(t, int32(16), template.Transactions[0].Vout[0].Value)
+	})
+
+	t.Run("maximum block size", func(t *testing.T) {
+		full, err := bc.NewBlockTemplate(address1, blockchain.DefaultMaxBlockSize)
+		require.NoError(t, err)
+
+		template, err := bc.NewBlockTemplate(address1, full.Size-len(low.Serialize()))
+		require.NoError(t, err)
+
+		assert.Equal(t, []*transaction.Tx{high, medium}, template.Transactions[1:])
+		assert.Equal(t, int32(5), template.Fees)
+		assert.LessOrEqual(t, template.Size, full.Size-len(low.Serialize()))
+	})
+
+	t.Run("mine", func(t *testing.T) {
+		b, err := bc.Mine(t.Context(), address1, blockchain.DefaultMaxBlockSize)
+		require.NoError(t, err)
+		assert.Len(t, b.Transactions, 4)
+
+		pending, err := bc.PendingTransactions()
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+
+		assert.Equal(t, 10+10+6, balance(t, bc, address1))
+		assert.Equal(t, 9+7+8, balance(t, bc, address2))
+	})
+}
+
+// spendWithFee creates a transaction that moves the first output of prevTx to another address, minus a fee.
+func TestBlockTemplateSkipsConflicts(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, mock.NewPoWFactory(), address1))
+	bc, err := blockchain.LoadBlockchain(storage, mock.NewPoWFactory(), wallets)
+	require.NoError(t, err)
+	require.NoError(t, bc.ReindexUTXOSet())
+
+	b, err := bc.Mine(t.Context(), address1, blockchain.DefaultMaxBlockSize)
+	require.NoError(t, err)
+	genesis, err := bc.GetBlock(b.PrevBlockHash)
+	require.NoError(t, err)
+
+	// Conflicting transactions bypass the pool's own checks by being stored directly
+	low := spendWithFee(t, wallets, genesis.Transactions[0], address1, address2, 1)
+	high := spendWithFee(t, wallets, genesis.Transactions[0], address1, address2, 2)
+	twice := spendWithFee(t, wallets, b.Transactions[0], address1, address2, 1)
+	twice.Vin = append(twice.Vin, twice.Vin[0])
+	for _, tx := range []*transaction.Tx{low, high, twice} {
+		require.NoError(t, storage.AddPendingTx(*tx))
+	}
+
+	template, err := bc.NewBlockTemplate(address1, blockchain.DefaultMaxBlockSize)
+	require.NoError(t, err)
+	assert.Equal(t, []*transaction.Tx{high}, template.Transactions[1:])
+	assert.Equal(t, int32(2), template.Fees)
+
+	_, err = bc.MineBlock(t.Context(), template.Transactions)
+	require.NoError(t, err)
+}
+
+func spendWithFee(
+	t *testing.T,
+	wallets *wallet.Collection,
+	prevTx *transaction.Tx,
+	from, to string,
+	fee int32,
+) *transaction.Tx {
+	t.Helper()
+
+	wlt, err := wallets.GetWallet(from)
+	require.NoError(t, err)
+
+	tx := &transaction.Tx{
+		Vin:  []transaction.TxInput{{TxID: prevTx.ID, Vout: 0, PubKey: wlt.PublicKey}},
+		Vout: []transaction.TxOutput{transaction.NewTxOutput(prevTx.Vout[0].Value-fee, to)},
+	}
+	tx.ID = tx.Hash()
+	require.NoError(t, tx.Sign(wlt.PrivateKey, map[transaction.TxID]*transaction.Tx{prevTx.ID: prevTx}))
+
+	return tx
+}
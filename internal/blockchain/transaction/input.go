@@ -28,3 +28,16 @@ func (in *TxInput) UsesKey(pubKeyHash []byte) (bool, error) {
 
 	return bytes.Equal(lockingHash, pubKeyHash), nil
 }
+
+// Outpoint identifies a single output of a transaction.
+type Outpoint struct {
+	// TxID is the ID of the transaction that created the output.
+	TxID TxID
+	// Vout is the index of the output in the transaction.
+	Vout int
+}
+
+// Outpoint returns the outpoint of the output spent by the input.
+func (in *TxInput) Outpoint() Outpoint {
+	return Outpoint{TxID: in.TxID, Vout: in.Vout}
+}
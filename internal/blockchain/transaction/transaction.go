@@ -6,15 +6,22 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"strings"
 )
 
 const (
-	subsidy = 10
+	// Subsidy is the amount of new coins a coinbase transaction may create.
+	Subsidy = 10
 )
 
+// ErrInvalidEncoding is returned when a serialized transaction is truncated or has trailing data.
+var ErrInvalidEncoding = errors.New("invalid transaction encoding")
+
 type TxID [32]byte
 
 // Tx represents a transaction in the blockchain.
@@ -31,7 +38,19 @@ type Tx struct {
 	Vout []TxOutput
 }
 
+// NewCoinbaseTX creates a coinbase transaction that pays the block subsidy to the given address.
 func NewCoinbaseTX(to, data string) (*Tx, error) {
+	return NewCoinbaseTXWithFees(to, data, 0)
+}
+
+// NewCoinbaseTXWithFees creates a coinbase transaction that pays the block subsidy
+// and the fees collected from the block's transactions to the given address.
+// It fails if the subsidy and the fees add up to more than an int32 can hold.
+func NewCoinbaseTXWithFees(to, data string, fees int32) (*Tx, error) {
+	if fees > math.MaxInt32-Subsidy {
+		return nil, fmt.Errorf("subsidy and fees add up to more than %d", math.MaxInt32)
+	}
+
 	if data == "" {
 		data = fmt.Sprintf("Reward to '%s'", to)
 	}
@@ -42,7 +61,7 @@ func NewCoinbaseTX(to, data string) (*Tx, error) {
 		Signature: nil,
 		PubKey:    []byte(data),
 	}
-	txout := NewTxOutput(subsidy, to)
+	txout := NewTxOutput(Subsidy+fees, to)
 	tx := Tx{
 		ID:   TxID{},
 		Vin:  []TxInput{txin},
@@ -58,19 +77,134 @@ func (tx *Tx) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && tx.Vin[0].TxID == TxID{} && tx.Vin[0].Vout == -1
 }
 
-// Serialize serializes the transaction into a byte slice using gob encoding.
+// Serialize encodes the transaction in a fixed big-endian layout: the ID, the number of inputs followed by
+// the inputs and the number of outputs followed by the outputs. Byte slices are prefixed with their length.
+// Unlike gob, the layout does not depend on the order types were first encoded in, so the transaction hash
+// is the same in every process.
 func (tx Tx) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
+	data := append([]byte{}, tx.ID[:]...)
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tx.Vin))) //nolint:gosec // Lengths fit in 32 bits
+	for _, in := range tx.Vin {
+		data = append(data, in.TxID[:]...)
+		data = binary.BigEndian.AppendUint64(data, uint64(in.Vout)) //nolint:gosec // Coinbase inputs use -1
+		data = appendBytes(data, in.Signature)
+		data = appendBytes(data, in.PubKey)
+	}
 
-	err := encoder.Encode(tx)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tx.Vout))) //nolint:gosec // Lengths fit in 32 bits
+	for _, out := range tx.Vout {
+		data = binary.BigEndian.AppendUint32(data, uint32(out.Value)) //nolint:gosec // Decoded back to int32
+		data = appendBytes(data, out.PubKeyHash)
+	}
+
+	return data
+}
+
+// Deserialize decodes a transaction from its fixed layout.
+func (tx *Tx) Deserialize(d []byte) error {
+	r := reader{data: d}
+
+	decoded, err := readTx(&r)
 	if err != nil {
-		// Error will only occur if the input contains unsupported types.
-		// In this case, we panic because the block should only contain serializable types.
-		panic(err)
+		return err
+	}
+
+	if len(r.data) != 0 {
+		return ErrInvalidEncoding
+	}
+
+	*tx = *decoded
+
+	return nil
+}
+
+// readTx decodes a transaction from the start of the reader's data.
+func readTx(r *reader) (*Tx, error) {
+	var decoded Tx
+	copy(decoded.ID[:], r.next(len(decoded.ID)))
+
+	for range r.uint32() {
+		var in TxInput
+		copy(in.TxID[:], r.next(len(in.TxID)))
+		in.Vout = int(int64(r.uint64())) //nolint:gosec // Encoded from an int
+		in.Signature = r.bytes()
+		in.PubKey = r.bytes()
+
+		if r.err != nil {
+			return nil, r.err
+		}
+		decoded.Vin = append(decoded.Vin, in)
+	}
+
+	for range r.uint32() {
+		var out TxOutput
+		out.Value = int32(r.uint32()) //nolint:gosec // Encoded from an int32
+		out.PubKeyHash = r.bytes()
+
+		if r.err != nil {
+			return nil, r.err
+		}
+		decoded.Vout = append(decoded.Vout, out)
 	}
 
-	return result.Bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return &decoded, nil
+}
+
+// appendBytes appends a byte slice prefixed with its length.
+func appendBytes(data, b []byte) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(b))) //nolint:gosec // Lengths fit in 32 bits
+	return append(data, b...)
+}
+
+// reader decodes the fixed layout of a transaction. After the first error it only returns zero values.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = ErrInvalidEncoding
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.next(4) //nolint:mnd // Length of a uint32
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.next(8) //nolint:mnd // Length of a uint64
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+// bytes reads a byte slice prefixed with its length, an empty slice is returned as nil.
+func (r *reader) bytes() []byte {
+	n := r.uint32()
+	if n == 0 {
+		return nil
+	}
+
+	return bytes.Clone(r.next(int(n)))
 }
 
 // Hash returns the hash of the transaction.
@@ -85,7 +219,51 @@ func (tx *Tx) Hash() TxID {
 	return hash
 }
 
+// Fee returns the difference between the values of the transaction's inputs and outputs.
+// prevTXs must contain the transactions whose outputs are spent.
+// It fails if an output has a negative value, the outputs exceed the inputs
+// or the inputs add up to more than an int32 can hold.
+func (tx *Tx) Fee(prevTXs map[TxID]*Tx) (int32, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	var in, out int64
+	for _, vin := range tx.Vin {
+		prevTx, ok := prevTXs[vin.TxID]
+		if !ok {
+			return 0, fmt.Errorf("previous transaction %x is missing", vin.TxID)
+		}
+
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return 0, fmt.Errorf("output %x:%d does not exist", vin.TxID, vin.Vout)
+		}
+
+		in += int64(prevTx.Vout[vin.Vout].Value)
+	}
+
+	for i, vout := range tx.Vout {
+		if vout.Value < 0 {
+			return 0, fmt.Errorf("output %d has a negative value", i)
+		}
+
+		out += int64(vout.Value)
+	}
+
+	if in > math.MaxInt32 {
+		return 0, fmt.Errorf("inputs add up to %d, more than %d", in, math.MaxInt32)
+	}
+
+	if out > in {
+		return 0, fmt.Errorf("outputs exceed inputs: %d > %d", out, in)
+	}
+
+	return int32(in - out), nil //nolint:gosec // Both totals are within int32
+}
+
 // Sign signs the transaction inputs using the provided private key.
+// The transaction ID is recomputed afterwards, so it covers the signatures as well.
+// Signatures use the lower of the two valid s values, see Verify.
 func (tx *Tx) Sign(privKey ecdsa.PrivateKey, prevTXs map[TxID]*Tx) error {
 	if tx.IsCoinbase() {
 		return nil // Coinbase transactions do not require signing
@@ -110,15 +288,27 @@ func (tx *Tx) Sign(privKey ecdsa.PrivateKey, prevTXs map[TxID]*Tx) error {
 		if err != nil {
 			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		if n := privKey.Curve.Params().N; s.Cmp(halfOrder(n)) > 0 {
+			s.Sub(n, s)
+		}
+		// Both halves are padded to the curve's byte length, so that the signature can be split in half
+		byteLen := (privKey.Curve.Params().BitSize + 7) / 8 //nolint:mnd // Magic number for byte length
+		signature := make([]byte, 2*byteLen)
+		r.FillBytes(signature[:byteLen])
+		s.FillBytes(signature[byteLen:])
 
 		tx.Vin[inID].Signature = signature
 	}
 
+	tx.ID = tx.Hash()
+
 	return nil
 }
 
 // Verify checks the validity of the transaction against previous transactions.
+// Since the ID covers the signatures, a signature (r, s) is only accepted with s in the lower half
+// of the curve order and with both halves padded to the curve's byte length. Otherwise anyone could
+// change the ID of a signed transaction by replacing s with n-s or by padding the signature.
 func (tx *Tx) Verify(prevTXs map[TxID]*Tx) bool {
 	if tx.IsCoinbase() {
 		return true
@@ -132,25 +322,37 @@ func (tx *Tx) Verify(prevTXs map[TxID]*Tx) bool {
 
 	txCopy := tx.trimmedCopy()
 	curve := elliptic.P256()
+	byteLen := (curve.Params().BitSize + 7) / 8 //nolint:mnd // Magic number for byte length
 
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[vin.TxID]
+
+		// The public key must be the one the spent output is locked with
+		if ok, err := vin.UsesKey(prevTx.Vout[vin.Vout].PubKeyHash); err != nil || !ok {
+			return false
+		}
+
 		txCopy.Vin[inID].Signature = nil                           // Clear the signature for verification
 		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash // Use the public key hash from the previous output
 		txCopy.ID = txCopy.Hash()                                  // Recalculate the transaction ID
 		txCopy.Vin[inID].PubKey = nil                              // Clear the public key for verification
 
+		if len(vin.Signature) != 2*byteLen || len(vin.PubKey) != 2*byteLen {
+			return false
+		}
+
 		r := big.Int{}
 		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)]) //nolint:mnd // Read the first half of the signature
-		s.SetBytes(vin.Signature[(sigLen / 2):]) //nolint:mnd // Read the second half of the signature
+		r.SetBytes(vin.Signature[:byteLen]) // Read the first half of the signature
+		s.SetBytes(vin.Signature[byteLen:]) // Read the second half of the signature
+		if s.Cmp(halfOrder(curve.Params().N)) > 0 {
+			return false
+		}
 
 		x := big.Int{}
 		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)]) //nolint:mnd // Read the first half of the public key
-		y.SetBytes(vin.PubKey[(keyLen / 2):]) //nolint:mnd // Read the second half of the public key
+		x.SetBytes(vin.PubKey[:byteLen]) // Read the first half of the public key
+		y.SetBytes(vin.PubKey[byteLen:]) // Read the second half of the public key
 
 		rawPubKey := ecdsa.PublicKey{
 			Curve: curve,
@@ -166,6 +368,34 @@ func (tx *Tx) Verify(prevTXs map[TxID]*Tx) bool {
 	return true
 }
 
+// halfOrder returns half of the curve order n, the largest s a signature may have.
+func halfOrder(n *big.Int) *big.Int {
+	return new(big.Int).Rsh(n, 1)
+}
+
+// String returns a human-readable representation of the transaction.
+func (tx Tx) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+
+	for i, input := range tx.Vin {
+		lines = append(lines, fmt.Sprintf("     Input %d:", i))
+		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.TxID))
+		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
+		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+	}
+
+	for i, output := range tx.Vout {
+		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 // trimmedCopy creates a copy of the transaction with the signatures and public keys cleared.
 func (tx *Tx) trimmedCopy() Tx {
 	var inputs []TxInput
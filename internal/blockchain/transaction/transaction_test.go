@@ -0,0 +1,282 @@
+package transaction_test
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxSerializeDeserialize(t *testing.T) {
+	tx := &transaction.Tx{
+		ID: transaction.TxID{'i', 'd'},
+		Vin: []transaction.TxInput{
+			{TxID: transaction.TxID{}, Vout: -1, PubKey: []byte("coinbase data")},
+			{TxID: transaction.TxID{'p', 'r', 'e', 'v'}, Vout: 3, Signature: []byte("sig"), PubKey: []byte("key")},
+		},
+		Vout: []transaction.TxOutput{
+			{Value: 10, PubKeyHash: []byte("pubkeyhash")},
+			{Value: 0},
+		},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		var deserialized transaction.Tx
+		err := deserialized.Deserialize(tx.Serialize())
+		require.NoError(t, err)
+
+		assert.Equal(t, tx, &deserialized)
+	})
+
+	t.Run("golden", func(t *testing.T) {
+		// Transaction IDs and Merkle roots are hashes of the layout, so it must not change
+		golden, err := hex.DecodeString(
+			"6964" + strings.Repeat("00", 30) + // ID
+				"00000002" + // Number of inputs
+				strings.Repeat("00", 32) + "ffffffffffffffff" + "00000000" + // Coinbase input
+				"0000000d" + hex.EncodeToString([]byte("coinbase data")) +
+				"70726576" + strings.Repeat("00", 28) + "0000000000000003" + // Spending input
+				"00000003" + hex.EncodeToString([]byte("sig")) +
+				"00000003" + hex.EncodeToString([]byte("key")) +
+				"00000002" + // Number of outputs
+				"0000000a" + "0000000a" + hex.EncodeToString([]byte("pubkeyhash")) +
+				"00000000" + "00000000",
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, golden, tx.Serialize())
+
+		var deserialized transaction.Tx
+		require.NoError(t, deserialized.Deserialize(golden))
+		assert.Equal(t, tx, &deserialized)
+
+		hash := tx.Hash()
+		assert.Equal(t, "253433f13dfb011bf47f9fa674724db053152e7f16e419ba74557380541b0985", hex.EncodeToString(hash[:]))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		empty := &transaction.Tx{}
+
+		var deserialized transaction.Tx
+		err := deserialized.Deserialize(empty.Serialize())
+		require.NoError(t, err)
+
+		assert.Equal(t, empty, &deserialized)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		serialized := tx.Serialize()
+
+		var deserialized transaction.Tx
+		err := deserialized.Deserialize(serialized[:len(serialized)-1])
+		assert.ErrorIs(t, err, transaction.ErrInvalidEncoding)
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		var deserialized transaction.Tx
+		err := deserialized.Deserialize(append(tx.Serialize(), 0))
+		assert.ErrorIs(t, err, transaction.ErrInvalidEncoding)
+	})
+}
+
+func TestTxVerify(t *testing.T) {
+	owner, err := wallet.New()
+	require.NoError(t, err)
+	other, err := wallet.New()
+	require.NoError(t, err)
+	ownerHash, err := wallet.HashPubKey(owner.PublicKey)
+	require.NoError(t, err)
+
+	prevTx := &transaction.Tx{
+		ID:   transaction.TxID{'p', 'r', 'e', 'v'},
+		Vout: []transaction.TxOutput{{Value: 10, PubKeyHash: ownerHash}},
+	}
+	prevTXs := map[transaction.TxID]*transaction.Tx{prevTx.ID: prevTx}
+
+	spend := func(w *wallet.Wallet) *transaction.Tx {
+		tx := &transaction.Tx{
+			Vin:  []transaction.TxInput{{TxID: prevTx.ID, Vout: 0, PubKey: w.PublicKey}},
+			Vout: []transaction.TxOutput{{Value: 10, PubKeyHash: []byte("recipient")}},
+		}
+		require.NoError(t, tx.Sign(w.PrivateKey, prevTXs))
+
+		return tx
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		// Half of the raw ECDSA signatures have a high s, Sign must replace each of them
+		for range 16 {
+			assert.True(t, spend(owner).Verify(prevTXs))
+		}
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		assert.False(t, spend(other).Verify(prevTXs), "signed with a key the output is not locked with")
+	})
+
+	t.Run("high s", func(t *testing.T) {
+		tx := spend(owner)
+		n := elliptic.P256().Params().N
+		half := len(tx.Vin[0].Signature) / 2
+		s := new(big.Int).SetBytes(tx.Vin[0].Signature[half:])
+		s.Sub(n, s).FillBytes(tx.Vin[0].Signature[half:])
+
+		assert.False(t, tx.Verify(prevTXs), "the same signature with n-s changes the ID")
+	})
+
+	t.Run("padded signature", func(t *testing.T) {
+		tx := spend(owner)
+		half := len(tx.Vin[0].Signature) / 2
+		r, s := tx.Vin[0].Signature[:half], tx.Vin[0].Signature[half:]
+		tx.Vin[0].Signature = slices.Concat([]byte{0}, r, []byte{0}, s)
+
+		assert.False(t, tx.Verify(prevTXs), "the same signature with longer halves changes the ID")
+	})
+}
+
+func TestTxFee(t *testing.T) {
+	prevTx := &transaction.Tx{
+		ID: transaction.TxID{'p', 'r', 'e', 'v'},
+		Vout: []transaction.TxOutput{
+			{Value: math.MaxInt32},
+			{Value: math.MaxInt32},
+		},
+	}
+	prevTXs := map[transaction.TxID]*transaction.Tx{prevTx.ID: prevTx}
+
+	spend := func(vouts []int, values ...int32) *transaction.Tx {
+		tx := &transaction.Tx{}
+		for _, vout := range vouts {
+			tx.Vin = append(tx.Vin, transaction.TxInput{TxID: prevTx.ID, Vout: vout})
+		}
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, transaction.TxOutput{Value: value})
+		}
+
+		return tx
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		fee, err := spend([]int{0}, 1, 2).Fee(prevTXs)
+		require.NoError(t, err)
+		assert.Equal(t, int32(math.MaxInt32-3), fee)
+	})
+
+	t.Run("outputs exceed inputs", func(t *testing.T) {
+		_, err := spend([]int{0}, math.MaxInt32, 1).Fee(prevTXs)
+		assert.Error(t, err)
+	})
+
+	t.Run("inputs exceed int32", func(t *testing.T) {
+		_, err := spend([]int{0, 1}, 1).Fee(prevTXs)
+		assert.Error(t, err)
+	})
+}
+
+func TestNewCoinbaseTXWithFees(t *testing.T) {
+	address := wallet.AddressFromPubKeyHash(make([]byte, 20))
+
+	tx, err := transaction.NewCoinbaseTXWithFees(address, "", math.MaxInt32-transaction.Subsidy)
+	require.NoError(t, err)
+	assert.Equal(t, int32(math.MaxInt32), tx.Vout[0].Value)
+
+	_, err = transaction.NewCoinbaseTXWithFees(address, "", math.MaxInt32-transaction.Subsidy+1)
+	assert.Error(t, err)
+}
+
+func TestUnsignedTx(t *testing.T) {
+	wlt, err := wallet.New()
+	require.NoError(t, err)
+	pubKeyHash, err := wallet.HashPubKey(wlt.PublicKey)
+	require.NoError(t, err)
+
+	prevTx := &transaction.Tx{
+		Vin: []transaction.TxInput{{TxID: transaction.TxID{'x'}, Vout: 0}},
+		Vout: []transaction.TxOutput{
+			{Value: 4, PubKeyHash: pubKeyHash},
+			{Value: 1, PubKeyHash: []byte("other")},
+			{Value: 7, PubKeyHash: pubKeyHash},
+		},
+	}
+	prevTx.ID = prevTx.Hash()
+
+	tx := &transaction.Tx{
+		Vin: []transaction.TxInput{{TxID: prevTx.ID, Vout: 2}, {TxID: prevTx.ID, Vout: 0}},
+		Vout: []transaction.TxOutput{
+			{Value: 9, PubKeyHash: []byte("recipient")},
+		},
+	}
+	tx.ID = tx.Hash()
+	unsigned := transaction.UnsignedTx{Tx: tx, PrevTXs: []*transaction.Tx{prevTx}}
+
+	var deserialized transaction.UnsignedTx
+	require.NoError(t, deserialized.Deserialize(unsigned.Serialize()))
+	assert.Equal(t, unsigned, deserialized)
+
+	fee, err := deserialized.Fee()
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), fee)
+
+	signerHash, err := deserialized.SignerPubKeyHash()
+	require.NoError(t, err)
+	assert.Equal(t, pubKeyHash, signerHash)
+
+	t.Run("sign", func(t *testing.T) {
+		signed, err := deserialized.Sign(wlt)
+		require.NoError(t, err)
+
+		assert.True(t, signed.Verify(map[transaction.TxID]*transaction.Tx{prevTx.ID: prevTx}))
+		assert.Equal(t, signed.Hash(), signed.ID)
+		assert.Nil(t, tx.Vin[0].Signature, "the unsigned transaction is left unchanged")
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		other, err := wallet.New()
+		require.NoError(t, err)
+
+		_, err = deserialized.Sign(other)
+		require.Error(t, err)
+	})
+
+	// Inflated input values would make the signer show a smaller fee than the transaction pays
+	t.Run("tampered values", func(t *testing.T) {
+		tampered := *prevTx
+		tampered.Vout = slices.Clone(prevTx.Vout)
+		tampered.Vout[2].Value = 70
+
+		// The ID the input refers to no longer matches
+		withID := transaction.UnsignedTx{Tx: tx, PrevTXs: []*transaction.Tx{&tampered}}
+		require.ErrorIs(t, deserialized.Deserialize(withID.Serialize()), transaction.ErrPrevTxMismatch)
+		_, err := withID.Sign(wlt)
+		require.ErrorIs(t, err, transaction.ErrPrevTxMismatch)
+		_, err = withID.Fee()
+		require.ErrorIs(t, err, transaction.ErrPrevTxMismatch)
+
+		// A recomputed ID is not the one the input refers to
+		tampered.ID = tampered.Hash()
+		rehashed := transaction.UnsignedTx{Tx: tx, PrevTXs: []*transaction.Tx{&tampered}}
+		require.ErrorIs(t, deserialized.Deserialize(rehashed.Serialize()), transaction.ErrPrevTxMismatch)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		missing := transaction.UnsignedTx{Tx: tx}
+		err := deserialized.Deserialize(missing.Serialize())
+		require.ErrorIs(t, err, transaction.ErrPrevTxMismatch)
+
+		outOfRange := *tx
+		outOfRange.Vin = []transaction.TxInput{{TxID: prevTx.ID, Vout: 3}}
+		err = deserialized.Deserialize(transaction.UnsignedTx{Tx: &outOfRange, PrevTXs: unsigned.PrevTXs}.Serialize())
+		require.Error(t, err)
+
+		err = deserialized.Deserialize(tx.Serialize())
+		require.ErrorIs(t, err, transaction.ErrInvalidEncoding)
+	})
+}
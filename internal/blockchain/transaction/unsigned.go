@@ -0,0 +1,177 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+)
+
+// ErrPrevTxMismatch is returned for an unsigned transaction whose previous transactions
+// do not match the IDs its inputs refer to.
+var ErrPrevTxMismatch = errors.New("previous transaction does not match its ID")
+
+// UnsignedTx is a transaction together with the transactions whose outputs it spends,
+// so that it can be signed on a machine that has the keys but not the blockchain.
+// The previous transactions are checked against the IDs the inputs refer to, which the signature commits to,
+// so the values of the spent outputs, and with them the fee, cannot be misstated to the signer.
+type UnsignedTx struct {
+	Tx *Tx
+	// PrevTXs are the transactions whose outputs are spent by the inputs, each of them once.
+	PrevTXs []*Tx
+}
+
+// Serialize encodes the transaction in its fixed layout, followed by the number of previous transactions
+// and the previous transactions in their fixed layout, each prefixed with its length.
+func (u UnsignedTx) Serialize() []byte {
+	data := u.Tx.Serialize()
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(u.PrevTXs))) //nolint:gosec // Lengths fit in 32 bits
+	for _, prevTx := range u.PrevTXs {
+		data = appendBytes(data, prevTx.Serialize())
+	}
+
+	return data
+}
+
+// Deserialize decodes an unsigned transaction from its fixed layout.
+// Every input must spend an existing output of one of the previous transactions,
+// whose contents must hash to the ID the input refers to.
+func (u *UnsignedTx) Deserialize(d []byte) error {
+	r := reader{data: d}
+
+	tx, err := readTx(&r)
+	if err != nil {
+		return err
+	}
+
+	var prevTXs []*Tx
+	for range r.uint32() {
+		data := r.bytes()
+		if r.err != nil {
+			return r.err
+		}
+
+		var prevTx Tx
+		if err := prevTx.Deserialize(data); err != nil {
+			return err
+		}
+		prevTXs = append(prevTXs, &prevTx)
+	}
+
+	if r.err == nil && len(r.data) != 0 {
+		r.err = ErrInvalidEncoding
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	decoded := UnsignedTx{Tx: tx, PrevTXs: prevTXs}
+	if _, err := decoded.prevTXs(); err != nil {
+		return err
+	}
+
+	*u = decoded
+
+	return nil
+}
+
+// Fee returns the difference between the values of the spent outputs and the transaction's outputs.
+func (u *UnsignedTx) Fee() (int32, error) {
+	prevTXs, err := u.prevTXs()
+	if err != nil {
+		return 0, err
+	}
+
+	return u.Tx.Fee(prevTXs)
+}
+
+// Sign signs all inputs with the key of a wallet and returns the signed transaction.
+// The spent outputs must all be locked with the wallet's key.
+func (u *UnsignedTx) Sign(w *wallet.Wallet) (*Tx, error) {
+	if u.Tx.IsCoinbase() {
+		return nil, errors.New("coinbase transactions are not signed")
+	}
+
+	prevTXs, err := u.prevTXs()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := wallet.HashPubKey(w.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash public key: %w", err)
+	}
+
+	signed := *u.Tx
+	signed.Vin = make([]TxInput, len(u.Tx.Vin))
+	for i, in := range u.Tx.Vin {
+		if !prevTXs[in.TxID].Vout[in.Vout].IsLockedWithKey(pubKeyHash) {
+			return nil, fmt.Errorf("input %d spends an output that is not locked with the key", i)
+		}
+
+		in.PubKey = w.PublicKey
+		signed.Vin[i] = in
+	}
+
+	if err := signed.Sign(w.PrivateKey, prevTXs); err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	if !signed.Verify(prevTXs) {
+		return nil, errors.New("signed transaction does not verify")
+	}
+
+	return &signed, nil
+}
+
+// SignerPubKeyHash returns the public key hash the spent outputs are locked with,
+// which is the key the transaction must be signed with.
+func (u *UnsignedTx) SignerPubKeyHash() ([]byte, error) {
+	prevTXs, err := u.prevTXs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.Tx.Vin) == 0 {
+		return nil, errors.New("transaction has no inputs")
+	}
+
+	var pubKeyHash []byte
+	for i, in := range u.Tx.Vin {
+		out := prevTXs[in.TxID].Vout[in.Vout]
+		if i > 0 && !bytes.Equal(out.PubKeyHash, pubKeyHash) {
+			return nil, errors.New("inputs spend outputs locked with different keys")
+		}
+		pubKeyHash = out.PubKeyHash
+	}
+
+	return pubKeyHash, nil
+}
+
+// prevTXs returns the previous transactions by their ID, as needed by Tx.Sign, Verify and Fee.
+// It checks that every previous transaction hashes to its ID and that every input spends one of their outputs.
+func (u *UnsignedTx) prevTXs() (map[TxID]*Tx, error) {
+	prevTXs := make(map[TxID]*Tx, len(u.PrevTXs))
+	for _, prevTx := range u.PrevTXs {
+		if prevTx.ID != prevTx.Hash() {
+			return nil, fmt.Errorf("%w: %x", ErrPrevTxMismatch, prevTx.ID)
+		}
+		prevTXs[prevTx.ID] = prevTx
+	}
+
+	for i, in := range u.Tx.Vin {
+		prevTx, ok := prevTXs[in.TxID]
+		if !ok {
+			return nil, fmt.Errorf("%w: input %d spends transaction %x, which is missing", ErrPrevTxMismatch, i, in.TxID)
+		}
+
+		if in.Vout < 0 || in.Vout >= len(prevTx.Vout) {
+			return nil, fmt.Errorf("input %d spends output %d of transaction %x, which does not exist", i, in.Vout, in.TxID)
+		}
+	}
+
+	return prevTXs, nil
+}
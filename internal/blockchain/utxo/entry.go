@@ -0,0 +1,41 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+// ErrNotFound is returned by Storage.GetUTXO when the outpoint is not in the UTXO set.
+var ErrNotFound = errors.New("unspent output not found")
+
+// Entry is an unspent output together with the block that created it.
+type Entry struct {
+	// Output is the unspent output.
+	Output transaction.TxOutput
+	// Height is the height of the block that created the output.
+	Height int
+	// Coinbase tells whether the output was created by a coinbase transaction.
+	Coinbase bool
+}
+
+// Serialize serializes the entry into a byte slice using gob encoding.
+func (e *Entry) Serialize() []byte {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(e)
+	if err != nil {
+		// Error will only occur if the input contains unsupported types.
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// Deserialize deserializes a byte slice into Entry using gob encoding.
+func (e *Entry) Deserialize(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	return decoder.Decode(e)
+}
@@ -0,0 +1,42 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+// SpentOutput is an output that a block removed from the UTXO set.
+type SpentOutput struct {
+	// Outpoint identifies the spent output.
+	Outpoint transaction.Outpoint
+	// Entry is the UTXO set entry of the output before it was spent.
+	Entry Entry
+}
+
+// Undo holds the changes a block made to the UTXO set that cannot be derived from the block itself.
+// It is written when the block is connected and read when the block is disconnected.
+type Undo struct {
+	// Spent holds the outputs spent by each transaction of the block, in the order they were spent.
+	Spent [][]SpentOutput
+}
+
+// Serialize serializes the undo record into a byte slice using gob encoding.
+func (u *Undo) Serialize() []byte {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(u)
+	if err != nil {
+		// Error will only occur if the input contains unsupported types.
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// Deserialize deserializes a byte slice into Undo using gob encoding.
+func (u *Undo) Deserialize(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	return decoder.Decode(u)
+}
@@ -1,15 +1,36 @@
 package utxo
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
 )
 
 type Storage interface {
-	GetUTXOs() (map[transaction.TxID][]transaction.TxOutput, error)
-	SetUTXOs(txID transaction.TxID, outputs []transaction.TxOutput) error
+	// GetUTXOs returns all unspent outputs by their outpoint.
+	GetUTXOs() (map[transaction.Outpoint]Entry, error)
+	// GetUTXOsByPubKeyHash returns the unspent outputs locked with the public key hash by their outpoint.
+	// Storages should index outputs by public key hash, so that it does not read the whole UTXO set.
+	GetUTXOsByPubKeyHash(pubKeyHash []byte) (map[transaction.Outpoint]Entry, error)
+	// GetUTXO returns the unspent output at the given outpoint, or ErrNotFound if it is not in the UTXO set.
+	GetUTXO(outpoint transaction.Outpoint) (*Entry, error)
+	// SetUTXO stores an unspent output.
+	SetUTXO(outpoint transaction.Outpoint, entry Entry) error
+	// DeleteUTXO removes an output from the UTXO set.
+	DeleteUTXO(outpoint transaction.Outpoint) error
+	// GetUTXOTip returns the hash of the block the UTXO set was last updated to,
+	// or an empty hash if it was never updated.
+	GetUTXOTip() (block.Hash, error)
+	// SetUTXOTip stores the hash of the block the UTXO set was last updated to.
+	SetUTXOTip(hash block.Hash) error
+	// GetUndo returns the undo record of a connected block.
+	GetUndo(hash block.Hash) (*Undo, error)
+	// SetUndo stores the undo record of a block.
+	SetUndo(hash block.Hash, undo Undo) error
 }
 
 type UTXOSet struct {
@@ -21,102 +42,192 @@ func NewUTXOSet(storage Storage) *UTXOSet {
 	return &UTXOSet{storage: storage}
 }
 
-func (u *UTXOSet) Set(utxos map[transaction.TxID][]transaction.TxOutput) error {
-	for txID, outputs := range utxos {
-		if err := u.storage.SetUTXOs(txID, outputs); err != nil {
-			return fmt.Errorf("failed to set UTXOs for transaction %s: %w", txID, err)
+// Set replaces the UTXO set with the given unspent outputs, which reflect the chain up to the given tip.
+func (u *UTXOSet) Set(utxos map[transaction.Outpoint]Entry, tip block.Hash) error {
+	existing, err := u.storage.GetUTXOs()
+	if err != nil {
+		return fmt.Errorf("failed to get UTXOs: %w", err)
+	}
+
+	for outpoint := range existing {
+		if _, ok := utxos[outpoint]; ok {
+			continue
+		}
+
+		if err := u.storage.DeleteUTXO(outpoint); err != nil {
+			return fmt.Errorf("failed to remove UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
+		}
+	}
+
+	for outpoint, entry := range utxos {
+		if err := u.storage.SetUTXO(outpoint, entry); err != nil {
+			return fmt.Errorf("failed to set UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
 		}
 	}
-	return nil
+
+	return u.storage.SetUTXOTip(tip)
+}
+
+// Tip returns the hash of the block the UTXO set reflects.
+func (u *UTXOSet) Tip() (block.Hash, error) {
+	return u.storage.GetUTXOTip()
 }
 
 // FindSpendableOutputIndexes finds and returns a map of trasnsaction IDs to their unspent output indexes
 // that can be used to spend the specified amount.
+// Outputs in exclude, e.g. the ones spent by pending transactions, are skipped,
+// as are outputs that would take the total beyond what an int32 can hold.
 func (u *UTXOSet) FindSpendableOutputIndexes(
 	pubKeyHash []byte,
 	amount int32,
+	exclude map[transaction.Outpoint]struct{},
 ) (int32, map[transaction.TxID][]int, error) {
-	utxos, err := u.storage.GetUTXOs()
+	utxos, err := u.storage.GetUTXOsByPubKeyHash(pubKeyHash)
 	if err != nil {
 		return 0, nil, fmt.Errorf("failed to get UTXOs: %w", err)
 	}
 
 	var accumulated int32
 	unspentOutputs := make(map[transaction.TxID][]int)
-	for txID, txos := range utxos {
-		for outIDx, out := range txos {
-			if accumulated >= amount {
-				return accumulated, unspentOutputs, nil
-			}
+	for outpoint, entry := range utxos {
+		if accumulated >= amount {
+			break
+		}
 
-			if out.IsLockedWithKey(pubKeyHash) {
-				accumulated += out.Value
-				unspentOutputs[txID] = append(unspentOutputs[txID], outIDx)
-			}
+		if _, ok := exclude[outpoint]; ok {
+			continue
+		}
+
+		if int64(accumulated)+int64(entry.Output.Value) > math.MaxInt32 {
+			continue // A transaction cannot spend more than an int32 holds
 		}
+
+		accumulated += entry.Output.Value
+		unspentOutputs[outpoint.TxID] = append(unspentOutputs[outpoint.TxID], outpoint.Vout)
 	}
 
 	return accumulated, unspentOutputs, nil
 }
 
-// FindUnspentTxOutputs finds and returns all unspent transaction outputs.
+// FindUnspentTxOutputs finds and returns all unspent transaction outputs locked with the public key hash.
 func (u *UTXOSet) FindUnspentTxOutputs(pubKeyHash []byte) ([]transaction.TxOutput, error) {
-	utxos, err := u.storage.GetUTXOs()
+	utxos, err := u.storage.GetUTXOsByPubKeyHash(pubKeyHash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get UTXOs: %w", err)
 	}
 
 	var unspentTxOs []transaction.TxOutput
-	for _, txos := range utxos {
-		for _, out := range txos {
-			if out.IsLockedWithKey(pubKeyHash) {
-				unspentTxOs = append(unspentTxOs, out)
-			}
-		}
+	for _, entry := range utxos {
+		unspentTxOs = append(unspentTxOs, entry.Output)
 	}
 
 	return unspentTxOs, nil
 }
 
-func (u *UTXOSet) Update(b block.Block) error {
-	utxos, err := u.storage.GetUTXOs()
+// FindUnspent returns the unspent outputs locked with the public key hash by their outpoint.
+func (u *UTXOSet) FindUnspent(pubKeyHash []byte) (map[transaction.Outpoint]Entry, error) {
+	utxos, err := u.storage.GetUTXOsByPubKeyHash(pubKeyHash)
 	if err != nil {
-		return fmt.Errorf("failed to get UTXOs: %w", err)
+		return nil, fmt.Errorf("failed to get UTXOs: %w", err)
 	}
 
-	for _, tx := range b.Transactions {
+	return utxos, nil
+}
+
+// Update applies a block at the given height to the UTXO set:
+// the outputs it spends are removed and the outputs it creates are added.
+// The removed outputs are stored as the block's undo record, so that Disconnect can restore them.
+func (u *UTXOSet) Update(b block.Block, height int) error {
+	undo := Undo{Spent: make([][]SpentOutput, len(b.Transactions))}
+
+	for txIdx, tx := range b.Transactions {
 		// Remove spent outputs
-		for _, in := range tx.Vin {
-			updatedOutputs := make([]transaction.TxOutput, 0)
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				outpoint := in.Outpoint()
 
-			outputs, ok := utxos[in.TxID]
-			if !ok {
-				continue // No outputs found for this transaction ID
-			}
+				entry, err := u.storage.GetUTXO(outpoint)
+				if errors.Is(err, ErrNotFound) {
+					continue // The output was already spent
+				}
+				if err != nil {
+					return fmt.Errorf("failed to get UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
+				}
 
-			for outIDx, out := range outputs {
-				if outIDx != in.Vout {
-					updatedOutputs = append(updatedOutputs, out) // Keep unspent outputs
+				undo.Spent[txIdx] = append(undo.Spent[txIdx], SpentOutput{Outpoint: outpoint, Entry: *entry})
+
+				err = u.storage.DeleteUTXO(outpoint)
+				if err != nil {
+					return fmt.Errorf("failed to remove UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
 				}
 			}
+		}
+
+		// Add new outputs
+		for outIdx, out := range tx.Vout {
+			outpoint := transaction.Outpoint{TxID: tx.ID, Vout: outIdx}
+			entry := Entry{Output: out, Height: height, Coinbase: tx.IsCoinbase()}
 
-			err := u.storage.SetUTXOs(in.TxID, updatedOutputs)
+			err := u.storage.SetUTXO(outpoint, entry)
 			if err != nil {
-				return fmt.Errorf("failed to update UTXOs for transaction %s: %w", in.TxID, err)
+				return fmt.Errorf("failed to set UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
 			}
 		}
+	}
 
-		// Add new outputs
-		newOutputs := make([]transaction.TxOutput, 0)
-		for _, out := range tx.Vout {
-			newOutputs = append(newOutputs, out)
+	err := u.storage.SetUndo(b.Hash, undo)
+	if err != nil {
+		return fmt.Errorf("failed to store undo record for block %x: %w", b.Hash, err)
+	}
+
+	return u.storage.SetUTXOTip(b.Hash)
+}
+
+// IsUnspent checks whether the output at the given outpoint is in the UTXO set.
+func (u *UTXOSet) IsUnspent(outpoint transaction.Outpoint) (bool, error) {
+	_, err := u.storage.GetUTXO(outpoint)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
+	}
+
+	return true, nil
+}
+
+// Disconnect undoes the changes Update made for the given block, using the block's undo record.
+// The outputs created by the block are removed and the outputs it spent are restored.
+func (u *UTXOSet) Disconnect(b block.Block) error {
+	undo, err := u.storage.GetUndo(b.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to get undo record for block %x: %w", b.Hash, err)
+	}
+
+	if len(undo.Spent) != len(b.Transactions) {
+		return fmt.Errorf("undo record for block %x does not match its transactions", b.Hash)
+	}
+
+	// Transactions are undone in reverse, so that outputs created and spent within the block end up removed
+	for txIdx, tx := range slices.Backward(b.Transactions) {
+		// Remove created outputs
+		for outIdx := range tx.Vout {
+			outpoint := transaction.Outpoint{TxID: tx.ID, Vout: outIdx}
+
+			err := u.storage.DeleteUTXO(outpoint)
+			if err != nil {
+				return fmt.Errorf("failed to remove UTXO %x:%d: %w", outpoint.TxID, outpoint.Vout, err)
+			}
 		}
 
-		err := u.storage.SetUTXOs(tx.ID, newOutputs)
-		if err != nil {
-			return fmt.Errorf("failed to set UTXOs for transaction %s: %w", tx.ID, err)
+		// Restore spent outputs
+		for _, spent := range undo.Spent[txIdx] {
+			err := u.storage.SetUTXO(spent.Outpoint, spent.Entry)
+			if err != nil {
+				return fmt.Errorf("failed to restore UTXO %x:%d: %w", spent.Outpoint.TxID, spent.Outpoint.Vout, err)
+			}
 		}
 	}
 
-	return nil
+	return u.storage.SetUTXOTip(b.PrevBlockHash)
 }
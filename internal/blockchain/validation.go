@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+const (
+	maxFutureBlockTime = 2 * time.Hour // How far ahead of the local clock a block timestamp may be
+	medianTimeSpan     = 11            // Number of previous blocks used to compute the median time past
+)
+
+// ErrInvalidBlock is returned when a block does not pass validation.
+var ErrInvalidBlock = errors.New("invalid block")
+
+// ValidateBlock checks that a block is valid on top of its parent.
+// The transaction inputs are checked against the UTXO set only if the block extends the current tip,
+// blocks of side chains have their inputs checked when the blockchain is reorganized onto them.
+func (bc *Blockchain) ValidateBlock(b *block.Block) error {
+	err := bc.checkBlock(b)
+	if err != nil {
+		return err
+	}
+
+	tip, err := bc.storage.GetTip()
+	if err != nil {
+		return fmt.Errorf("failed to get tip of blockchain: %w", err)
+	}
+
+	if b.PrevBlockHash != tip {
+		return nil
+	}
+
+	return bc.checkInputs(b)
+}
+
+// checkBlock performs all checks that do not depend on the UTXO set.
+// The parent must be stored, as the proof of work may depend on the block's ancestors.
+func (bc *Blockchain) checkBlock(b *block.Block) error {
+	if b.PrevBlockHash != *new(block.Hash) && !bc.HasBlock(b.PrevBlockHash) {
+		return fmt.Errorf("%w: %x", ErrUnknownParent, b.PrevBlockHash)
+	}
+
+	if !bc.powFactory.Validate(b) {
+		return fmt.Errorf("%w: proof of work is not valid", ErrInvalidBlock)
+	}
+
+	if b.MerkleRoot != b.ComputeMerkleRoot() {
+		return fmt.Errorf("%w: merkle root does not match transactions", ErrInvalidBlock)
+	}
+
+	err := bc.checkTimestamp(b)
+	if err != nil {
+		return err
+	}
+
+	err = checkCoinbase(b)
+	if err != nil {
+		return err
+	}
+
+	if b.PrevBlockHash == *new(block.Hash) && string(b.Transactions[0].Vin[0].PubKey) != bc.genesisData {
+		return fmt.Errorf("%w: genesis block belongs to another network", ErrInvalidBlock)
+	}
+
+	for _, tx := range b.Transactions {
+		if tx.ID != tx.Hash() {
+			return fmt.Errorf("%w: transaction ID %x does not match its contents", ErrInvalidBlock, tx.ID)
+		}
+	}
+
+	return checkDoubleSpends(b)
+}
+
+// checkTimestamp checks that the block is not older than the median time of its ancestors
+// and not too far in the future.
+func (bc *Blockchain) checkTimestamp(b *block.Block) error {
+	if time.Unix(b.Timestamp, 0).After(time.Now().Add(maxFutureBlockTime)) {
+		return fmt.Errorf("%w: timestamp is too far in the future", ErrInvalidBlock)
+	}
+
+	if b.PrevBlockHash == *new(block.Hash) {
+		return nil // Genesis block has no ancestors
+	}
+
+	medianTime, err := bc.medianTimePast(b.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	if b.Timestamp < medianTime {
+		return fmt.Errorf("%w: timestamp is before the median time of previous blocks", ErrInvalidBlock)
+	}
+
+	return nil
+}
+
+// medianTimePast returns the median timestamp of the given block and its ancestors.
+func (bc *Blockchain) medianTimePast(hash block.Hash) (int64, error) {
+	timestamps := make([]int64, 0, medianTimeSpan)
+
+	for len(timestamps) < medianTimeSpan && hash != *new(block.Hash) {
+		header, err := bc.storage.GetHeader(hash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get header %x: %w", hash, err)
+		}
+
+		timestamps = append(timestamps, header.Timestamp)
+		hash = header.PrevBlockHash
+	}
+
+	slices.Sort(timestamps)
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// checkCoinbase checks that the block starts with a coinbase transaction and contains no other.
+func checkCoinbase(b *block.Block) error {
+	if len(b.Transactions) == 0 {
+		return fmt.Errorf("%w: block has no transactions", ErrInvalidBlock)
+	}
+
+	if !b.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("%w: first transaction is not a coinbase", ErrInvalidBlock)
+	}
+
+	for _, tx := range b.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return fmt.Errorf("%w: more than one coinbase transaction", ErrInvalidBlock)
+		}
+	}
+
+	return nil
+}
+
+// checkDoubleSpends checks that no output is spent twice within the block.
+func checkDoubleSpends(b *block.Block) error {
+	spent := make(map[transaction.Outpoint]struct{})
+
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			op := vin.Outpoint()
+			if _, ok := spent[op]; ok {
+				return fmt.Errorf("%w: output %x:%d is spent twice", ErrInvalidBlock, vin.TxID, vin.Vout)
+			}
+			spent[op] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// checkInputs checks that every input spends an unspent output and is signed by its owner,
+// that no transaction creates more than it spends and that the coinbase claims at most
+// the subsidy plus the fees of the block.
+// Outputs may be spent from the UTXO set or from a transaction earlier in the same block.
+// The block must extend the current tip.
+func (bc *Blockchain) checkInputs(b *block.Block) error {
+	blockTXs := make(map[transaction.TxID]*transaction.Tx)
+	var fees int64
+
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			blockTXs[tx.ID] = tx
+			continue
+		}
+
+		prevTXs := make(map[transaction.TxID]*transaction.Tx)
+		for _, vin := range tx.Vin {
+			prevTX, err := bc.findSpentTransaction(vin, blockTXs)
+			if err != nil {
+				return fmt.Errorf("%w: transaction %x: %w", ErrInvalidBlock, tx.ID, err)
+			}
+			prevTXs[prevTX.ID] = prevTX
+		}
+
+		fee, err := tx.Fee(prevTXs)
+		if err != nil {
+			return fmt.Errorf("%w: transaction %x: %w", ErrInvalidBlock, tx.ID, err)
+		}
+		fees += int64(fee)
+
+		if !tx.Verify(prevTXs) {
+			return fmt.Errorf("%w: transaction %x has an invalid signature", ErrInvalidBlock, tx.ID)
+		}
+
+		blockTXs[tx.ID] = tx
+	}
+
+	var reward int64
+	for _, out := range b.Transactions[0].Vout {
+		if out.Value < 0 {
+			return fmt.Errorf("%w: coinbase output has a negative value", ErrInvalidBlock)
+		}
+		reward += int64(out.Value)
+	}
+
+	if reward > transaction.Subsidy+fees {
+		return fmt.Errorf("%w: coinbase claims %d, more than subsidy plus fees %d",
+			ErrInvalidBlock, reward, transaction.Subsidy+fees)
+	}
+
+	return nil
+}
+
+// findSpentTransaction returns the transaction whose output the input spends,
+// after checking that the output exists and is unspent.
+func (bc *Blockchain) findSpentTransaction(
+	vin transaction.TxInput,
+	blockTXs map[transaction.TxID]*transaction.Tx,
+) (*transaction.Tx, error) {
+	if prevTX, ok := blockTXs[vin.TxID]; ok {
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return nil, fmt.Errorf("output %x:%d does not exist", vin.TxID, vin.Vout)
+		}
+		return prevTX, nil
+	}
+
+	prevTX, err := bc.findTransaction(vin.TxID)
+	if err != nil {
+		return nil, fmt.Errorf("previous transaction %x: %w", vin.TxID, err)
+	}
+
+	if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+		return nil, fmt.Errorf("output %x:%d does not exist", vin.TxID, vin.Vout)
+	}
+
+	unspent, err := bc.utxoSet.IsUnspent(vin.Outpoint())
+	if err != nil {
+		return nil, err
+	}
+	if !unspent {
+		return nil, fmt.Errorf("output %x:%d is already spent", vin.TxID, vin.Vout)
+	}
+
+	return prevTX, nil
+}
@@ -0,0 +1,185 @@
+package blockchain_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rejectingPoWFactory struct{}
+
+func (rejectingPoWFactory) Produce(_ context.Context, _ *block.Block) (block.Hash, error) {
+	return block.Hash{'r'}, nil
+}
+func (rejectingPoWFactory) Validate(_ *block.Block) bool { return false }
+func (rejectingPoWFactory) Work(_ *block.Block) *big.Int { return big.NewInt(1) }
+
+func TestValidateBlock(t *testing.T) {
+	storage := mock.NewStorage()
+	powFactory := mock.NewPoWFactory()
+	wallets := wallet.NewCollection(storage)
+
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+	require.NoError(t, bc.ReindexUTXOSet())
+
+	tip := bc.GetBlockHashes()[0]
+
+	// Both transactions spend the genesis coinbase output
+	tx1, err := bc.NewUTXOTransaction(address1, address2, 3, 0)
+	require.NoError(t, err)
+	tx2, err := bc.NewUTXOTransaction(address1, address2, 4, 0)
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), tx1)
+		assert.NoError(t, bc.ValidateBlock(b))
+	})
+
+	t.Run("invalid proof of work", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), tx1)
+		rejecting := blockchain.NewBlockchain(storage, rejectingPoWFactory{}, wallets)
+		assert.ErrorIs(t, rejecting.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("unknown parent", func(t *testing.T) {
+		b := newTestBlock(block.Hash{'u'}, block.Hash{'v'}, coinbase(t, address1, "valid"))
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrUnknownParent)
+	})
+
+	t.Run("merkle root mismatch", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), tx1)
+		b.Transactions = b.Transactions[:1]
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("timestamp in the future", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"))
+		b.Timestamp = time.Now().Add(3 * time.Hour).Unix()
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("timestamp before median time past", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"))
+		b.Timestamp = time.Now().Add(-time.Hour).Unix()
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("no coinbase", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, tx1)
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("coinbase not first", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, tx1, coinbase(t, address1, "valid"))
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("two coinbases", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "first"), coinbase(t, address1, "second"))
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("double spend inside block", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), tx1, tx2)
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("coinbase claims fees", func(t *testing.T) {
+		genesis, err := bc.GetBlock(tip)
+		require.NoError(t, err)
+		tx := spendWithFee(t, wallets, genesis.Transactions[0], address1, address2, 2)
+
+		claimed, err := transaction.NewCoinbaseTXWithFees(address1, "fees", 2)
+		require.NoError(t, err)
+		b := newTestBlock(tip, block.Hash{'v'}, claimed, tx)
+		assert.NoError(t, bc.ValidateBlock(b))
+
+		overclaimed, err := transaction.NewCoinbaseTXWithFees(address1, "fees", 3)
+		require.NoError(t, err)
+		b = newTestBlock(tip, block.Hash{'v'}, overclaimed, tx)
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("outputs exceed inputs", func(t *testing.T) {
+		genesis, err := bc.GetBlock(tip)
+		require.NoError(t, err)
+		tx := spendWithFee(t, wallets, genesis.Transactions[0], address1, address2, -1)
+
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), tx)
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+		assert.ErrorIs(t, bc.SubmitTransaction(tx), blockchain.ErrInvalidTransaction)
+	})
+
+	t.Run("input spent twice", func(t *testing.T) {
+		genesis, err := bc.GetBlock(tip)
+		require.NoError(t, err)
+		prevTx := genesis.Transactions[0]
+
+		wlt, err := wallets.GetWallet(address1)
+		require.NoError(t, err)
+
+		// Counting the same input twice would make the transaction appear to pay out twice the value
+		in := transaction.TxInput{TxID: prevTx.ID, Vout: 0, PubKey: wlt.PublicKey}
+		tx := &transaction.Tx{
+			Vin:  []transaction.TxInput{in, in},
+			Vout: []transaction.TxOutput{transaction.NewTxOutput(2*prevTx.Vout[0].Value, address2)},
+		}
+		tx.ID = tx.Hash()
+		require.NoError(t, tx.Sign(wlt.PrivateKey, map[transaction.TxID]*transaction.Tx{prevTx.ID: prevTx}))
+
+		assert.ErrorIs(t, bc.SubmitTransaction(tx), blockchain.ErrInvalidTransaction)
+
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), tx)
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	forged := *tx1
+	forged.Vout = []transaction.TxOutput{transaction.NewTxOutput(10, address2)}
+	forged.ID = forged.Hash()
+
+	t.Run("invalid signature", func(t *testing.T) {
+		b := newTestBlock(tip, block.Hash{'v'}, coinbase(t, address1, "valid"), &forged)
+		assert.ErrorIs(t, bc.ValidateBlock(b), blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("spent output", func(t *testing.T) {
+		b, err := bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "mined"), tx1})
+		require.NoError(t, err)
+
+		invalid := newTestBlock(b.Hash, block.Hash{'v'}, coinbase(t, address1, "valid"), tx2)
+		assert.ErrorIs(t, bc.ValidateBlock(invalid), blockchain.ErrInvalidBlock)
+
+		_, err = bc.MineBlock(t.Context(), []*transaction.Tx{coinbase(t, address1, "invalid"), tx2})
+		assert.ErrorIs(t, err, blockchain.ErrInvalidBlock)
+	})
+
+	t.Run("invalid side chain is rolled back", func(t *testing.T) {
+		s1 := newTestBlock(tip, block.Hash{'s', '1'}, coinbase(t, address2, "s1"))
+		s2 := newTestBlock(s1.Hash, block.Hash{'s', '2'}, coinbase(t, address2, "s2"), &forged)
+
+		require.NoError(t, bc.AddBlock(s1))
+		require.ErrorIs(t, bc.AddBlock(s2), blockchain.ErrInvalidBlock)
+
+		assert.Equal(t, 1, bc.GetBestHeight())
+		assert.NotEqual(t, s2.Hash, bc.GetBlockHashes()[0])
+		assert.False(t, bc.HasBlock(s2.Hash))
+		assert.Equal(t, 17, balance(t, bc, address1))
+		assert.Equal(t, 3, balance(t, bc, address2))
+	})
+}
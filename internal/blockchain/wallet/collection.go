@@ -1,22 +1,63 @@
 package wallet
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 )
 
+var (
+	// ErrWalletNotFound is returned for an address without a wallet in the collection.
+	ErrWalletNotFound = errors.New("wallet not found")
+	// ErrWalletExists is returned when importing the key of a wallet that is already in the collection.
+	ErrWalletExists = errors.New("wallet already exists")
+	// ErrWatchOnly is returned when the key of an address that is only watched is needed.
+	ErrWatchOnly = errors.New("address is watch-only")
+	// ErrHDChainExists is returned when setting the seed of a collection that already has one.
+	ErrHDChainExists = errors.New("wallets already have a mnemonic")
+)
+
+// seedAddress is the associated data of the encrypted seed. It is not a valid address.
+const seedAddress = "hd seed"
+
 // Storage is an interface for a storage system that can store and retrieve wallets.
+// Wallets are stored as encoded by the Collection, encrypted if the collection is.
 type Storage interface {
-	// AddWallet adds a new wallet to the storage and returns its address.
-	AddWallet(address string, wallet Wallet) error
+	// AddWallet stores the encoded wallet of an address.
+	AddWallet(address string, data []byte) error
 	// GetAddresses returns a slice of all wallet addresses in the storage.
 	GetAddresses() ([]string, error)
-	// GetWallet retrieves a wallet by its address.
-	GetWallet(address string) (*Wallet, error)
+	// GetWallet retrieves the encoded wallet of an address, ErrWalletNotFound if there is none.
+	GetWallet(address string) ([]byte, error)
+	// AddWatchAddress stores an address that is watched without its key.
+	AddWatchAddress(address string) error
+	// GetWatchAddresses returns the watched addresses.
+	GetWatchAddresses() ([]string, error)
+	// GetWalletEncryption returns the encoded encryption parameters of the wallets, nil if they are not encrypted.
+	GetWalletEncryption() ([]byte, error)
+	// GetHDChain returns the encoded HD chain the wallets are derived from, nil if there is none.
+	GetHDChain() ([]byte, error)
+	// SetHDChain stores the encoded HD chain.
+	SetHDChain(data []byte) error
+	// ReplaceWallets stores new encryption parameters and all wallets and the HD chain encoded with them at once.
+	// The HD chain is left unchanged if it is nil.
+	ReplaceWallets(encryption, hdChain []byte, wallets map[string][]byte) error
 }
 
 // Collection stores a collection of wallets.
+// Besides wallets, it can watch addresses whose keys are kept elsewhere, which can receive but not sign.
+// Once the collection has the seed of an HD wallet, new keys are derived from it instead of being random,
+// so that the mnemonic of the seed is a backup of all of them.
+// The wallets can be encrypted with a passphrase, after which their keys are only available while it is unlocked.
+// It is safe for concurrent use, so that it can be locked again by a timer.
 type Collection struct {
 	storage Storage
+
+	mu        sync.Mutex
+	key       []byte      // Key the wallets are encrypted with, nil while locked
+	lockTimer *time.Timer // Locks the collection when the unlock timeout passes
 }
 
 // NewCollection creates new Collection.
@@ -27,19 +68,257 @@ func NewCollection(storage Storage) *Collection {
 }
 
 // AddWallet adds a Wallet to Collection and returns its address.
+// The key is the next one of the receive chain if the collection has an HD seed, random otherwise.
+// An encrypted collection must be unlocked.
 func (c *Collection) AddWallet() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkUnlocked(); err != nil {
+		return "", err
+	}
+
+	chain, err := c.hdChain()
+	if err != nil {
+		return "", err
+	}
+
+	if chain != nil {
+		return c.addDerivedWallet(chain, ReceiveChain)
+	}
+
 	wallet, err := New()
 	if err != nil {
 		return "", err
 	}
 
+	return c.add(wallet)
+}
+
+// ImportWallet adds a Wallet with an existing key and returns its address.
+// The key is kept as it is, so it is not covered by the mnemonic of an HD seed.
+// An encrypted collection must be unlocked.
+func (c *Collection) ImportWallet(wallet *Wallet) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkUnlocked(); err != nil {
+		return "", err
+	}
+
+	address, err := wallet.getAddress()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = c.storage.GetWallet(string(address))
+	if err == nil {
+		return "", fmt.Errorf("%w: %s", ErrWalletExists, address)
+	}
+	if !errors.Is(err, ErrWalletNotFound) {
+		return "", err
+	}
+
+	return c.add(wallet)
+}
+
+// AddWatchAddress watches an address without its key, so that it is listed with the wallets but cannot sign.
+// Importing its key later turns it into a full wallet.
+func (c *Collection) AddWatchAddress(address string) error {
+	if err := ValidateAddress(address); err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Address == address {
+			return fmt.Errorf("%w: %s", ErrWalletExists, address)
+		}
+	}
+
+	if err := c.storage.AddWatchAddress(address); err != nil {
+		return fmt.Errorf("failed to store watch address: %w", err)
+	}
+
+	return nil
+}
+
+// ChangeAddress returns the address change of a transaction from the given address is sent to.
+// It is a new address of the change chain if the collection has an HD seed, the sender's address otherwise.
+func (c *Collection) ChangeAddress(from string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chain, err := c.hdChain()
+	if err != nil || chain == nil {
+		return from, err
+	}
+
+	if err := c.checkUnlocked(); err != nil {
+		return "", err
+	}
+
+	return c.addDerivedWallet(chain, ChangeChain)
+}
+
+// HasSeed reports whether the collection has an HD seed.
+func (c *Collection) HasSeed() (bool, error) {
+	chain, err := c.hdChain()
+	return chain != nil, err
+}
+
+// SetSeed sets the HD seed the keys of new wallets are derived from to the seed of a mnemonic.
+// Existing wallets are kept. An encrypted collection must be unlocked.
+func (c *Collection) SetSeed(mnemonic string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seed, err := c.checkNewSeed(mnemonic)
+	if err != nil {
+		return err
+	}
+
+	chain, err := c.newHDChain(seed)
+	if err != nil {
+		return err
+	}
+
+	return c.storeHDChain(chain)
+}
+
+// Restore sets the HD seed to the seed of a mnemonic and adds the wallets of its used keys.
+// Keys of each chain are derived until gapLimit consecutive ones are unused, as reported by isUsed
+// for the hash of their public key. It returns the addresses of the added wallets.
+func (c *Collection) Restore(mnemonic string, gapLimit int, isUsed func(pubKeyHash []byte) bool) ([]string, error) {
+	if gapLimit <= 0 {
+		return nil, fmt.Errorf("gap limit must be positive: %d", gapLimit)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seed, err := c.checkNewSeed(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := c.newHDChain(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, chainIndex := range []int{ReceiveChain, ChangeChain} {
+		var derived []*Wallet
+		restored := 0
+		for gap := 0; gap < gapLimit; {
+			wallet := deriveWallet(seed, chainIndex, uint32(len(derived))) //nolint:gosec // Far fewer keys than 2^32
+			derived = append(derived, wallet)
+
+			pubKeyHash, err := HashPubKey(wallet.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+
+			if isUsed(pubKeyHash) {
+				restored = len(derived)
+				gap = 0
+			} else {
+				gap++
+			}
+		}
+
+		// Unused keys before a used one are restored as well, they may still be paid
+		for _, wallet := range derived[:restored] {
+			address, err := c.add(wallet)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, address)
+		}
+		chain.Next[chainIndex] = uint32(restored) //nolint:gosec // Far fewer keys than 2^32
+	}
+
+	if err := c.storeHDChain(chain); err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+// checkNewSeed checks that the collection can get a new HD seed and returns the seed of a mnemonic.
+func (c *Collection) checkNewSeed(mnemonic string) ([]byte, error) {
+	if err := c.checkUnlocked(); err != nil {
+		return nil, err
+	}
+
+	chain, err := c.hdChain()
+	if err != nil {
+		return nil, err
+	}
+	if chain != nil {
+		return nil, ErrHDChainExists
+	}
+
+	return MnemonicToSeed(mnemonic)
+}
+
+// newHDChain returns an HD chain with the seed, encrypted if the collection is.
+func (c *Collection) newHDChain(seed []byte) (*hdChain, error) {
+	if c.key == nil {
+		return &hdChain{Seed: seed}, nil
+	}
+
+	encrypted, err := encrypt(c.key, seedAddress, seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt seed: %w", err)
+	}
+
+	return &hdChain{Seed: encrypted}, nil
+}
+
+// addDerivedWallet adds the wallet of the next key of a chain.
+func (c *Collection) addDerivedWallet(chain *hdChain, chainIndex int) (string, error) {
+	seed, err := c.seed(chain)
+	if err != nil {
+		return "", err
+	}
+
+	address, err := c.add(deriveWallet(seed, chainIndex, chain.Next[chainIndex]))
+	if err != nil {
+		return "", err
+	}
+
+	chain.Next[chainIndex]++
+	if err := c.storeHDChain(chain); err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
+
+// add stores a wallet and returns its address.
+func (c *Collection) add(wallet *Wallet) (string, error) {
 	address, err := wallet.getAddress()
 	if err != nil {
 		return "", err
 	}
 
 	addressStr := fmt.Sprintf("%s", address)
-	err = c.storage.AddWallet(addressStr, *wallet)
+
+	data, err := c.encode(addressStr, wallet)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.storage.AddWallet(addressStr, data)
 	if err != nil {
 		return "", err
 	}
@@ -48,11 +327,373 @@ func (c *Collection) AddWallet() (string, error) {
 }
 
 // GetAddresses returns an array of addresses stored in the Collection.
+// Watch-only addresses are not included, see Entries.
 func (c *Collection) GetAddresses() ([]string, error) {
 	return c.storage.GetAddresses()
 }
 
+// Entry is an address of the collection.
+type Entry struct {
+	Address string
+	// WatchOnly is set for addresses without a key, which cannot sign.
+	WatchOnly bool
+}
+
+// Entries returns the addresses of all wallets followed by the watch-only addresses.
+func (c *Collection) Entries() ([]Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries()
+}
+
+func (c *Collection) entries() ([]Entry, error) {
+	addresses, err := c.storage.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	watched, err := c.storage.GetWatchAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get watch addresses: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(addresses)+len(watched))
+	for _, address := range addresses {
+		entries = append(entries, Entry{Address: address})
+	}
+
+	// A watched address stays stored when its key is imported, it is listed as a wallet then
+	for _, address := range watched {
+		if !slices.Contains(addresses, address) {
+			entries = append(entries, Entry{Address: address, WatchOnly: true})
+		}
+	}
+
+	return entries, nil
+}
+
 // GetWallet returns a Wallet by its address.
-func (c Collection) GetWallet(address string) (*Wallet, error) {
-	return c.storage.GetWallet(address)
+// It returns ErrLocked if the collection is encrypted and locked, ErrWatchOnly if the address is only watched.
+func (c *Collection) GetWallet(address string) (*Wallet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := c.storage.GetWallet(address)
+	if errors.Is(err, ErrWalletNotFound) {
+		watched, watchErr := c.storage.GetWatchAddresses()
+		if watchErr != nil {
+			return nil, fmt.Errorf("failed to get watch addresses: %w", watchErr)
+		}
+		if slices.Contains(watched, address) {
+			return nil, ErrWatchOnly
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := c.encryption()
+	if err != nil {
+		return nil, err
+	}
+
+	if params != nil {
+		if c.key == nil {
+			return nil, ErrLocked
+		}
+
+		data, err = decrypt(c.key, address, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt wallet: %w", err)
+		}
+	}
+
+	wallet := &Wallet{}
+	if err := wallet.Deserialize(data); err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+// IsEncrypted reports whether the wallets are encrypted.
+func (c *Collection) IsEncrypted() (bool, error) {
+	params, err := c.encryption()
+	return params != nil, err
+}
+
+// IsLocked reports whether the wallets are encrypted and locked.
+func (c *Collection) IsLocked() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	params, err := c.encryption()
+	return params != nil && c.key == nil, err
+}
+
+// Encrypt encrypts all wallets with a passphrase. The collection is locked afterwards.
+// The unencrypted values are overwritten, but storages that keep old versions of values,
+// like badger until it compacts its files, may still hold them on disk.
+func (c *Collection) Encrypt(passphrase string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	params, err := c.encryption()
+	if err != nil {
+		return err
+	}
+	if params != nil {
+		return ErrEncrypted
+	}
+
+	return c.reencrypt(nil, passphrase)
+}
+
+// ChangePassphrase encrypts all wallets with a new passphrase. The collection is locked afterwards.
+// Like with Encrypt, the values encrypted with the old passphrase may remain on disk for a while.
+func (c *Collection) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	params, err := c.encryption()
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		return ErrNotEncrypted
+	}
+
+	key, err := params.deriveKey(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	return c.reencrypt(key, newPassphrase)
+}
+
+// Unlock makes the keys of an encrypted collection available until the timeout passes,
+// or until Lock is called if the timeout is zero.
+func (c *Collection) Unlock(passphrase string, timeout time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	params, err := c.encryption()
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		return ErrNotEncrypted
+	}
+
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	c.lock()
+	c.key = key
+	if timeout > 0 {
+		c.lockTimer = time.AfterFunc(timeout, c.Lock)
+	}
+
+	return nil
+}
+
+// Lock forgets the key of an encrypted collection.
+func (c *Collection) Lock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lock()
+}
+
+func (c *Collection) lock() {
+	if c.lockTimer != nil {
+		c.lockTimer.Stop()
+		c.lockTimer = nil
+	}
+
+	clear(c.key)
+	c.key = nil
+}
+
+// checkUnlocked returns ErrLocked if the collection is encrypted and locked.
+func (c *Collection) checkUnlocked() error {
+	params, err := c.encryption()
+	if err != nil {
+		return err
+	}
+
+	if params != nil && c.key == nil {
+		return ErrLocked
+	}
+
+	return nil
+}
+
+// hdChain returns the HD chain of the collection, nil if it has none.
+func (c *Collection) hdChain() (*hdChain, error) {
+	data, err := c.storage.GetHDChain()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HD chain: %w", err)
+	}
+
+	if data == nil {
+		return nil, nil //nolint:nilnil // No HD chain
+	}
+
+	chain := &hdChain{}
+	if err := chain.Deserialize(data); err != nil {
+		return nil, fmt.Errorf("failed to decode HD chain: %w", err)
+	}
+
+	return chain, nil
+}
+
+func (c *Collection) storeHDChain(chain *hdChain) error {
+	data, err := chain.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if err := c.storage.SetHDChain(data); err != nil {
+		return fmt.Errorf("failed to store HD chain: %w", err)
+	}
+
+	return nil
+}
+
+// seed returns the seed of an HD chain, decrypted if the collection is encrypted.
+func (c *Collection) seed(chain *hdChain) ([]byte, error) {
+	if c.key == nil {
+		return chain.Seed, nil
+	}
+
+	seed, err := decrypt(c.key, seedAddress, chain.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt seed: %w", err)
+	}
+
+	return seed, nil
+}
+
+// encryption returns the encryption parameters of the wallets, nil if they are not encrypted.
+func (c *Collection) encryption() (*encryptionParams, error) {
+	data, err := c.storage.GetWalletEncryption()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet encryption: %w", err)
+	}
+
+	if data == nil {
+		return nil, nil //nolint:nilnil // Wallets are not encrypted
+	}
+
+	params := &encryptionParams{}
+	if err := params.Deserialize(data); err != nil {
+		return nil, fmt.Errorf("failed to decode wallet encryption: %w", err)
+	}
+
+	return params, nil
+}
+
+// encode serializes a wallet and encrypts it if the collection is encrypted.
+func (c *Collection) encode(address string, wallet *Wallet) ([]byte, error) {
+	data, err := wallet.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.key == nil {
+		return data, nil
+	}
+
+	return encrypt(c.key, address, data)
+}
+
+// reencrypt decrypts all wallets and the HD seed with the old key, nil if they are not encrypted,
+// and stores them encrypted with a key derived from the new passphrase. The collection is locked afterwards.
+func (c *Collection) reencrypt(oldKey []byte, newPassphrase string) error {
+	if newPassphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+
+	params, err := newEncryptionParams()
+	if err != nil {
+		return err
+	}
+
+	newKey, err := params.key(newPassphrase)
+	if err != nil {
+		return err
+	}
+	defer clear(newKey)
+
+	params.Check, err = encrypt(newKey, checkAddress, nil)
+	if err != nil {
+		return err
+	}
+
+	addresses, err := c.storage.GetAddresses()
+	if err != nil {
+		return err
+	}
+
+	wallets := make(map[string][]byte, len(addresses))
+	for _, address := range addresses {
+		data, err := c.storage.GetWallet(address)
+		if err != nil {
+			return fmt.Errorf("failed to get wallet %s: %w", address, err)
+		}
+
+		if oldKey != nil {
+			data, err = decrypt(oldKey, address, data)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt wallet %s: %w", address, err)
+			}
+		}
+
+		wallets[address], err = encrypt(newKey, address, data)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt wallet %s: %w", address, err)
+		}
+	}
+
+	chain, err := c.hdChain()
+	if err != nil {
+		return err
+	}
+
+	var encodedChain []byte
+	if chain != nil {
+		seed := chain.Seed
+		if oldKey != nil {
+			seed, err = decrypt(oldKey, seedAddress, seed)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt seed: %w", err)
+			}
+		}
+
+		chain.Seed, err = encrypt(newKey, seedAddress, seed)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt seed: %w", err)
+		}
+
+		encodedChain, err = chain.Serialize()
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded, err := params.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if err := c.storage.ReplaceWallets(encoded, encodedChain, wallets); err != nil {
+		return fmt.Errorf("failed to store encrypted wallets: %w", err)
+	}
+
+	c.lock()
+	return nil
 }
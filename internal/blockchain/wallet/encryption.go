@@ -0,0 +1,129 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	saltLength = 16
+	keyLength  = chacha20poly1305.KeySize
+
+	// Cost parameters of scrypt, deriving a key takes about 100ms
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+
+	// checkAddress is the associated data of the value that tells whether a passphrase is right.
+	// It is not a valid address, so no wallet can be mistaken for it.
+	checkAddress = "passphrase check"
+)
+
+var (
+	// ErrLocked is returned when the key of an encrypted wallet is needed while the wallets are locked.
+	ErrLocked = errors.New("wallet is locked")
+	// ErrEncrypted is returned when encrypting wallets that already are.
+	ErrEncrypted = errors.New("wallets are already encrypted")
+	// ErrNotEncrypted is returned when unlocking wallets that are not encrypted.
+	ErrNotEncrypted = errors.New("wallets are not encrypted")
+	// ErrWrongPassphrase is returned for a passphrase the wallets are not encrypted with.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// encryptionParams describe how the key the wallets are encrypted with is derived from the passphrase.
+// Each wallet is encrypted with XChaCha20-Poly1305 and its address as associated data,
+// so that an encrypted wallet cannot be moved to another address.
+type encryptionParams struct {
+	// Salt is the random salt of scrypt.
+	Salt []byte
+	// LogN, R and P are the cost parameters of scrypt.
+	LogN uint8
+	R, P int
+	// Check is an empty value encrypted with the key, it tells whether a passphrase is right.
+	Check []byte
+}
+
+func newEncryptionParams() (*encryptionParams, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return &encryptionParams{Salt: salt, LogN: scryptLogN, R: scryptR, P: scryptP}, nil
+}
+
+// key derives the key from a passphrase.
+func (p *encryptionParams) key(passphrase string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), p.Salt, 1<<p.LogN, p.R, p.P, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	return key, nil
+}
+
+// deriveKey derives the key from a passphrase and checks that it is the key the wallets are encrypted with.
+func (p *encryptionParams) deriveKey(passphrase string) ([]byte, error) {
+	key, err := p.key(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := decrypt(key, checkAddress, p.Check); err != nil {
+		clear(key)
+		return nil, ErrWrongPassphrase
+	}
+
+	return key, nil
+}
+
+// Serialize serializes the parameters into a byte slice using gob encoding.
+func (p *encryptionParams) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	if err := gob.NewEncoder(&result).Encode(p); err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// Deserialize deserializes a byte slice into encryptionParams using gob encoding.
+func (p *encryptionParams) Deserialize(d []byte) error {
+	return gob.NewDecoder(bytes.NewReader(d)).Decode(p)
+}
+
+// encrypt encrypts the data of an address, the random nonce is prepended to the ciphertext.
+func encrypt(key []byte, address string, data []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, data, []byte(address)), nil
+}
+
+// decrypt decrypts the data of an address encrypted by encrypt.
+func decrypt(key []byte, address string, data []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(address))
+}
@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/gob"
+	"math/big"
+)
+
+// Chains of keys derived from the seed of an HD wallet.
+const (
+	// ReceiveChain derives the keys of the addresses handed out by AddWallet.
+	ReceiveChain = 0
+	// ChangeChain derives the keys of the addresses change is sent to.
+	ChangeChain = 1
+)
+
+const (
+	// DefaultGapLimit is the number of consecutive unused addresses after which Restore stops deriving keys.
+	DefaultGapLimit = 20
+
+	hardenedOffset = 0x80000000
+	account        = hardenedOffset // Keys are derived as m/0'/chain/index
+	masterSecret   = "Nist256p1 seed"
+	keyBytes       = 32
+)
+
+// hdChain is the seed of an HD wallet and the indexes of the next keys of its chains.
+// Keys are derived from the seed on P-256 as specified by SLIP-10.
+type hdChain struct {
+	// Seed is the seed of the master key, encrypted while the wallets are.
+	Seed []byte
+	// Next are the indexes of the next keys of the receive and change chains.
+	Next [2]uint32
+}
+
+// Serialize serializes the chain into a byte slice using gob encoding.
+func (c *hdChain) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	if err := gob.NewEncoder(&result).Encode(c); err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// Deserialize deserializes a byte slice into hdChain using gob encoding.
+func (c *hdChain) Deserialize(d []byte) error {
+	return gob.NewDecoder(bytes.NewReader(d)).Decode(c)
+}
+
+// extendedKey is a private key together with the chain code its children are derived with.
+type extendedKey struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+// newMasterKey derives the master key of a seed.
+func newMasterKey(seed []byte) *extendedKey {
+	n := elliptic.P256().Params().N
+
+	data := seed
+	for {
+		sum := hmacSHA512([]byte(masterSecret), data)
+
+		key := new(big.Int).SetBytes(sum[:keyBytes])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &extendedKey{key: key, chainCode: sum[keyBytes:]}
+		}
+
+		data = sum
+	}
+}
+
+// child derives the child key with the given index, hardened if the index is at least hardenedOffset.
+func (k *extendedKey) child(index uint32) *extendedKey {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	keyData := k.key.FillBytes(make([]byte, keyBytes))
+
+	var data []byte
+	if index >= hardenedOffset {
+		data = append([]byte{0}, keyData...)
+	} else {
+		x, y := curve.ScalarBaseMult(keyData)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		sum := hmacSHA512(k.chainCode, data)
+
+		tweak := new(big.Int).SetBytes(sum[:keyBytes])
+		if tweak.Cmp(n) < 0 {
+			key := tweak.Add(tweak, k.key)
+			key.Mod(key, n)
+
+			if key.Sign() != 0 {
+				return &extendedKey{key: key, chainCode: sum[keyBytes:]}
+			}
+		}
+
+		// The derived key is invalid, which is practically impossible, so the next candidate is tried
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, sum[keyBytes:]...), index)
+	}
+}
+
+// deriveWallet derives the wallet of a key of a chain from a seed.
+func deriveWallet(seed []byte, chain int, index uint32) *Wallet {
+	key := newMasterKey(seed).child(account).child(uint32(chain)).child(index) //nolint:gosec // Chain is 0 or 1
+
+	return newWalletFromKey(key.key)
+}
+
+// newWalletFromKey creates the wallet of a private key.
+func newWalletFromKey(d *big.Int) *Wallet {
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(d.FillBytes(make([]byte, keyBytes)))
+
+	return &Wallet{
+		PrivateKey: ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+			D:         d,
+		},
+		PublicKey: encodePubKey(curve, x, y),
+	}
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,136 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/jleipus/learn-blockchain/internal/utils"
+)
+
+// Formats private keys can be exported in.
+const (
+	// KeyFormatBase58 is a version byte, the private scalar and a checksum encoded in Base58, like an address.
+	KeyFormatBase58 = "base58"
+	// KeyFormatPKCS8 is a PEM encoded PKCS #8 private key.
+	KeyFormatPKCS8 = "pkcs8"
+	// KeyFormatSEC1 is a PEM encoded SEC 1 EC private key.
+	KeyFormatSEC1 = "sec1"
+)
+
+// KeyFormats are the formats private keys can be exported in.
+var KeyFormats = []string{KeyFormatBase58, KeyFormatPKCS8, KeyFormatSEC1} //nolint:gochecknoglobals // Known formats
+
+// privateKeyVersionOffset is added to the address version to get the version byte of Base58 private keys,
+// so that they can neither be mistaken for addresses nor imported on another network.
+const privateKeyVersionOffset = 0x80
+
+var (
+	// ErrInvalidKey is returned for a private key that cannot be decoded.
+	ErrInvalidKey = errors.New("invalid private key")
+	// ErrKeyVersion is returned for a Base58 private key whose version byte belongs to another network.
+	ErrKeyVersion = errors.New("private key belongs to another network")
+)
+
+// EncodePrivateKey encodes the private key of the wallet in one of KeyFormats.
+// PEM encoded keys end with a newline.
+func (w *Wallet) EncodePrivateKey(format string) (string, error) {
+	switch format {
+	case KeyFormatBase58:
+		payload := make([]byte, 0, VersionLength+keyBytes+ChecksumLength)
+		payload = append(payload, version+privateKeyVersionOffset)
+		payload = append(payload, w.PrivateKey.D.FillBytes(make([]byte, keyBytes))...)
+		payload = append(payload, checksum(payload)...)
+
+		return string(utils.Base58Encode(payload)), nil
+	case KeyFormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(&w.PrivateKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal private key: %w", err)
+		}
+
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	case KeyFormatSEC1:
+		der, err := x509.MarshalECPrivateKey(&w.PrivateKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal private key: %w", err)
+		}
+
+		return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+	default:
+		return "", fmt.Errorf("unknown key format %q, must be one of %v", format, KeyFormats)
+	}
+}
+
+// DecodePrivateKey returns the wallet of a private key in any of KeyFormats.
+// PEM encoded keys are told apart by their block type.
+func DecodePrivateKey(s string) (*Wallet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "-----BEGIN") {
+		return decodeBase58Key(s)
+	}
+
+	block, rest := pem.Decode([]byte(s))
+	if block == nil || len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("%w: expected a single PEM block", ErrInvalidKey)
+	}
+
+	var (
+		key *ecdsa.PrivateKey
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		var parsed any
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = parsed.(*ecdsa.PrivateKey); !ok {
+				return nil, fmt.Errorf("%w: not an ECDSA key", ErrInvalidKey)
+			}
+		}
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unexpected PEM block %q", ErrInvalidKey, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: curve %s is not P-256", ErrInvalidKey, key.Curve.Params().Name)
+	}
+
+	return newWalletFromKey(key.D), nil
+}
+
+// decodeBase58Key decodes a private key in KeyFormatBase58.
+func decodeBase58Key(s string) (*Wallet, error) {
+	payload := utils.Base58Decode([]byte(s))
+	if len(payload) != VersionLength+keyBytes+ChecksumLength {
+		return nil, fmt.Errorf("%w: wrong length", ErrInvalidKey)
+	}
+
+	data, foundChecksum := payload[:VersionLength+keyBytes], payload[VersionLength+keyBytes:]
+	if !bytes.Equal(foundChecksum, checksum(data)) {
+		return nil, fmt.Errorf("%w: invalid checksum", ErrInvalidKey)
+	}
+
+	if data[0] != version+privateKeyVersionOffset {
+		return nil, ErrKeyVersion
+	}
+
+	d := new(big.Int).SetBytes(data[VersionLength:])
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: scalar out of range", ErrInvalidKey)
+	}
+
+	return newWalletFromKey(d), nil
+}
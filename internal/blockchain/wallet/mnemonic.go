@@ -0,0 +1,116 @@
+package wallet
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	entropyLength      = 16   // Bytes of entropy of a new mnemonic, encoded as 12 words
+	bitsPerWord        = 11   // A word encodes 11 bits, an index into the word list
+	seedIterations     = 2048 // PBKDF2 iterations deriving the seed from a mnemonic
+	seedLength         = 64
+	seedSaltPrefix     = "mnemonic"
+	entropyPerChecksum = 32 // Bits of entropy per bit of checksum
+)
+
+// ErrInvalidMnemonic is returned for a mnemonic with unknown words or a wrong checksum.
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// englishWords is the English word list of BIP-39.
+//
+//go:embed english.txt
+var englishWords string
+
+var wordList = strings.Fields(englishWords) //nolint:gochecknoglobals // Parsed word list
+
+// NewMnemonic returns the mnemonic of a new random seed. It is encoded as in BIP-39,
+// so the words can be written down as a backup of all wallets derived from the seed.
+func NewMnemonic() (string, error) {
+	entropy := make([]byte, entropyLength)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", fmt.Errorf("failed to generate entropy: %w", err)
+	}
+
+	return entropyToMnemonic(entropy), nil
+}
+
+// MnemonicToSeed checks a mnemonic and returns the seed it encodes.
+func MnemonicToSeed(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if _, err := mnemonicToEntropy(words); err != nil {
+		return nil, err
+	}
+
+	return pbkdf2.Key(sha512.New, strings.Join(words, " "), []byte(seedSaltPrefix), seedIterations, seedLength)
+}
+
+// entropyToMnemonic encodes entropy followed by a checksum of its hash as words of 11 bits each.
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := len(entropy) * 8 / entropyPerChecksum //nolint:mnd // Bits per byte
+	hash := sha256.Sum256(entropy)
+	data := append(slices.Clone(entropy), hash[0])
+
+	wordCount := (len(entropy)*8 + checksumBits) / bitsPerWord //nolint:mnd // Bits per byte
+	words := make([]string, wordCount)
+	for i := range words {
+		words[i] = wordList[readBits(data, i*bitsPerWord, bitsPerWord)]
+	}
+
+	return strings.Join(words, " ")
+}
+
+// mnemonicToEntropy decodes the entropy of a mnemonic and checks its checksum.
+func mnemonicToEntropy(words []string) ([]byte, error) {
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words, must be 12, 15, 18, 21 or 24", ErrInvalidMnemonic, len(words))
+	}
+
+	totalBits := len(words) * bitsPerWord
+	checksumBits := totalBits / (entropyPerChecksum + 1)
+	data := make([]byte, (totalBits+7)/8) //nolint:mnd // Bits per byte
+
+	for i, word := range words {
+		index := slices.Index(wordList, word)
+		if index < 0 {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		writeBits(data, i*bitsPerWord, bitsPerWord, index)
+	}
+
+	entropy := data[:(totalBits-checksumBits)/8] //nolint:mnd // Bits per byte
+	hash := sha256.Sum256(entropy)
+	if readBits(data, len(entropy)*8, checksumBits) != readBits(hash[:], 0, checksumBits) { //nolint:mnd // Bits per byte
+		return nil, fmt.Errorf("%w: wrong checksum", ErrInvalidMnemonic)
+	}
+
+	return entropy, nil
+}
+
+// readBits returns count bits of data starting at bit offset, most significant bit first.
+func readBits(data []byte, offset, count int) int {
+	value := 0
+	for i := offset; i < offset+count; i++ {
+		bit := (data[i/8] >> (7 - i%8)) & 1 //nolint:mnd // Bits per byte
+		value = value<<1 | int(bit)
+	}
+
+	return value
+}
+
+// writeBits writes the lowest count bits of value to data starting at bit offset, most significant bit first.
+func writeBits(data []byte, offset, count, value int) {
+	for i := range count {
+		if value>>(count-1-i)&1 == 1 {
+			pos := offset + i
+			data[pos/8] |= 1 << (7 - pos%8) //nolint:mnd // Bits per byte
+		}
+	}
+}
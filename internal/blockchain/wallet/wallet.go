@@ -14,15 +14,24 @@ import (
 )
 
 const (
-	version        = byte(0x00) // Version byte for the address
-	VersionLength  = 1          // Length of the version field
-	ChecksumLength = 4          // Length of the checksum
+	MainAddressVersion = byte(0x00) // Version byte for addresses of the main network
+	VersionLength      = 1          // Length of the version field
+	ChecksumLength     = 4          // Length of the checksum
 )
 
 var (
 	ErrAddressTooShort = errors.New("too short")
+	// ErrAddressVersion is returned for an address whose version byte belongs to another network.
+	ErrAddressVersion = errors.New("address belongs to another network")
 )
 
+var version = MainAddressVersion //nolint:gochecknoglobals // Version byte of the network the process runs on
+
+// SetAddressVersion sets the version byte of the addresses the process creates and accepts.
+func SetAddressVersion(v byte) {
+	version = v
+}
+
 // Wallet represents a cryptocurrency wallet containing a private key and a public key.
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
@@ -52,20 +61,37 @@ func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
 		return ecdsa.PrivateKey{}, nil, err
 	}
 
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
+	publicKey := encodePubKey(curve, privateKey.PublicKey.X, privateKey.PublicKey.Y)
 
 	return *privateKey, publicKey, nil
 }
 
+// encodePubKey encodes a public point as its coordinates, each padded to the curve's byte length
+// so that the point can be split in half when verifying signatures.
+func encodePubKey(curve elliptic.Curve, x, y *big.Int) []byte {
+	byteLen := (curve.Params().BitSize + 7) / 8 //nolint:mnd // Magic number for byte length
+
+	pubKey := make([]byte, 2*byteLen)
+	x.FillBytes(pubKey[:byteLen])
+	y.FillBytes(pubKey[byteLen:])
+
+	return pubKey
+}
+
 // getAddress generates a human-readable address from the wallet's public key.
-// The address consists of a version byte, the hashed public key, and a checksum.
-// The full address is encoded in Base58 to make it human-readable.
 func (w *Wallet) getAddress() ([]byte, error) {
 	pubKeyHash, err := HashPubKey(w.PublicKey)
 	if err != nil {
 		return nil, err
 	}
 
+	return []byte(AddressFromPubKeyHash(pubKeyHash)), nil
+}
+
+// AddressFromPubKeyHash returns the address of the outputs locked with the public key hash.
+// The address consists of a version byte, the hashed public key, and a checksum.
+// The full address is encoded in Base58 to make it human-readable.
+func AddressFromPubKeyHash(pubKeyHash []byte) string {
 	payload := make([]byte, 0, VersionLength+len(pubKeyHash)+ChecksumLength)
 
 	payload = append(payload, version)       // Version
@@ -74,8 +100,7 @@ func (w *Wallet) getAddress() ([]byte, error) {
 	checksum := checksum(payload)
 	payload = append(payload, checksum...) // Checksum
 
-	address := utils.Base58Encode(payload)
-	return address, nil
+	return string(utils.Base58Encode(payload))
 }
 
 // Serialize serializes the Wallet into a byte slice to be stored.
@@ -128,7 +153,7 @@ func (w *Wallet) Deserialize(data []byte) error {
 		},
 	}
 
-	pubKey := append(x.Bytes(), y.Bytes()...)
+	pubKey := encodePubKey(curve, x, y)
 
 	w.PrivateKey = priv
 	w.PublicKey = pubKey
@@ -167,6 +192,9 @@ func GetHashFromAddress(address []byte) ([]byte, error) {
 
 func ValidateAddress(address string) error {
 	addressPayload := utils.Base58Decode([]byte(address))
+	if len(addressPayload) < VersionLength+ChecksumLength {
+		return ErrAddressTooShort
+	}
 
 	foundChecksum := addressPayload[len(addressPayload)-ChecksumLength:]
 	foundVersion := addressPayload[0]
@@ -181,6 +209,10 @@ func ValidateAddress(address string) error {
 		return errors.New("invalid checksum")
 	}
 
+	if foundVersion != version {
+		return ErrAddressVersion
+	}
+
 	return nil
 }
 
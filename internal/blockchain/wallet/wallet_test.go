@@ -1,7 +1,15 @@
 package wallet_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
@@ -26,6 +34,9 @@ func TestWallet(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, pubKeyHash, wltHash)
+	assert.Equal(t, address, wallet.AddressFromPubKeyHash(pubKeyHash))
+	require.NoError(t, wallet.ValidateAddress(address))
+	require.ErrorIs(t, wallet.ValidateAddress("1"), wallet.ErrAddressTooShort)
 }
 
 func TestSerializeDeserialize(t *testing.T) {
@@ -42,3 +53,272 @@ func TestSerializeDeserialize(t *testing.T) {
 
 	assert.Equal(t, wlt, &deserialized)
 }
+
+func TestAddressVersion(t *testing.T) {
+	t.Cleanup(func() { wallet.SetAddressVersion(wallet.MainAddressVersion) })
+
+	wallets := wallet.NewCollection(mock.NewStorage())
+
+	mainAddress, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	wallet.SetAddressVersion(0x6f)
+
+	testAddress, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, wallet.ValidateAddress(testAddress))
+	require.ErrorIs(t, wallet.ValidateAddress(mainAddress), wallet.ErrAddressVersion)
+}
+
+func TestEncryption(t *testing.T) {
+	wallets := wallet.NewCollection(mock.NewStorage())
+	address, err := wallets.AddWallet()
+	require.NoError(t, err)
+	wlt, err := wallets.GetWallet(address)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, wallets.Unlock("passphrase", 0), wallet.ErrNotEncrypted)
+	require.Error(t, wallets.Encrypt(""))
+	require.NoError(t, wallets.Encrypt("passphrase"))
+	require.ErrorIs(t, wallets.Encrypt("passphrase"), wallet.ErrEncrypted)
+
+	encrypted, err := wallets.IsEncrypted()
+	require.NoError(t, err)
+	assert.True(t, encrypted)
+
+	t.Run("locked", func(t *testing.T) {
+		_, err := wallets.GetWallet(address)
+		require.ErrorIs(t, err, wallet.ErrLocked)
+
+		_, err = wallets.AddWallet()
+		require.ErrorIs(t, err, wallet.ErrLocked)
+
+		addresses, err := wallets.GetAddresses()
+		require.NoError(t, err)
+		assert.Equal(t, []string{address}, addresses)
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		require.ErrorIs(t, wallets.Unlock("wrong", 0), wallet.ErrWrongPassphrase)
+
+		locked, err := wallets.IsLocked()
+		require.NoError(t, err)
+		assert.True(t, locked)
+	})
+
+	t.Run("unlocked", func(t *testing.T) {
+		require.NoError(t, wallets.Unlock("passphrase", 0))
+		t.Cleanup(wallets.Lock)
+
+		unlocked, err := wallets.GetWallet(address)
+		require.NoError(t, err)
+		assert.Equal(t, wlt, unlocked)
+
+		newAddress, err := wallets.AddWallet()
+		require.NoError(t, err)
+		_, err = wallets.GetWallet(newAddress)
+		require.NoError(t, err)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		require.NoError(t, wallets.Unlock("passphrase", 10*time.Millisecond))
+
+		assert.Eventually(t, func() bool {
+			locked, err := wallets.IsLocked()
+			return err == nil && locked
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("change passphrase", func(t *testing.T) {
+		require.ErrorIs(t, wallets.ChangePassphrase("wrong", "new passphrase"), wallet.ErrWrongPassphrase)
+		require.NoError(t, wallets.ChangePassphrase("passphrase", "new passphrase"))
+
+		require.ErrorIs(t, wallets.Unlock("passphrase", 0), wallet.ErrWrongPassphrase)
+		require.NoError(t, wallets.Unlock("new passphrase", 0))
+		t.Cleanup(wallets.Lock)
+
+		unlocked, err := wallets.GetWallet(address)
+		require.NoError(t, err)
+		assert.Equal(t, wlt, unlocked)
+	})
+}
+
+func TestMnemonic(t *testing.T) {
+	// Test vector of BIP-39
+	seed, err := wallet.MnemonicToSeed(
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about")
+	require.NoError(t, err)
+	assert.Equal(t, "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc1"+
+		"9a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4", hex.EncodeToString(seed))
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	assert.Len(t, strings.Fields(mnemonic), 12)
+	_, err = wallet.MnemonicToSeed(mnemonic)
+	require.NoError(t, err)
+
+	for _, invalid := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon bitcoins",
+		"abandon abandon about",
+	} {
+		_, err := wallet.MnemonicToSeed(invalid)
+		require.ErrorIs(t, err, wallet.ErrInvalidMnemonic, invalid)
+	}
+}
+
+func TestHDWallet(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	wallets1 := wallet.NewCollection(mock.NewStorage())
+	require.NoError(t, wallets1.SetSeed(mnemonic))
+	require.ErrorIs(t, wallets1.SetSeed(mnemonic), wallet.ErrHDChainExists)
+
+	wallets2 := wallet.NewCollection(mock.NewStorage())
+	require.NoError(t, wallets2.Encrypt("passphrase"))
+	require.ErrorIs(t, wallets2.SetSeed(mnemonic), wallet.ErrLocked)
+	require.NoError(t, wallets2.Unlock("passphrase", 0))
+	require.NoError(t, wallets2.SetSeed(mnemonic))
+
+	hasSeed, err := wallets2.HasSeed()
+	require.NoError(t, err)
+	assert.True(t, hasSeed)
+
+	// Both collections derive the same keys, whether the seed is encrypted or not
+	for range 3 {
+		address1, err := wallets1.AddWallet()
+		require.NoError(t, err)
+		address2, err := wallets2.AddWallet()
+		require.NoError(t, err)
+		assert.Equal(t, address1, address2)
+	}
+
+	change1, err := wallets1.ChangeAddress("sender")
+	require.NoError(t, err)
+	require.NoError(t, wallet.ValidateAddress(change1))
+
+	require.NoError(t, wallets2.ChangePassphrase("passphrase", "new passphrase"))
+	_, err = wallets2.ChangeAddress("sender")
+	require.ErrorIs(t, err, wallet.ErrLocked)
+	require.NoError(t, wallets2.Unlock("new passphrase", 0))
+	change2, err := wallets2.ChangeAddress("sender")
+	require.NoError(t, err)
+	assert.Equal(t, change1, change2)
+
+	random := wallet.NewCollection(mock.NewStorage())
+	change, err := random.ChangeAddress("sender")
+	require.NoError(t, err)
+	assert.Equal(t, "sender", change)
+}
+
+func TestKeyFormats(t *testing.T) {
+	t.Cleanup(func() { wallet.SetAddressVersion(wallet.MainAddressVersion) })
+
+	wlt, err := wallet.New()
+	require.NoError(t, err)
+
+	for _, format := range wallet.KeyFormats {
+		t.Run(format, func(t *testing.T) {
+			encoded, err := wlt.EncodePrivateKey(format)
+			require.NoError(t, err)
+
+			decoded, err := wallet.DecodePrivateKey(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, wlt.PublicKey, decoded.PublicKey)
+			assert.Equal(t, 0, wlt.PrivateKey.D.Cmp(decoded.PrivateKey.D))
+		})
+	}
+
+	_, err = wlt.EncodePrivateKey("hex")
+	require.Error(t, err)
+
+	t.Run("invalid", func(t *testing.T) {
+		encoded, err := wlt.EncodePrivateKey(wallet.KeyFormatBase58)
+		require.NoError(t, err)
+
+		tampered := []byte(encoded)
+		if tampered[10] == '2' {
+			tampered[10] = '3'
+		} else {
+			tampered[10] = '2'
+		}
+
+		p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalECPrivateKey(p384)
+		require.NoError(t, err)
+
+		for _, invalid := range []string{
+			"",
+			string(tampered),
+			encoded[:len(encoded)-1],
+			string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+			string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		} {
+			_, err := wallet.DecodePrivateKey(invalid)
+			require.ErrorIs(t, err, wallet.ErrInvalidKey, invalid)
+		}
+
+		wallet.SetAddressVersion(0x6f)
+		_, err = wallet.DecodePrivateKey(encoded)
+		require.ErrorIs(t, err, wallet.ErrKeyVersion)
+	})
+}
+
+func TestImportWallet(t *testing.T) {
+	wlt, err := wallet.New()
+	require.NoError(t, err)
+
+	wallets := wallet.NewCollection(mock.NewStorage())
+	require.NoError(t, wallets.Encrypt("passphrase"))
+
+	_, err = wallets.ImportWallet(wlt)
+	require.ErrorIs(t, err, wallet.ErrLocked)
+
+	require.NoError(t, wallets.Unlock("passphrase", 0))
+	address, err := wallets.ImportWallet(wlt)
+	require.NoError(t, err)
+
+	imported, err := wallets.GetWallet(address)
+	require.NoError(t, err)
+	assert.Equal(t, wlt.PublicKey, imported.PublicKey)
+
+	_, err = wallets.ImportWallet(wlt)
+	require.ErrorIs(t, err, wallet.ErrWalletExists)
+}
+
+func TestWatchAddress(t *testing.T) {
+	wallets := wallet.NewCollection(mock.NewStorage())
+	address, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	wlt, err := wallet.New()
+	require.NoError(t, err)
+	pubKeyHash, err := wallet.HashPubKey(wlt.PublicKey)
+	require.NoError(t, err)
+	watched := wallet.AddressFromPubKeyHash(pubKeyHash)
+
+	require.NoError(t, wallets.AddWatchAddress(watched))
+	require.ErrorIs(t, wallets.AddWatchAddress(watched), wallet.ErrWalletExists)
+	require.ErrorIs(t, wallets.AddWatchAddress(address), wallet.ErrWalletExists)
+	require.Error(t, wallets.AddWatchAddress("1"))
+
+	entries, err := wallets.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, []wallet.Entry{{Address: address}, {Address: watched, WatchOnly: true}}, entries)
+
+	_, err = wallets.GetWallet(watched)
+	require.ErrorIs(t, err, wallet.ErrWatchOnly)
+
+	// Importing the key turns the watched address into a wallet
+	_, err = wallets.ImportWallet(wlt)
+	require.NoError(t, err)
+	_, err = wallets.GetWallet(watched)
+	require.NoError(t, err)
+
+	entries, err = wallets.Entries()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []wallet.Entry{{Address: address}, {Address: watched}}, entries)
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/utxo"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+)
+
+// RestoreWallets restores the HD wallets of a mnemonic and returns their addresses, see wallet.Collection.Restore.
+// A key counts as used if an output of the active chain or of a pending transaction is locked with it.
+func (bc *Blockchain) RestoreWallets(mnemonic string, gapLimit int) ([]string, error) {
+	used, err := bc.usedPubKeyHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.wallets.Restore(mnemonic, gapLimit, func(pubKeyHash []byte) bool {
+		_, ok := used[string(pubKeyHash)]
+		return ok
+	})
+}
+
+// ImportWallet adds a wallet with an existing key and returns its address and its unspent outputs.
+// The outputs are looked up in the UTXO set, which is indexed by public key hash,
+// so outputs paid to the key before the import count towards its balance at once.
+func (bc *Blockchain) ImportWallet(w *wallet.Wallet) (string, map[transaction.Outpoint]utxo.Entry, error) {
+	address, err := bc.wallets.ImportWallet(w)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pubKeyHash, err := wallet.HashPubKey(w.PublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	unspent, err := bc.ListUnspent(pubKeyHash)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find unspent outputs of imported wallet: %w", err)
+	}
+
+	return address, unspent, nil
+}
+
+// SignTransaction signs an unsigned transaction with the wallet of the key its spent outputs are locked with.
+// It needs only the wallets, not the blockchain, so that transactions can be signed on a machine without one.
+func SignTransaction(wallets *wallet.Collection, unsigned *transaction.UnsignedTx) (*transaction.Tx, error) {
+	pubKeyHash, err := unsigned.SignerPubKeyHash()
+	if err != nil {
+		return nil, err
+	}
+
+	address := wallet.AddressFromPubKeyHash(pubKeyHash)
+	wlt, err := wallets.GetWallet(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet for address %s: %w", address, err)
+	}
+
+	return unsigned.Sign(wlt)
+}
+
+// usedPubKeyHashes returns the public key hashes outputs of the active chain and of pending transactions are locked with.
+// Spent outputs count as well, so it is enough to look at outputs.
+func (bc *Blockchain) usedPubKeyHashes() (map[string]struct{}, error) {
+	used := make(map[string]struct{})
+
+	for _, b := range bc.Blocks() {
+		for _, tx := range b.Transactions {
+			for _, out := range tx.Vout {
+				used[string(out.PubKeyHash)] = struct{}{}
+			}
+		}
+	}
+
+	pending, err := bc.PendingTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+
+	for _, tx := range pending {
+		for _, out := range tx.Vout {
+			used[string(out.PubKeyHash)] = struct{}{}
+		}
+	}
+
+	return used, nil
+}
@@ -0,0 +1,498 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/badger"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/node"
+)
+
+// backend runs the operations of the commands,
+// either on the local storage or through a daemon, see remoteBackend.
+type backend interface {
+	NewAddress(ctx context.Context) (string, error)
+	// Addresses returns the addresses of the wallets followed by the watch-only addresses.
+	Addresses(ctx context.Context) ([]wallet.Entry, error)
+	// WatchAddress watches an address without its key.
+	WatchAddress(ctx context.Context, address string) error
+	Balance(ctx context.Context, address string) (int, error)
+	BestHeight(ctx context.Context) (int, error)
+	// Block returns a block given by its hex encoded hash or by its height in the active chain.
+	Block(ctx context.Context, id string) (*blockView, error)
+	Transaction(ctx context.Context, txID transaction.TxID) (*txView, error)
+	PendingTransactions(ctx context.Context) ([]*txView, error)
+	// Send creates a transaction and adds it to the pending transactions.
+	Send(ctx context.Context, from, to string, amount, fee int32) (transaction.TxID, error)
+	SubmitTransaction(ctx context.Context, tx *transaction.Tx) error
+	// CreateTransaction creates a transaction from any address without signing it.
+	CreateTransaction(ctx context.Context, from, to string, amount, fee int32) (*transaction.UnsignedTx, error)
+	// SignTransaction signs an unsigned transaction with the wallet of the key it spends from.
+	SignTransaction(ctx context.Context, unsigned *transaction.UnsignedTx) (*transaction.Tx, error)
+	DropTransaction(ctx context.Context, txID transaction.TxID) error
+	Mine(ctx context.Context, rewardAddress string, maxBlockSize int) (*blockView, error)
+	// Rollback returns the hashes of the disconnected blocks, starting with the old tip.
+	Rollback(ctx context.Context, to block.Hash) ([]block.Hash, error)
+	// Sync syncs from a peer or from a chain directory and returns the number of added blocks and the best height.
+	Sync(ctx context.Context, peer, fromDir string, checkpoints blockchain.Checkpoints) (int, int, error)
+	// EncryptWallet encrypts the wallets with a passphrase, which locks them.
+	EncryptWallet(ctx context.Context, passphrase string) error
+	// Unlock makes the keys of the encrypted wallets available until the timeout passes.
+	Unlock(ctx context.Context, passphrase string, timeout time.Duration) error
+	// ChangePassphrase encrypts the wallets with a new passphrase, which locks them.
+	ChangePassphrase(ctx context.Context, oldPassphrase, newPassphrase string) error
+	// NewMnemonicWallet sets the HD seed of the wallets to a new mnemonic and returns it with the first address.
+	NewMnemonicWallet(ctx context.Context) (string, string, error)
+	// RestoreWallet restores the HD wallets of a mnemonic and returns their addresses.
+	RestoreWallet(ctx context.Context, mnemonic string, gapLimit int) ([]string, error)
+	// ExportKey returns the private key of a wallet address in one of wallet.KeyFormats.
+	ExportKey(ctx context.Context, address, format string) (string, error)
+	// ImportKey adds a wallet with a private key and returns its address and balance.
+	ImportKey(ctx context.Context, key string) (string, int, error)
+}
+
+// blockView is a block of the active chain as the commands print it.
+type blockView struct {
+	hash     block.Hash
+	prevHash block.Hash
+	txs      []*txView
+}
+
+// txView is a transaction as the commands print it.
+// The block hash and confirmations are only set by backend.Transaction.
+type txView struct {
+	tx            *transaction.Tx
+	fee           int32
+	blockHash     block.Hash
+	confirmations int
+}
+
+// localBackend runs the operations on the storage.
+type localBackend struct {
+	storage     blockchain.Storage
+	powFactory  blockchain.ProofOfWorkFactory
+	genesisData string
+	checkpoints blockchain.Checkpoints // Enforced by every blockchain the backend opens
+	wallets     *wallet.Collection
+	passphrase  func() (string, error) // Asks for the passphrase when a key of the locked wallets is needed
+
+	bc *blockchain.Blockchain // Loaded on first use, wallet operations do not need a blockchain
+}
+
+func newLocalBackend(
+	storage blockchain.Storage,
+	powFactory blockchain.ProofOfWorkFactory,
+	genesisData string,
+	checkpoints blockchain.Checkpoints,
+) *localBackend {
+	return &localBackend{
+		storage:     storage,
+		powFactory:  powFactory,
+		genesisData: genesisData,
+		checkpoints: checkpoints,
+		wallets:     wallet.NewCollection(storage),
+	}
+}
+
+func (l *localBackend) blockchain() (*blockchain.Blockchain, error) {
+	if l.bc == nil {
+		bc, err := blockchain.LoadBlockchain(l.storage, l.powFactory, l.wallets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load blockchain: %w", err)
+		}
+		bc.SetGenesisData(l.genesisData)
+		bc.AddCheckpoints(l.checkpoints)
+		l.bc = bc
+	}
+
+	return l.bc, nil
+}
+
+// unlock asks for the passphrase of locked wallets and unlocks them until the command ends.
+func (l *localBackend) unlock() error {
+	locked, err := l.wallets.IsLocked()
+	if err != nil || !locked {
+		return err
+	}
+
+	if l.passphrase == nil {
+		return wallet.ErrLocked
+	}
+
+	passphrase, err := l.passphrase()
+	if err != nil {
+		return err
+	}
+
+	return l.wallets.Unlock(passphrase, 0)
+}
+
+func (l *localBackend) NewAddress(context.Context) (string, error) {
+	if err := l.unlock(); err != nil {
+		return "", err
+	}
+
+	return l.wallets.AddWallet()
+}
+
+func (l *localBackend) Addresses(context.Context) ([]wallet.Entry, error) {
+	return l.wallets.Entries()
+}
+
+func (l *localBackend) WatchAddress(_ context.Context, address string) error {
+	return l.wallets.AddWatchAddress(address)
+}
+
+func (l *localBackend) Balance(_ context.Context, address string) (int, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return 0, err
+	}
+
+	pubKeyHash, err := wallet.GetHashFromAddress([]byte(address))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get public key hash from address: %w", err)
+	}
+
+	outputs, err := bc.FindUnspentTxOutputs(pubKeyHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find unspent transaction outputs: %w", err)
+	}
+
+	balance := 0
+	for _, out := range outputs {
+		balance += int(out.Value)
+	}
+
+	return balance, nil
+}
+
+func (l *localBackend) BestHeight(context.Context) (int, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return 0, err
+	}
+
+	return bc.GetBestHeight(), nil
+}
+
+func (l *localBackend) Block(_ context.Context, id string) (*blockView, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := findBlock(bc, id)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &blockView{hash: b.Hash, prevHash: b.PrevBlockHash}
+	for _, tx := range b.Transactions {
+		txView, err := newTxView(bc, tx)
+		if err != nil {
+			return nil, err
+		}
+		view.txs = append(view.txs, txView)
+	}
+
+	return view, nil
+}
+
+func (l *localBackend) Transaction(_ context.Context, txID transaction.TxID) (*txView, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := bc.GetTransaction(txID)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := newTxView(bc, info.Tx)
+	if err != nil {
+		return nil, err
+	}
+	view.blockHash = info.BlockHash
+	view.confirmations = info.Confirmations
+
+	return view, nil
+}
+
+func (l *localBackend) PendingTransactions(context.Context) ([]*txView, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := bc.PendingTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]*txView, 0, len(txs))
+	for _, tx := range txs {
+		view, err := newTxView(bc, tx)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+func (l *localBackend) Send(_ context.Context, from, to string, amount, fee int32) (transaction.TxID, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return transaction.TxID{}, err
+	}
+
+	if err := l.unlock(); err != nil {
+		return transaction.TxID{}, err
+	}
+
+	tx, err := bc.NewUTXOTransaction(from, to, amount, fee)
+	if err != nil {
+		return transaction.TxID{}, fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	if err := bc.SubmitTransaction(tx); err != nil {
+		return transaction.TxID{}, fmt.Errorf("failed to queue transaction: %w", err)
+	}
+
+	return tx.ID, nil
+}
+
+func (l *localBackend) SubmitTransaction(_ context.Context, tx *transaction.Tx) error {
+	bc, err := l.blockchain()
+	if err != nil {
+		return err
+	}
+
+	return bc.SubmitTransaction(tx)
+}
+
+func (l *localBackend) CreateTransaction(
+	_ context.Context,
+	from, to string,
+	amount, fee int32,
+) (*transaction.UnsignedTx, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.NewUnsignedTransaction(from, to, amount, fee)
+}
+
+// SignTransaction needs only the wallets, so that it works on a machine without a blockchain.
+func (l *localBackend) SignTransaction(_ context.Context, unsigned *transaction.UnsignedTx) (*transaction.Tx, error) {
+	if err := l.unlock(); err != nil {
+		return nil, err
+	}
+
+	return blockchain.SignTransaction(l.wallets, unsigned)
+}
+
+func (l *localBackend) DropTransaction(_ context.Context, txID transaction.TxID) error {
+	bc, err := l.blockchain()
+	if err != nil {
+		return err
+	}
+
+	return bc.DropTransaction(txID)
+}
+
+func (l *localBackend) Mine(ctx context.Context, rewardAddress string, maxBlockSize int) (*blockView, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := bc.Mine(ctx, rewardAddress, maxBlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Block(ctx, hex.EncodeToString(b.Hash[:]))
+}
+
+func (l *localBackend) Rollback(_ context.Context, to block.Hash) ([]block.Hash, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	disconnected, err := bc.Rollback(to)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]block.Hash, 0, len(disconnected))
+	for _, b := range disconnected {
+		hashes = append(hashes, b.Hash)
+	}
+
+	return hashes, nil
+}
+
+func (l *localBackend) Sync(
+	ctx context.Context,
+	peer, fromDir string,
+	checkpoints blockchain.Checkpoints,
+) (int, int, error) {
+	// An empty storage is synced from the genesis block of the source
+	bc := blockchain.NewBlockchain(l.storage, l.powFactory, l.wallets)
+	bc.SetGenesisData(l.genesisData)
+	bc.AddCheckpoints(l.checkpoints)
+	bc.AddCheckpoints(checkpoints)
+
+	var source blockchain.Source
+	if fromDir != "" {
+		sourceStorage, err := badger.NewStorage(fromDir)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to open chain directory %s: %w", fromDir, err)
+		}
+		defer sourceStorage.Close()
+
+		source = blockchain.NewStorageSource(sourceStorage)
+	} else {
+		source = node.NewPeerSource(peer)
+	}
+
+	added, err := bc.Sync(ctx, source)
+	if err != nil {
+		return added, 0, fmt.Errorf("failed after %d blocks: %w", added, err)
+	}
+
+	return added, bc.GetBestHeight(), nil
+}
+
+func (l *localBackend) EncryptWallet(_ context.Context, passphrase string) error {
+	return l.wallets.Encrypt(passphrase)
+}
+
+// Unlock fails, the wallets would be locked again as soon as the command ends.
+// Commands that need a key ask for the passphrase instead.
+func (l *localBackend) Unlock(context.Context, string, time.Duration) error {
+	return errors.New("wallets only stay unlocked while the command runs, unlock a daemon with --daemon-url")
+}
+
+func (l *localBackend) ChangePassphrase(_ context.Context, oldPassphrase, newPassphrase string) error {
+	return l.wallets.ChangePassphrase(oldPassphrase, newPassphrase)
+}
+
+func (l *localBackend) NewMnemonicWallet(context.Context) (string, string, error) {
+	if err := l.unlock(); err != nil {
+		return "", "", err
+	}
+
+	mnemonic, err := wallet.NewMnemonic()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := l.wallets.SetSeed(mnemonic); err != nil {
+		return "", "", err
+	}
+
+	address, err := l.wallets.AddWallet()
+	if err != nil {
+		return "", "", err
+	}
+
+	return mnemonic, address, nil
+}
+
+func (l *localBackend) RestoreWallet(_ context.Context, mnemonic string, gapLimit int) ([]string, error) {
+	bc, err := l.blockchain()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.unlock(); err != nil {
+		return nil, err
+	}
+
+	return bc.RestoreWallets(mnemonic, gapLimit)
+}
+
+func (l *localBackend) ExportKey(_ context.Context, address, format string) (string, error) {
+	if err := l.unlock(); err != nil {
+		return "", err
+	}
+
+	wlt, err := l.wallets.GetWallet(address)
+	if err != nil {
+		return "", err
+	}
+
+	return wlt.EncodePrivateKey(format)
+}
+
+func (l *localBackend) ImportKey(_ context.Context, key string) (string, int, error) {
+	wlt, err := wallet.DecodePrivateKey(key)
+	if err != nil {
+		return "", 0, err
+	}
+
+	bc, err := l.blockchain()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := l.unlock(); err != nil {
+		return "", 0, err
+	}
+
+	address, unspent, err := bc.ImportWallet(wlt)
+	if err != nil {
+		return "", 0, err
+	}
+
+	balance := 0
+	for _, entry := range unspent {
+		balance += int(entry.Output.Value)
+	}
+
+	return address, balance, nil
+}
+
+// newTxView looks up the fee of a transaction.
+func newTxView(bc *blockchain.Blockchain, tx *transaction.Tx) (*txView, error) {
+	fee, err := bc.TransactionFee(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee of transaction %x: %w", tx.ID, err)
+	}
+
+	return &txView{tx: tx, fee: fee}, nil
+}
+
+// findBlock returns a block given by its hex encoded hash or by its height in the active chain.
+func findBlock(bc *blockchain.Blockchain, s string) (*block.Block, error) {
+	if len(s) == hex.EncodedLen(len(block.Hash{})) {
+		data, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+
+		return bc.GetBlock(block.Hash(data))
+	}
+
+	height, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.New("must be a block hash or a height")
+	}
+
+	return bc.GetBlockByHeight(height)
+}
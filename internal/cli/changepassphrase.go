@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newChangePassphraseCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "change-passphrase",
+		Short: "Change the passphrase the wallet keys are encrypted with",
+		Long: `Change the passphrase the wallet keys are encrypted with. The current and the new passphrase
+are read from the standard input. The wallets are locked afterwards.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			oldPassphrase, err := e.readLine(cmd, "Current passphrase: ")
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			newPassphrase, err := e.readNewPassphrase(cmd)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			if err := e.backend.ChangePassphrase(cmd.Context(), oldPassphrase, newPassphrase); err != nil {
+				cmd.PrintErrf("Error changing passphrase: %v\n", err)
+				return
+			}
+
+			cmd.Println("Passphrase changed")
+		},
+	}
+}
@@ -1,41 +1,45 @@
 package cli
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/jleipus/learn-blockchain/internal/blockchain"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 	"github.com/spf13/cobra"
 )
 
-func newCreateBlockchainCmd(storage blockchain.Storage, powFactory blockchain.ProofOfWorkFactory) *cobra.Command {
+func newCreateBlockchainCmd(e *env) *cobra.Command {
 	return &cobra.Command{
-		Use:   "create-blockchain",
-		Short: "Create a new blockchain",
-		Args:  cobra.ExactArgs(1),
+		Use:         "create-blockchain",
+		Short:       "Create a new blockchain",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{localOnlyAnnotation: "true"},
 		Run: func(cmd *cobra.Command, args []string) {
 			if err := wallet.ValidateAddress(args[0]); err != nil {
 				cmd.PrintErrf("Invalid address %s: %v\n", args[0], err)
 				return
 			}
 
-			if err := blockchain.CreateBlockchain(storage, powFactory, args[0]); err != nil {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			genesisData := e.cfg.Params().GenesisData
+			if err := blockchain.CreateBlockchainWithGenesisData(ctx, e.storage, e.powFactory, args[0], genesisData); err != nil {
 				cmd.PrintErrf("Error creating blockchain: %v\n", err)
 				return
 			}
 
-			wallets := wallet.NewCollection(storage)
-
-			bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+			genesis, err := e.storage.GetTip()
 			if err != nil {
-				cmd.PrintErrf("Error loading blockchain: %v\n", err)
-				return
-			}
-
-			if err := bc.ReindexUTXOSet(); err != nil {
-				cmd.PrintErrf("Error reindexing UTXO set: %v\n", err)
+				cmd.PrintErrf("Error getting genesis block: %v\n", err)
 				return
 			}
 
 			cmd.Printf("Blockchain created with genesis block for address: %s\n", args[0])
+			cmd.Printf("Genesis block hash: %x, set it as genesis_hash in the config file of the network's nodes\n", genesis)
 		},
 	}
 }
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newCreateTxCmd(e *env) *cobra.Command {
+	var fee int32
+
+	cmd := &cobra.Command{
+		Use:   "create-tx FROM TO AMOUNT",
+		Short: "Create an unsigned transaction to be signed elsewhere",
+		Long: `Create a transaction from an address to another and print it hex encoded without signing it.
+The sender's key is not needed, FROM may be a watch-only address. The transaction includes the transactions
+it spends, so that sign-tx can sign it and check its fee on a machine with the sender's wallet but without
+the blockchain.
+The change is sent back to the sender.`,
+		Args: cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := wallet.ValidateAddress(args[0]); err != nil {
+				cmd.PrintErrf("Invalid sender address %s: %v\n", args[0], err)
+				return
+			}
+
+			if err := wallet.ValidateAddress(args[1]); err != nil {
+				cmd.PrintErrf("Invalid recipient address %s: %v\n", args[1], err)
+				return
+			}
+
+			amount, err := strconv.ParseInt(args[2], 10, 32)
+			if err != nil || amount <= 0 {
+				cmd.PrintErrf("Invalid amount %s: must be a positive integer\n", args[2])
+				return
+			}
+
+			if fee < 0 {
+				cmd.PrintErrf("Invalid fee %d: must not be negative\n", fee)
+				return
+			}
+
+			unsigned, err := e.backend.CreateTransaction(cmd.Context(), args[0], args[1], int32(amount), fee)
+			if err != nil {
+				cmd.PrintErrf("Error creating transaction: %v\n", err)
+				return
+			}
+
+			cmd.Printf("%s\n", hex.EncodeToString(unsigned.Serialize()))
+		},
+	}
+
+	cmd.Flags().Int32Var(&fee, "fee", 0, "Fee paid to the miner of the block")
+
+	return cmd
+}
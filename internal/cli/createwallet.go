@@ -1,18 +1,34 @@
 package cli
 
 import (
-	"github.com/jleipus/learn-blockchain/internal/blockchain"
-	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 	"github.com/spf13/cobra"
 )
 
-func newCreateWalletCmd(storage blockchain.Storage) *cobra.Command {
-	return &cobra.Command{
+func newCreateWalletCmd(e *env) *cobra.Command {
+	var mnemonic bool
+
+	cmd := &cobra.Command{
 		Use:   "create-wallet",
 		Short: "Create a new wallet",
+		Long: `Create a new wallet and print its address.
+With --mnemonic the wallets become an HD wallet: a new seed is printed as a mnemonic,
+and the keys of all wallets created afterwards are derived from it, so the mnemonic is a backup of all of them.`,
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			wallets := wallet.NewCollection(storage)
-			address, err := wallets.AddWallet()
+			if mnemonic {
+				words, address, err := e.backend.NewMnemonicWallet(cmd.Context())
+				if err != nil {
+					cmd.PrintErrf("Error creating wallet: %v\n", err)
+					return
+				}
+
+				cmd.Printf("Mnemonic: %s\n", words)
+				cmd.Println("Write the mnemonic down and keep it safe, restore-wallet recovers the wallets from it.")
+				cmd.Printf("%s\n", address)
+				return
+			}
+
+			address, err := e.backend.NewAddress(cmd.Context())
 			if err != nil {
 				cmd.PrintErrf("Error creating wallet: %v\n", err)
 				return
@@ -20,4 +36,8 @@ func newCreateWalletCmd(storage blockchain.Storage) *cobra.Command {
 			cmd.Printf("%s\n", address)
 		},
 	}
+
+	cmd.Flags().BoolVar(&mnemonic, "mnemonic", false, "Derive the keys of new wallets from a new mnemonic")
+
+	return cmd
 }
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/rpc"
+	"github.com/spf13/cobra"
+)
+
+func newDaemonCmd(e *env) *cobra.Command {
+	var socket, listen, rpcAddr, rpcUser, rpcPassword string
+	var peers, checkpoints []string
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run a daemon that owns the blockchain and runs the commands of other processes",
+		Long: `Run a daemon that owns the blockchain storage and serves JSON-RPC on a local control socket.
+Other commands run through it with --daemon-url unix://<socket>, while the storage is in use.
+With --listen the daemon is also a node of the network, and with --rpc it serves JSON-RPC over HTTP as well.
+The methods that spend, reveal or replace keys, lock the wallets or rewrite the chain, see serve,
+are only run over the control socket or for clients of --rpc that authenticate.
+An empty data directory can be synced from a peer with the sync command.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{localOnlyAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			if (rpcUser == "") != (rpcPassword == "") {
+				cmd.PrintErrln("Error: --rpc-user and --rpc-password must be set together")
+				return
+			}
+
+			if listen == "" && len(peers) > 0 {
+				cmd.PrintErrln("Error: --peer requires --listen")
+				return
+			}
+
+			parsed, err := parseCheckpoints(checkpoints)
+			if err != nil {
+				cmd.PrintErrf("Invalid checkpoint: %v\n", err)
+				return
+			}
+
+			wallets := wallet.NewCollection(e.storage)
+			bc, err := e.newBlockchain(wallets)
+			if err != nil {
+				cmd.PrintErrf("Error loading blockchain: %v\n", err)
+				return
+			}
+			bc.AddCheckpoints(parsed)
+
+			server := rpc.NewServer(bc, wallets)
+
+			if listen != "" {
+				n := e.newNode(listen, bc)
+				if err := n.Start(); err != nil {
+					cmd.PrintErrf("Error starting node: %v\n", err)
+					return
+				}
+				defer n.Close()
+
+				server.SetNetwork(n)
+				cmd.Printf("Node listening on %s\n", n.Address())
+
+				for _, peer := range peers {
+					if err := n.Connect(peer); err != nil {
+						cmd.PrintErrf("Error connecting to peer %s: %v\n", peer, err)
+					}
+				}
+			}
+
+			handlers := make(map[net.Listener]http.Handler)
+
+			if socket == "" {
+				socket = e.cfg.SocketPath()
+			}
+
+			socketLn, err := listenSocket(socket)
+			if err != nil {
+				cmd.PrintErrf("Error listening on %s: %v\n", socket, err)
+				return
+			}
+			handlers[socketLn] = rpc.Trusted(server) // Only the current user can connect to the socket
+			cmd.Printf("Control socket listening on %s\n", socket)
+
+			if rpcAddr != "" {
+				rpcLn, err := net.Listen("tcp", rpcAddr)
+				if err != nil {
+					socketLn.Close()
+					cmd.PrintErrf("Error listening on %s: %v\n", rpcAddr, err)
+					return
+				}
+				handlers[rpcLn] = withBasicAuth(server, rpcUser, rpcPassword)
+				cmd.Printf("JSON-RPC server listening on %s\n", rpcLn.Addr())
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if err := serveAll(ctx, handlers); err != nil {
+				cmd.PrintErrf("Error serving JSON-RPC: %v\n", err)
+				return
+			}
+
+			cmd.Println("Shutting down daemon")
+		},
+	}
+
+	cmd.Flags().StringVar(&socket, "socket", "", "Path of the control socket, blockchain.sock in the network's data directory if empty")
+	cmd.Flags().StringVar(&listen, "listen", "", "Address to listen on for peers, the daemon does not join the network if empty")
+	cmd.Flags().StringSliceVar(&peers, "peer", nil, "Address of a peer to connect to, can be repeated")
+	cmd.Flags().StringArrayVar(&checkpoints, "checkpoint", nil, "Checkpoint as <height>:<hash>, can be repeated")
+	cmd.Flags().StringVar(&rpcAddr, "rpc", "", "Address to also serve JSON-RPC on over TCP")
+	cmd.Flags().StringVar(&rpcUser, "rpc-user", "", "Username clients of --rpc must authenticate with")
+	cmd.Flags().StringVar(&rpcPassword, "rpc-password", "", "Password clients of --rpc must authenticate with")
+
+	return cmd
+}
+
+// listenSocket listens on a Unix socket that only the current user can connect to.
+// A socket left behind by a daemon that did not shut down is replaced,
+// the daemon holds the storage so no other daemon can be using it. Any other file at the path is kept.
+// The socket is bound in a private directory and only moved to the path once its permissions are restricted,
+// so that no other user can connect in between.
+func listenSocket(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to check socket path: %w", err)
+	case info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	default:
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	// The directory is created with mode 0700, short names keep the path within the length limit of sockets
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".s")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bound := filepath.Join(dir, "s")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false) // The socket is unlinked from its final path by socketListener
+
+	if err := os.Chmod(bound, 0o600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	if err := os.Rename(bound, path); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to move socket into place: %w", err)
+	}
+
+	return &socketListener{UnixListener: ln, path: path}, nil
+}
+
+// socketListener is a Unix socket listener that removes its socket file when it is closed.
+type socketListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.UnixListener.Close()
+
+	if removeErr := os.Remove(l.path); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+		return errors.Join(err, fmt.Errorf("failed to remove socket: %w", removeErr))
+	}
+
+	return err
+}
+
+// serveAll serves HTTP requests on every listener until the context is cancelled or one of them fails.
+func serveAll(ctx context.Context, handlers map[net.Listener]http.Handler) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	for ln, handler := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := serveHTTP(ctx, ln, handler); err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
+}
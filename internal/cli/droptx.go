@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/spf13/cobra"
+)
+
+func newDropTxCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "drop-tx",
+		Short: "Remove a transaction from the pending transactions by its ID",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			txID, err := parseTxID(args[0])
+			if err != nil {
+				cmd.PrintErrf("Invalid transaction ID %s: %v\n", args[0], err)
+				return
+			}
+
+			if err := e.backend.DropTransaction(cmd.Context(), txID); err != nil {
+				cmd.PrintErrf("Error dropping transaction: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Transaction %x dropped\n", txID)
+		},
+	}
+}
+
+// parseTxID parses a hex encoded transaction ID.
+func parseTxID(s string) (transaction.TxID, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return transaction.TxID{}, err
+	}
+
+	if len(data) != len(transaction.TxID{}) {
+		return transaction.TxID{}, errors.New("transaction ID must be 32 bytes long")
+	}
+
+	return transaction.TxID(data), nil
+}
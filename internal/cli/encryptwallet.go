@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newEncryptWalletCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "encrypt-wallet",
+		Short: "Encrypt the wallet keys with a passphrase",
+		Long: `Encrypt the wallet keys with a passphrase, which is read from the standard input.
+Afterwards the wallets are locked: commands that need a key ask for the passphrase,
+and a daemon must be unlocked with the unlock command.
+The unencrypted keys may remain in the storage files until the storage compacts them,
+and can be recovered from the disk until then. Wallets holding funds are best
+encrypted on a new data directory, or their funds moved to new keys afterwards.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase, err := e.readNewPassphrase(cmd)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			if err := e.backend.EncryptWallet(cmd.Context(), passphrase); err != nil {
+				cmd.PrintErrf("Error encrypting wallet: %v\n", err)
+				return
+			}
+
+			cmd.Println("Wallet encrypted")
+		},
+	}
+}
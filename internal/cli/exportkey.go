@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newExportKeyCmd(e *env) *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "export-key ADDRESS",
+		Short: "Print the private key of a wallet",
+		Long: `Print the private key of a wallet, so that it can be imported with import-key elsewhere.
+The --format is base58, a checksummed key like an address, or a PEM encoded pkcs8 or sec1 key.
+Anyone who has the key can spend the funds of the address.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := wallet.ValidateAddress(args[0]); err != nil {
+				cmd.PrintErrf("Invalid address %s: %v\n", args[0], err)
+				return
+			}
+
+			if !slices.Contains(wallet.KeyFormats, format) {
+				cmd.PrintErrf("Invalid format %q: must be one of %v\n", format, wallet.KeyFormats)
+				return
+			}
+
+			key, err := e.backend.ExportKey(cmd.Context(), args[0], format)
+			if err != nil {
+				cmd.PrintErrf("Error exporting key: %v\n", err)
+				return
+			}
+
+			cmd.Printf("%s\n", strings.TrimSuffix(key, "\n"))
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", wallet.KeyFormatBase58,
+		"Format of the key, one of "+strings.Join(wallet.KeyFormats, ", "))
+
+	return cmd
+}
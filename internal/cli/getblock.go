@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newGetBlockCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get-block <height|hash>",
+		Short: "Print a block by its height in the active chain or by its hash",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			b, err := e.backend.Block(cmd.Context(), args[0])
+			if err != nil {
+				cmd.PrintErrf("Error getting block %s: %v\n", args[0], err)
+				return
+			}
+
+			printBlock(b)
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newGetTxCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get-tx <txid>",
+		Short: "Print a transaction and its number of confirmations by its ID",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			txID, err := parseTxID(args[0])
+			if err != nil {
+				cmd.PrintErrf("Invalid transaction ID %s: %v\n", args[0], err)
+				return
+			}
+
+			tx, err := e.backend.Transaction(cmd.Context(), txID)
+			if err != nil {
+				cmd.PrintErrf("Error getting transaction: %v\n", err)
+				return
+			}
+
+			fmt.Println(formatTx(tx))
+			if tx.confirmations == 0 {
+				fmt.Println("     Pending")
+				return
+			}
+
+			fmt.Printf("     Block: %x\n", tx.blockHash)
+			fmt.Printf("     Confirmations: %d\n", tx.confirmations)
+		},
+	}
+}
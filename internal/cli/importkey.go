@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newImportKeyCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-key",
+		Short: "Add a wallet with an existing private key",
+		Long: `Add a wallet with a private key, which is read from the standard input.
+The key may be in any format export-key prints. Outputs already paid to the key count towards
+the balance at once. Imported keys are not covered by the mnemonic of an HD wallet, back them up separately.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			key, err := e.readKey(cmd)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			address, balance, err := e.backend.ImportKey(cmd.Context(), key)
+			if err != nil {
+				cmd.PrintErrf("Error importing key: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Imported %s with balance %d\n", address, balance)
+		},
+	}
+}
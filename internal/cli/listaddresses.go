@@ -1,30 +1,33 @@
 package cli
 
 import (
-	"github.com/jleipus/learn-blockchain/internal/blockchain"
-	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 	"github.com/spf13/cobra"
 )
 
-func newListAddressesCmd(storage blockchain.Storage) *cobra.Command {
+func newListAddressesCmd(e *env) *cobra.Command {
 	return &cobra.Command{
 		Use:   "list-addresses",
 		Short: "List all wallet addresses",
+		Long: `List the addresses of all wallets, followed by the watched addresses.
+Watched addresses are marked watch-only, they have no key and cannot sign.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			wallets := wallet.NewCollection(storage)
-			addresses, err := wallets.GetAddresses()
+			entries, err := e.backend.Addresses(cmd.Context())
 			if err != nil {
 				cmd.Println("Error retrieving addresses:", err)
 				return
 			}
 
-			if len(addresses) == 0 {
+			if len(entries) == 0 {
 				cmd.Println("No addresses found.")
 				return
 			}
 
-			for _, address := range addresses {
-				cmd.Println(address)
+			for _, entry := range entries {
+				if entry.WatchOnly {
+					cmd.Println(entry.Address, "watch-only")
+				} else {
+					cmd.Println(entry.Address)
+				}
 			}
 		},
 	}
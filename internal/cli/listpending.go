@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newListPendingCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list-pending",
+		Short: "List transactions waiting to be mined",
+		Run: func(cmd *cobra.Command, args []string) {
+			txs, err := e.backend.PendingTransactions(cmd.Context())
+			if err != nil {
+				cmd.PrintErrf("Error retrieving pending transactions: %v\n", err)
+				return
+			}
+
+			if len(txs) == 0 {
+				cmd.Println("No pending transactions.")
+				return
+			}
+
+			for _, tx := range txs {
+				cmd.Println(formatTx(tx))
+			}
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newMineCmd(e *env) *cobra.Command {
+	var (
+		address      string
+		blocks       int
+		maxBlockSize int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mine",
+		Short: "Mine blocks from pending transactions",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := wallet.ValidateAddress(address); err != nil {
+				cmd.PrintErrf("Invalid reward address %s: %v\n", address, err)
+				return
+			}
+
+			if blocks <= 0 {
+				cmd.PrintErrf("Invalid number of blocks %d: must be a positive integer\n", blocks)
+				return
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			for range blocks {
+				b, err := e.backend.Mine(ctx, address, maxBlockSize)
+				if err != nil {
+					cmd.PrintErrf("Error mining block: %v\n", err)
+					return
+				}
+
+				cmd.Printf("Mined block %x with %d transactions\n", b.hash, len(b.txs))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&address, "address", "", "Address that receives the block rewards")
+	cmd.Flags().IntVar(&blocks, "blocks", 1, "Number of blocks to mine")
+	cmd.Flags().IntVar(&maxBlockSize, "max-block-size", blockchain.DefaultMaxBlockSize, "Maximum size of a block's transactions in bytes")
+	_ = cmd.MarkFlagRequired("address")
+
+	return cmd
+}
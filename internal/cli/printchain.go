@@ -2,29 +2,76 @@ package cli
 
 import (
 	"fmt"
+	"strconv"
 
-	"github.com/jleipus/learn-blockchain/internal/blockchain"
-	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 	"github.com/spf13/cobra"
 )
 
-func newPrintChainCmd(storage blockchain.Storage, powFactory blockchain.ProofOfWorkFactory) *cobra.Command {
-	return &cobra.Command{
+func newPrintChainCmd(e *env) *cobra.Command {
+	var from, to int
+
+	cmd := &cobra.Command{
 		Use:   "print-chain",
 		Short: "Print the blockchain",
+		Long: `Print the blocks of the active chain from one height to another.
+By default the whole chain is printed, starting from the tip.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			wallets := wallet.NewCollection(storage)
-
-			bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+			bestHeight, err := e.backend.BestHeight(cmd.Context())
 			if err != nil {
-				cmd.PrintErrf("Error loading blockchain: %v\n", err)
+				cmd.PrintErrf("Error getting best height: %v\n", err)
 				return
 			}
 
-			for _, b := range bc.Blocks() {
-				fmt.Printf("============ Block %x ============\n", b.Hash)
-				fmt.Printf("Prev. block: %x\n", b.PrevBlockHash)
+			if !cmd.Flags().Changed("from") {
+				from = bestHeight
+			}
+
+			for _, height := range []int{from, to} {
+				if height < 0 || height > bestHeight {
+					cmd.PrintErrf("Invalid height %d: must be between 0 and %d\n", height, bestHeight)
+					return
+				}
+			}
+
+			step := 1
+			if from > to {
+				step = -1
+			}
+
+			for height := from; ; height += step {
+				b, err := e.backend.Block(cmd.Context(), strconv.Itoa(height))
+				if err != nil {
+					cmd.PrintErrf("Error getting block: %v\n", err)
+					return
+				}
+
+				printBlock(b)
+
+				if height == to {
+					break
+				}
 			}
 		},
 	}
+
+	cmd.Flags().IntVar(&from, "from", 0, "Height of the first block to print (default is the height of the tip)")
+	cmd.Flags().IntVar(&to, "to", 0, "Height of the last block to print")
+
+	return cmd
+}
+
+// printBlock prints a block followed by its transactions.
+func printBlock(b *blockView) {
+	fmt.Printf("============ Block %x ============\n", b.hash)
+	fmt.Printf("Prev. block: %x\n", b.prevHash)
+
+	for _, tx := range b.txs {
+		fmt.Println(formatTx(tx))
+	}
+	fmt.Println()
+}
+
+// formatTx returns the human-readable representation of a transaction followed by its fee.
+func formatTx(tx *txView) string {
+	return fmt.Sprintf("%s\n     Fee: %d", tx.tx, tx.fee)
 }
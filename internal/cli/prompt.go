@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// readLine prompts for a passphrase or mnemonic and reads it as a line of the standard input.
+// Input typed at a terminal is not echoed. Other input is shared by all prompts,
+// so that several lines can be piped to a command.
+func (e *env) readLine(cmd *cobra.Command, prompt string) (string, error) {
+	if fd, ok := terminalFd(cmd.InOrStdin()); ok {
+		cmd.PrintErr(prompt)
+
+		line, err := term.ReadPassword(fd)
+		cmd.PrintErrln() // The typed newline is not echoed either
+		if err != nil {
+			return "", fmt.Errorf("failed to read input: %w", err)
+		}
+
+		return string(line), nil
+	}
+
+	if e.stdin == nil {
+		e.stdin = bufio.NewReader(cmd.InOrStdin())
+	}
+
+	cmd.PrintErr(prompt)
+
+	line, err := e.stdin.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// terminalFd returns the file descriptor of an input that is a terminal.
+func terminalFd(r io.Reader) (int, bool) {
+	f, ok := r.(*os.File)
+	if !ok {
+		return 0, false
+	}
+
+	fd := int(f.Fd()) //nolint:gosec // File descriptors fit in an int
+	return fd, term.IsTerminal(fd)
+}
+
+// readNewPassphrase prompts for a new passphrase twice and checks that both match.
+func (e *env) readNewPassphrase(cmd *cobra.Command) (string, error) {
+	passphrase, err := e.readLine(cmd, "New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	repeated, err := e.readLine(cmd, "Repeat new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase != repeated {
+		return "", errors.New("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+// readKey prompts for a private key and reads it from the standard input.
+// A PEM encoded key is read up to the end of its block, other keys are a single line.
+func (e *env) readKey(cmd *cobra.Command) (string, error) {
+	line, err := e.readLine(cmd, "Private key: ")
+	if err != nil || !strings.HasPrefix(line, "-----BEGIN") {
+		return line, err
+	}
+
+	lines := []string{line}
+	for !strings.HasPrefix(line, "-----END") {
+		line, err = e.readLine(cmd, "")
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
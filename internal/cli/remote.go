@@ -0,0 +1,290 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/rpc"
+)
+
+// remoteBackend runs the operations through the JSON-RPC server of a daemon.
+type remoteBackend struct {
+	client *rpc.Client
+}
+
+func (r *remoteBackend) NewAddress(ctx context.Context) (string, error) {
+	var address string
+	err := r.client.Call(ctx, "getnewaddress", &address)
+	return address, err
+}
+
+func (r *remoteBackend) Addresses(ctx context.Context) ([]wallet.Entry, error) {
+	var results []rpc.AddressResult
+	if err := r.client.Call(ctx, "listaddresses", &results); err != nil {
+		return nil, err
+	}
+
+	entries := make([]wallet.Entry, 0, len(results))
+	for _, result := range results {
+		entries = append(entries, wallet.Entry{Address: result.Address, WatchOnly: result.WatchOnly})
+	}
+
+	return entries, nil
+}
+
+func (r *remoteBackend) WatchAddress(ctx context.Context, address string) error {
+	return r.client.Call(ctx, "importaddress", nil, address)
+}
+
+func (r *remoteBackend) Balance(ctx context.Context, address string) (int, error) {
+	var balance int
+	err := r.client.Call(ctx, "getbalance", &balance, address)
+	return balance, err
+}
+
+func (r *remoteBackend) BestHeight(ctx context.Context) (int, error) {
+	var height int
+	err := r.client.Call(ctx, "getblockcount", &height)
+	return height, err
+}
+
+func (r *remoteBackend) Block(ctx context.Context, id string) (*blockView, error) {
+	var param any = id
+	if height, err := strconv.Atoi(id); err == nil {
+		param = height
+	}
+
+	var result rpc.BlockResult
+	if err := r.client.Call(ctx, "getblock", &result, param); err != nil {
+		return nil, err
+	}
+
+	return newBlockViewFromResult(result)
+}
+
+func (r *remoteBackend) Transaction(ctx context.Context, txID transaction.TxID) (*txView, error) {
+	var result rpc.TxResult
+	if err := r.client.Call(ctx, "gettransaction", &result, hex.EncodeToString(txID[:])); err != nil {
+		return nil, err
+	}
+
+	return newTxViewFromResult(result)
+}
+
+func (r *remoteBackend) PendingTransactions(ctx context.Context) ([]*txView, error) {
+	var results []rpc.TxResult
+	if err := r.client.Call(ctx, "listpending", &results); err != nil {
+		return nil, err
+	}
+
+	views := make([]*txView, 0, len(results))
+	for _, result := range results {
+		view, err := newTxViewFromResult(result)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+func (r *remoteBackend) Send(ctx context.Context, from, to string, amount, fee int32) (transaction.TxID, error) {
+	var txID string
+	if err := r.client.Call(ctx, "sendtoaddress", &txID, from, to, amount, fee); err != nil {
+		return transaction.TxID{}, err
+	}
+
+	return parseTxID(txID)
+}
+
+func (r *remoteBackend) SubmitTransaction(ctx context.Context, tx *transaction.Tx) error {
+	return r.client.Call(ctx, "sendrawtransaction", nil, hex.EncodeToString(tx.Serialize()))
+}
+
+func (r *remoteBackend) CreateTransaction(
+	ctx context.Context,
+	from, to string,
+	amount, fee int32,
+) (*transaction.UnsignedTx, error) {
+	var unsignedHex string
+	if err := r.client.Call(ctx, "createrawtransaction", &unsignedHex, from, to, amount, fee); err != nil {
+		return nil, err
+	}
+
+	return parseUnsignedTx(unsignedHex)
+}
+
+func (r *remoteBackend) SignTransaction(
+	ctx context.Context,
+	unsigned *transaction.UnsignedTx,
+) (*transaction.Tx, error) {
+	var signedHex string
+	if err := r.client.Call(ctx, "signrawtransaction", &signedHex, hex.EncodeToString(unsigned.Serialize())); err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(signedHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction data: %w", err)
+	}
+
+	tx := &transaction.Tx{}
+	if err := tx.Deserialize(data); err != nil {
+		return nil, fmt.Errorf("invalid transaction data: %w", err)
+	}
+
+	return tx, nil
+}
+
+func (r *remoteBackend) DropTransaction(ctx context.Context, txID transaction.TxID) error {
+	return r.client.Call(ctx, "droptransaction", nil, hex.EncodeToString(txID[:]))
+}
+
+func (r *remoteBackend) Mine(ctx context.Context, rewardAddress string, maxBlockSize int) (*blockView, error) {
+	var result rpc.BlockResult
+	if err := r.client.Call(ctx, "mine", &result, rewardAddress, maxBlockSize); err != nil {
+		return nil, err
+	}
+
+	return newBlockViewFromResult(result)
+}
+
+func (r *remoteBackend) Rollback(ctx context.Context, to block.Hash) ([]block.Hash, error) {
+	var results []string
+	if err := r.client.Call(ctx, "rollback", &results, hex.EncodeToString(to[:])); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]block.Hash, 0, len(results))
+	for _, result := range results {
+		hash, err := parseBlockHash(result)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
+
+func (r *remoteBackend) Sync(
+	ctx context.Context,
+	peer, fromDir string,
+	checkpoints blockchain.Checkpoints,
+) (int, int, error) {
+	if fromDir != "" {
+		return 0, 0, errors.New("a daemon can only sync from a peer")
+	}
+
+	hashes := make(map[int]string, len(checkpoints))
+	for height, hash := range checkpoints {
+		hashes[height] = hex.EncodeToString(hash[:])
+	}
+
+	var result rpc.SyncResult
+	if err := r.client.Call(ctx, "sync", &result, peer, hashes); err != nil {
+		return 0, 0, err
+	}
+
+	return result.Added, result.Height, nil
+}
+
+func (r *remoteBackend) EncryptWallet(ctx context.Context, passphrase string) error {
+	return r.client.Call(ctx, "encryptwallet", nil, passphrase)
+}
+
+func (r *remoteBackend) Unlock(ctx context.Context, passphrase string, timeout time.Duration) error {
+	return r.client.Call(ctx, "walletpassphrase", nil, passphrase, int(timeout.Seconds()))
+}
+
+func (r *remoteBackend) ChangePassphrase(ctx context.Context, oldPassphrase, newPassphrase string) error {
+	return r.client.Call(ctx, "walletpassphrasechange", nil, oldPassphrase, newPassphrase)
+}
+
+func (r *remoteBackend) NewMnemonicWallet(ctx context.Context) (string, string, error) {
+	var result rpc.HDWalletResult
+	err := r.client.Call(ctx, "createhdwallet", &result)
+	return result.Mnemonic, result.Address, err
+}
+
+func (r *remoteBackend) RestoreWallet(ctx context.Context, mnemonic string, gapLimit int) ([]string, error) {
+	var addresses []string
+	err := r.client.Call(ctx, "restorewallet", &addresses, mnemonic, gapLimit)
+	return addresses, err
+}
+
+func (r *remoteBackend) ExportKey(ctx context.Context, address, format string) (string, error) {
+	var key string
+	err := r.client.Call(ctx, "dumpprivkey", &key, address, format)
+	return key, err
+}
+
+func (r *remoteBackend) ImportKey(ctx context.Context, key string) (string, int, error) {
+	var result rpc.ImportKeyResult
+	err := r.client.Call(ctx, "importprivkey", &result, key)
+	return result.Address, result.Balance, err
+}
+
+func newBlockViewFromResult(result rpc.BlockResult) (*blockView, error) {
+	hash, err := parseBlockHash(result.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	prevHash, err := parseBlockHash(result.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &blockView{hash: hash, prevHash: prevHash}
+	for _, txResult := range result.Transactions {
+		txView, err := newTxViewFromResult(txResult)
+		if err != nil {
+			return nil, err
+		}
+		view.txs = append(view.txs, txView)
+	}
+
+	return view, nil
+}
+
+func newTxViewFromResult(result rpc.TxResult) (*txView, error) {
+	data, err := hex.DecodeString(result.Hex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction %s: %w", result.TxID, err)
+	}
+
+	tx := &transaction.Tx{}
+	if err := tx.Deserialize(data); err != nil {
+		return nil, fmt.Errorf("invalid transaction %s: %w", result.TxID, err)
+	}
+
+	view := &txView{tx: tx, fee: result.Fee, confirmations: result.Confirmations}
+	if result.BlockHash != "" {
+		view.blockHash, err = parseBlockHash(result.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return view, nil
+}
+
+// parseBlockHash parses a hex encoded block hash.
+func parseBlockHash(s string) (block.Hash, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != len(block.Hash{}) {
+		return block.Hash{}, fmt.Errorf("invalid block hash %q", s)
+	}
+
+	return block.Hash(data), nil
+}
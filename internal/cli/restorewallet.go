@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newRestoreWalletCmd(e *env) *cobra.Command {
+	var gapLimit int
+
+	cmd := &cobra.Command{
+		Use:   "restore-wallet",
+		Short: "Restore the HD wallets of a mnemonic",
+		Long: `Restore the HD wallets of a mnemonic, which is read from the standard input.
+The keys of the receive and change chains are derived until --gap-limit consecutive ones were never paid
+in the blockchain, so sync the blockchain first. Keys derived later continue after the restored ones.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if gapLimit <= 0 {
+				cmd.PrintErrf("Invalid gap limit %d: must be positive\n", gapLimit)
+				return
+			}
+
+			mnemonic, err := e.readLine(cmd, "Mnemonic: ")
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			addresses, err := e.backend.RestoreWallet(cmd.Context(), mnemonic, gapLimit)
+			if err != nil {
+				cmd.PrintErrf("Error restoring wallet: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Restored %d addresses\n", len(addresses))
+			for _, address := range addresses {
+				cmd.Printf("%s\n", address)
+			}
+		},
+	}
+
+	cmd.Flags().IntVar(&gapLimit, "gap-limit", wallet.DefaultGapLimit,
+		"Number of consecutive unused addresses after which the search stops")
+
+	return cmd
+}
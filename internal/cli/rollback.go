@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newRollbackCmd(e *env) *cobra.Command {
+	var to string
+
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Disconnect blocks from the tip until the given block is the tip",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			target, err := e.backend.Block(cmd.Context(), to)
+			if err != nil {
+				cmd.PrintErrf("Invalid block %s: %v\n", to, err)
+				return
+			}
+
+			disconnected, err := e.backend.Rollback(cmd.Context(), target.hash)
+			if err != nil {
+				cmd.PrintErrf("Error rolling back blockchain: %v\n", err)
+				return
+			}
+
+			for _, hash := range disconnected {
+				cmd.Printf("Disconnected block %x\n", hash)
+			}
+
+			height, err := e.backend.BestHeight(cmd.Context())
+			if err != nil {
+				cmd.PrintErrf("Error getting best height: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Tip is now block %x at height %d\n", target.hash, height)
+		},
+	}
+
+	cmd.Flags().StringVar(&to, "to", "", "Hash or height of the block that becomes the new tip")
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
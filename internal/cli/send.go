@@ -1,16 +1,24 @@
 package cli
 
 import (
+	"context"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
 	"github.com/jleipus/learn-blockchain/internal/blockchain"
-	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
 	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
 	"github.com/spf13/cobra"
 )
 
-func newSendCmd(storage blockchain.Storage, powFactory blockchain.ProofOfWorkFactory) *cobra.Command {
-	return &cobra.Command{
+func newSendCmd(e *env) *cobra.Command {
+	var (
+		noMine bool
+		fee    int32
+	)
+
+	cmd := &cobra.Command{
 		Use:   "send",
 		Short: "Send coins to an address",
 		Args:  cobra.ExactArgs(3),
@@ -31,36 +39,35 @@ func newSendCmd(storage blockchain.Storage, powFactory blockchain.ProofOfWorkFac
 				return
 			}
 
-			wallets := wallet.NewCollection(storage)
-
-			bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
-			if err != nil {
-				cmd.PrintErrf("Error loading blockchain: %v\n", err)
+			if fee < 0 {
+				cmd.PrintErrf("Invalid fee %d: must not be negative\n", fee)
 				return
 			}
 
-			tx, err := bc.NewUTXOTransaction(args[0], args[1], int32(amount))
+			txID, err := e.backend.Send(cmd.Context(), args[0], args[1], int32(amount), fee)
 			if err != nil {
-				cmd.PrintErrf("Error creating transaction: %v\n", err)
+				cmd.PrintErrf("Error sending: %v\n", err)
 				return
 			}
 
-			cbTx, err := transaction.NewCoinbaseTX(args[0], "")
-			if err != nil {
-				cmd.PrintErrf("Error creating coinbase transaction: %v\n", err)
+			if noMine {
+				cmd.Printf("Transaction %x queued\n", txID)
 				return
 			}
 
-			b, err := bc.MineBlock([]*transaction.Tx{tx, cbTx})
-			if err != nil {
-				cmd.PrintErrf("Error mining block: %v\n", err)
-				return
-			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
 
-			if err := bc.Update(*b); err != nil {
-				cmd.PrintErrf("Error updating UTXO set: %v\n", err)
+			// The sender mines the block and receives its reward
+			if _, err := e.backend.Mine(ctx, args[0], blockchain.DefaultMaxBlockSize); err != nil {
+				cmd.PrintErrf("Error mining block: %v\n", err)
 				return
 			}
 		},
 	}
+
+	cmd.Flags().Int32Var(&fee, "fee", 0, "Fee paid to the miner of the block")
+	cmd.Flags().BoolVar(&noMine, "no-mine", false, "Queue the transaction as pending instead of mining a block")
+
+	return cmd
 }
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/rpc"
+	"github.com/spf13/cobra"
+)
+
+const (
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 5 * time.Second
+)
+
+func newServeCmd(e *env) *cobra.Command {
+	var rpcAddr, rpcUser, rpcPassword string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the blockchain and its wallets over JSON-RPC",
+		Long: `Serve the blockchain and its wallets over HTTP with JSON-RPC 2.0.
+The methods are getblockcount, getblock, gettransaction, getbalance, sendtoaddress,
+listunspent, getnewaddress, listaddresses, importaddress, submitblock, sendrawtransaction,
+createrawtransaction, signrawtransaction, listpending, droptransaction, mine, rollback, sync,
+encryptwallet, walletpassphrase, walletpassphrasechange, walletlock, createhdwallet, restorewallet,
+dumpprivkey and importprivkey.
+Parameters are passed by position. The methods that spend, reveal or replace keys, lock the wallets
+or rewrite the chain are refused unless clients authenticate with --rpc-user and --rpc-password:
+sendtoaddress, signrawtransaction, rollback, sync, encryptwallet, walletpassphrasechange,
+createhdwallet, restorewallet, dumpprivkey and importprivkey.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{localOnlyAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			if (rpcUser == "") != (rpcPassword == "") {
+				cmd.PrintErrln("Error: --rpc-user and --rpc-password must be set together")
+				return
+			}
+
+			wallets := wallet.NewCollection(e.storage)
+
+			bc, err := blockchain.LoadBlockchain(e.storage, e.powFactory, wallets)
+			if err != nil {
+				cmd.PrintErrf("Error loading blockchain: %v\n", err)
+				return
+			}
+			bc.SetGenesisData(e.cfg.Params().GenesisData)
+			bc.AddCheckpoints(e.cfg.Checkpoints())
+
+			addr := listenAddress(rpcAddr, e.cfg.RPCPort)
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				cmd.PrintErrf("Error listening on %s: %v\n", addr, err)
+				return
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			cmd.Printf("JSON-RPC server listening on %s\n", ln.Addr())
+
+			if err := serveHTTP(ctx, ln, withBasicAuth(rpc.NewServer(bc, wallets), rpcUser, rpcPassword)); err != nil {
+				cmd.PrintErrf("Error serving JSON-RPC: %v\n", err)
+				return
+			}
+
+			cmd.Println("Shutting down JSON-RPC server")
+		},
+	}
+
+	cmd.Flags().StringVar(&rpcAddr, "rpc", "", "Address to serve JSON-RPC on, localhost:<rpc-port> if empty")
+	cmd.Flags().StringVar(&rpcUser, "rpc-user", "", "Username clients must authenticate with")
+	cmd.Flags().StringVar(&rpcPassword, "rpc-password", "", "Password clients must authenticate with")
+
+	return cmd
+}
+
+// withBasicAuth requires basic auth for a handler, unless no username is given.
+func withBasicAuth(handler http.Handler, username, password string) http.Handler {
+	if username == "" {
+		return handler
+	}
+
+	return rpc.BasicAuth(handler, username, password)
+}
+
+// serveHTTP serves HTTP requests on a listener until the context is cancelled.
+// It returns once the requests in progress are handled.
+func serveHTTP(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: readHeaderTimeout}
+
+	shutdown := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(shutdown)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	})
+
+	err := server.Serve(ln)
+	if !errors.Is(err, http.ErrServerClosed) {
+		stop()
+		return err
+	}
+
+	<-shutdown
+	return nil
+}
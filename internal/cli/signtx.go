@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newSignTxCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "sign-tx UNSIGNED_TX",
+		Short: "Sign a transaction created by create-tx",
+		Long: `Sign a hex encoded unsigned transaction created by create-tx with the wallet of the sender.
+Only the wallets are needed, not the blockchain, so the transaction can be signed on an offline machine.
+The outputs and the fee are printed before the signed transaction, which submit-tx sends.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			unsigned, err := parseUnsignedTx(args[0])
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			fee, err := unsigned.Fee()
+			if err != nil {
+				cmd.PrintErrf("Invalid transaction: %v\n", err)
+				return
+			}
+
+			for _, out := range unsigned.Tx.Vout {
+				cmd.Printf("Pays %d to %s\n", out.Value, wallet.AddressFromPubKeyHash(out.PubKeyHash))
+			}
+			cmd.Printf("Fee %d\n", fee)
+
+			tx, err := e.backend.SignTransaction(cmd.Context(), unsigned)
+			if err != nil {
+				cmd.PrintErrf("Error signing transaction: %v\n", err)
+				return
+			}
+
+			cmd.Printf("%s\n", hex.EncodeToString(tx.Serialize()))
+		},
+	}
+}
+
+// parseUnsignedTx decodes a hex encoded unsigned transaction.
+func parseUnsignedTx(s string) (*transaction.UnsignedTx, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction data: %w", err)
+	}
+
+	unsigned := &transaction.UnsignedTx{}
+	if err := unsigned.Deserialize(data); err != nil {
+		return nil, fmt.Errorf("invalid transaction data: %w", err)
+	}
+
+	return unsigned, nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newStartNodeCmd(e *env) *cobra.Command {
+	var listen string
+	var peers, checkpoints []string
+
+	cmd := &cobra.Command{
+		Use:         "start-node",
+		Short:       "Start a node that syncs blocks and transactions with its peers",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{localOnlyAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			parsed, err := parseCheckpoints(checkpoints)
+			if err != nil {
+				cmd.PrintErrf("Invalid checkpoint: %v\n", err)
+				return
+			}
+
+			wallets := wallet.NewCollection(e.storage)
+			bc, err := e.newBlockchain(wallets)
+			if err != nil {
+				cmd.PrintErrf("Error loading blockchain: %v\n", err)
+				return
+			}
+			bc.AddCheckpoints(parsed)
+
+			n := e.newNode(listenAddress(listen, e.cfg.P2PPort), bc)
+			if err := n.Start(); err != nil {
+				cmd.PrintErrf("Error starting node: %v\n", err)
+				return
+			}
+			defer n.Close()
+
+			cmd.Printf("Node listening on %s\n", n.Address())
+
+			for _, peer := range peers {
+				if err := n.Connect(peer); err != nil {
+					cmd.PrintErrf("Error connecting to peer %s: %v\n", peer, err)
+				}
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			<-ctx.Done()
+			cmd.Println("Shutting down node")
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "", "Address to listen on, localhost:<p2p-port> if empty")
+	cmd.Flags().StringSliceVar(&peers, "peer", nil, "Address of a peer to connect to, can be repeated")
+	cmd.Flags().StringArrayVar(&checkpoints, "checkpoint", nil, "Checkpoint as <height>:<hash>, can be repeated")
+
+	return cmd
+}
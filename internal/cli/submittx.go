@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"encoding/hex"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/spf13/cobra"
+)
+
+func newSubmitTxCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:     "submit-tx",
+		Aliases: []string{"broadcast-tx"},
+		Short:   "Add a hex encoded serialized transaction to the pending transactions",
+		Long: `Add a hex encoded serialized transaction, such as one signed by sign-tx, to the pending transactions.
+Through a daemon the transaction is announced to its peers as well.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := hex.DecodeString(args[0])
+			if err != nil {
+				cmd.PrintErrf("Invalid transaction data: %v\n", err)
+				return
+			}
+
+			tx := &transaction.Tx{}
+			if err := tx.Deserialize(data); err != nil {
+				cmd.PrintErrf("Invalid transaction data: %v\n", err)
+				return
+			}
+
+			if err := e.backend.SubmitTransaction(cmd.Context(), tx); err != nil {
+				cmd.PrintErrf("Error submitting transaction: %v\n", err)
+				return
+			}
+
+			cmd.Printf("%x\n", tx.ID)
+		},
+	}
+}
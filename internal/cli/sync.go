@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/spf13/cobra"
+)
+
+func newSyncCmd(e *env) *cobra.Command {
+	var fromDir, peer string
+	var checkpoints []string
+
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Bring the blockchain up to date from a peer or an exported chain directory",
+		Long: `Bring the blockchain up to date from a peer or an exported chain directory.
+The headers are validated first, then the blocks are downloaded in parallel.
+An empty data directory is synced from the genesis block of the source,
+which must be the genesis block set by genesis_hash in the config file, if any.
+Through a daemon, only --peer is supported and the checkpoints only apply to this sync.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if (fromDir == "") == (peer == "") {
+				cmd.PrintErrln("Error: exactly one of --from-dir and --peer is required")
+				return
+			}
+
+			parsed, err := parseCheckpoints(checkpoints)
+			if err != nil {
+				cmd.PrintErrf("Invalid checkpoint: %v\n", err)
+				return
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			added, height, err := e.backend.Sync(ctx, peer, fromDir, parsed)
+			if err != nil {
+				cmd.PrintErrf("Error syncing blockchain: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Added %d blocks, the best height is %d\n", added, height)
+		},
+	}
+
+	cmd.Flags().StringVar(&fromDir, "from-dir", "", "Chain directory to sync from, it must not be in use")
+	cmd.Flags().StringVar(&peer, "peer", "", "Address of a peer to sync from")
+	cmd.Flags().StringArrayVar(&checkpoints, "checkpoint", nil, "Checkpoint as <height>:<hash>, can be repeated")
+
+	return cmd
+}
+
+// parseCheckpoints parses checkpoints given as <height>:<hash>.
+func parseCheckpoints(values []string) (blockchain.Checkpoints, error) {
+	checkpoints := make(blockchain.Checkpoints, len(values))
+
+	for _, value := range values {
+		heightStr, hashStr, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s: must be <height>:<hash>", value)
+		}
+
+		height, err := strconv.Atoi(heightStr)
+		if err != nil || height < 0 {
+			return nil, fmt.Errorf("%s: invalid height", value)
+		}
+
+		data, err := hex.DecodeString(hashStr)
+		if err != nil || len(data) != len(block.Hash{}) {
+			return nil, fmt.Errorf("%s: invalid block hash", value)
+		}
+
+		checkpoints[height] = block.Hash(data)
+	}
+
+	return checkpoints, nil
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newUnlockCmd(e *env) *cobra.Command {
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "Unlock the encrypted wallets of a daemon for a while",
+		Long: `Unlock the encrypted wallets of a daemon, so that it can sign transactions until the timeout passes.
+The passphrase is read from the standard input. Without a daemon, commands that need a key ask for the passphrase.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if e.cfg.DaemonURL == "" {
+				cmd.PrintErrln("Error: unlock needs a daemon, without one commands that need a key ask for the passphrase")
+				return
+			}
+
+			if timeout < time.Second {
+				cmd.PrintErrf("Invalid timeout %s: must be at least one second\n", timeout)
+				return
+			}
+
+			passphrase, err := e.readLine(cmd, "Passphrase: ")
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				return
+			}
+
+			if err := e.backend.Unlock(cmd.Context(), passphrase, timeout); err != nil {
+				cmd.PrintErrf("Error unlocking wallet: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Wallet unlocked for %s\n", timeout)
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "Time after which the wallets are locked again")
+
+	return cmd
+}
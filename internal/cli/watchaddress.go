@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func newWatchAddressCmd(e *env) *cobra.Command {
+	return &cobra.Command{
+		Use:   "watch-address ADDRESS",
+		Short: "Watch an address without its key",
+		Long: `Watch an address whose key is kept elsewhere, such as in cold storage.
+The address is listed by list-addresses and its balance can be checked, but nothing can be sent from it.
+Importing its key with import-key later turns it into a full wallet.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := wallet.ValidateAddress(args[0]); err != nil {
+				cmd.PrintErrf("Invalid address %s: %v\n", args[0], err)
+				return
+			}
+
+			if err := e.backend.WatchAddress(cmd.Context(), args[0]); err != nil {
+				cmd.PrintErrf("Error watching address: %v\n", err)
+				return
+			}
+
+			cmd.Printf("Watching %s\n", args[0])
+		},
+	}
+}
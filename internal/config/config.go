@@ -0,0 +1,243 @@
+// Package config holds the settings of the CLI: the networks it can run on and the options of its config file.
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/hashcash"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the config file that is read from the data directory if no other file is given.
+const FileName = "config.yaml"
+
+// Names of the built-in networks.
+const (
+	MainNetwork    = "main"
+	TestNetwork    = "test"
+	RegtestNetwork = "regtest"
+)
+
+// Log levels, from the most to the least verbose.
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+//nolint:gochecknoglobals // Known log levels
+var logLevels = []string{LogDebug, LogInfo, LogWarn, LogError}
+
+// Network holds the parameters that differ between networks.
+// Every network has its own genesis block and address version byte,
+// so that blocks and addresses of one network are rejected by the others.
+type Network struct {
+	Name string
+	// GenesisData is the coinbase data of the genesis block.
+	GenesisData string
+	// AddressVersion is the version byte of addresses.
+	AddressVersion byte
+	// PoW are the difficulty parameters of the proof of work.
+	PoW hashcash.Params
+	// P2PPort is the default port nodes listen on for peers.
+	P2PPort int
+	// RPCPort is the default port JSON-RPC is served on.
+	RPCPort int
+}
+
+// Networks are the built-in networks by name.
+// The test network is easier to mine than the main network, the regtest network is meant for local testing.
+var Networks = map[string]Network{ //nolint:gochecknoglobals // Built-in networks
+	MainNetwork: {
+		Name:           MainNetwork,
+		GenesisData:    blockchain.MainGenesisData,
+		AddressVersion: wallet.MainAddressVersion,
+		PoW:            hashcash.DefaultParams,
+		P2PPort:        3000,
+		RPCPort:        8545,
+	},
+	TestNetwork: {
+		Name:           TestNetwork,
+		GenesisData:    "Test network genesis block",
+		AddressVersion: 0x6f,
+		PoW: hashcash.Params{
+			InitialBits:      16,
+			RetargetInterval: 10,
+			TargetBlockTime:  5 * time.Second,
+		},
+		P2PPort: 13000,
+		RPCPort: 18545,
+	},
+	RegtestNetwork: {
+		Name:           RegtestNetwork,
+		GenesisData:    "Regression test network genesis block",
+		AddressVersion: 0x3c,
+		PoW: hashcash.Params{
+			InitialBits:      8,
+			RetargetInterval: 10,
+			TargetBlockTime:  time.Second,
+		},
+		P2PPort: 23000,
+		RPCPort: 28545,
+	},
+}
+
+// Config are the settings shared by all commands. Unset settings are zero and take the defaults of the network.
+type Config struct {
+	// DataDir is the directory the data of all networks is kept in.
+	// The main network uses it directly, other networks use a subdirectory named after them.
+	DataDir string `yaml:"data_dir"`
+	// Network is the name of the network to run on.
+	Network string `yaml:"network"`
+	// Difficulty is the number of leading zero bits a genesis block hash must have,
+	// replacing the initial difficulty of the network.
+	Difficulty uint32 `yaml:"difficulty"`
+	// LogLevel is the least severe level of the messages that are logged, one of debug, info, warn and error.
+	LogLevel string `yaml:"log_level"`
+	// P2PPort is the port nodes listen on for peers.
+	P2PPort int `yaml:"p2p_port"`
+	// RPCPort is the port JSON-RPC is served on.
+	RPCPort int `yaml:"rpc_port"`
+	// DaemonURL is the URL of the daemon commands run through, if any.
+	DaemonURL string `yaml:"daemon_url"`
+	// GenesisHash is the hex encoded hash of the network's genesis block, if it is known.
+	// Every blockchain made by create-blockchain starts from its own genesis block,
+	// so the nodes of a network pin theirs here and refuse to sync any other chain.
+	GenesisHash string `yaml:"genesis_hash"`
+}
+
+// Default returns the settings used when neither a config file nor flags change them.
+func Default() Config {
+	return Config{
+		DataDir:  ".",
+		Network:  MainNetwork,
+		LogLevel: LogInfo,
+	}
+}
+
+// Load reads a YAML config file over the current settings.
+// Unknown keys are rejected, so that misspelled settings do not go unnoticed.
+func (c *Config) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Resolve checks the settings and fills in the defaults of the network for the unset ones.
+func (c *Config) Resolve() error {
+	network, ok := Networks[c.Network]
+	if !ok {
+		return fmt.Errorf("unknown network %q, must be one of %s, %s and %s",
+			c.Network, MainNetwork, TestNetwork, RegtestNetwork)
+	}
+
+	if !slices.Contains(logLevels, c.LogLevel) {
+		return fmt.Errorf("unknown log level %q, must be one of %v", c.LogLevel, logLevels)
+	}
+
+	if c.Difficulty == 0 {
+		c.Difficulty = network.PoW.InitialBits
+	}
+	if c.Difficulty >= 256 {
+		return fmt.Errorf("invalid difficulty %d, must be less than 256", c.Difficulty)
+	}
+	if err := c.PoWParams().Validate(); err != nil {
+		return fmt.Errorf("invalid proof of work parameters of network %s: %w", c.Network, err)
+	}
+
+	if c.GenesisHash != "" {
+		if _, err := c.genesisHash(); err != nil {
+			return err
+		}
+	}
+
+	if c.P2PPort == 0 {
+		c.P2PPort = network.P2PPort
+	}
+	if c.RPCPort == 0 {
+		c.RPCPort = network.RPCPort
+	}
+
+	return nil
+}
+
+// Params returns the parameters of the network. The settings must be resolved.
+func (c Config) Params() Network {
+	return Networks[c.Network]
+}
+
+// PoWParams returns the difficulty parameters of the network with the configured difficulty.
+func (c Config) PoWParams() hashcash.Params {
+	params := c.Params().PoW
+	params.InitialBits = c.Difficulty
+
+	return params
+}
+
+// Checkpoints returns the checkpoints of the settings: the genesis block at height 0, if its hash is set.
+// The settings must be resolved.
+func (c Config) Checkpoints() blockchain.Checkpoints {
+	checkpoints := blockchain.Checkpoints{}
+	if hash, err := c.genesisHash(); err == nil {
+		checkpoints[0] = hash
+	}
+
+	return checkpoints
+}
+
+func (c Config) genesisHash() (block.Hash, error) {
+	data, err := hex.DecodeString(c.GenesisHash)
+	if err != nil || len(data) != len(block.Hash{}) {
+		return block.Hash{}, fmt.Errorf("invalid genesis hash %q, must be %d hex encoded bytes",
+			c.GenesisHash, len(block.Hash{}))
+	}
+
+	return block.Hash(data), nil
+}
+
+// NetworkDir returns the directory the data of the network is kept in.
+func (c Config) NetworkDir() string {
+	if c.Network == MainNetwork {
+		return c.DataDir
+	}
+
+	return filepath.Join(c.DataDir, c.Network)
+}
+
+// StoragePath returns the directory of the blockchain storage.
+func (c Config) StoragePath() string {
+	return filepath.Join(c.NetworkDir(), "blockchain.db")
+}
+
+// SocketPath returns the default path of the daemon's control socket.
+func (c Config) SocketPath() string {
+	return filepath.Join(c.NetworkDir(), "blockchain.sock")
+}
+
+// Logs reports whether messages of the given level are logged.
+// Mining progress is logged at info, failures of the network at warn.
+func (c Config) Logs(level string) bool {
+	return slices.Index(logLevels, level) >= slices.Index(logLevels, c.LogLevel)
+}
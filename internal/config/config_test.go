@@ -0,0 +1,109 @@
+package config_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), config.FileName)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg := config.Default()
+		require.NoError(t, cfg.Resolve())
+
+		assert.Equal(t, ".", cfg.NetworkDir())
+		assert.Equal(t, "blockchain.db", cfg.StoragePath())
+		assert.Equal(t, uint32(18), cfg.PoWParams().InitialBits)
+		assert.Equal(t, 3000, cfg.P2PPort)
+		assert.Equal(t, 8545, cfg.RPCPort)
+		assert.True(t, cfg.Logs(config.LogInfo))
+		assert.False(t, cfg.Logs(config.LogDebug))
+	})
+
+	t.Run("load", func(t *testing.T) {
+		path := writeConfig(t, "network: regtest\ndata_dir: /data\ndifficulty: 4\nlog_level: warn\nrpc_port: 9000\n")
+
+		cfg := config.Default()
+		require.NoError(t, cfg.Load(path))
+		require.NoError(t, cfg.Resolve())
+
+		assert.Equal(t, config.Networks[config.RegtestNetwork], cfg.Params())
+		assert.Equal(t, filepath.Join("/data", "regtest"), cfg.NetworkDir())
+		assert.Equal(t, filepath.Join("/data", "regtest", "blockchain.sock"), cfg.SocketPath())
+		assert.Equal(t, uint32(4), cfg.PoWParams().InitialBits)
+		assert.Equal(t, 23000, cfg.P2PPort)
+		assert.Equal(t, 9000, cfg.RPCPort)
+		assert.False(t, cfg.Logs(config.LogInfo))
+		assert.True(t, cfg.Logs(config.LogError))
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		cfg := config.Default()
+		require.NoError(t, cfg.Load(writeConfig(t, "")))
+		assert.Equal(t, config.Default(), cfg)
+	})
+
+	t.Run("genesis hash", func(t *testing.T) {
+		hash := strings.Repeat("ab", 32)
+		path := writeConfig(t, "genesis_hash: "+hash+"\n")
+
+		cfg := config.Default()
+		require.NoError(t, cfg.Load(path))
+		require.NoError(t, cfg.Resolve())
+
+		genesis, err := hex.DecodeString(hash)
+		require.NoError(t, err)
+		assert.Equal(t, blockchain.Checkpoints{0: block.Hash(genesis)}, cfg.Checkpoints())
+
+		assert.Empty(t, config.Default().Checkpoints())
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		cfg := config.Default()
+		assert.Error(t, cfg.Load(writeConfig(t, "netwrok: test\n")))
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for _, cfg := range []config.Config{
+			{Network: "other", LogLevel: config.LogInfo},
+			{Network: config.MainNetwork, LogLevel: "verbose"},
+			{Network: config.MainNetwork, LogLevel: config.LogInfo, Difficulty: 256},
+			{Network: config.MainNetwork, LogLevel: config.LogInfo, GenesisHash: "abcd"},
+			{Network: config.MainNetwork, LogLevel: config.LogInfo, GenesisHash: strings.Repeat("zz", 32)},
+		} {
+			assert.Error(t, cfg.Resolve(), cfg)
+		}
+	})
+}
+
+func TestNetworks(t *testing.T) {
+	genesisData := make(map[string]bool)
+	versions := make(map[byte]bool)
+
+	for _, network := range config.Networks {
+		assert.False(t, genesisData[network.GenesisData], network.Name)
+		assert.False(t, versions[network.AddressVersion], network.Name)
+
+		genesisData[network.GenesisData] = true
+		versions[network.AddressVersion] = true
+
+		assert.NoError(t, network.PoW.Validate(), network.Name)
+	}
+}
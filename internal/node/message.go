@@ -0,0 +1,150 @@
+package node
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+)
+
+const (
+	protocolVersion = 1
+	commandLength   = 12 // Length of the command prefix of every message
+)
+
+// MaxMessageSize is the largest message a node reads from a connection, in bytes.
+// It leaves room for a block of the default maximum size and the encoding around it.
+const MaxMessageSize = 4 * blockchain.DefaultMaxBlockSize
+
+// ErrMessageTooLarge is returned for a message that exceeds MaxMessageSize.
+var ErrMessageTooLarge = errors.New("message too large")
+
+type command string
+
+const (
+	cmdVersion    command = "version"
+	cmdGetBlocks  command = "getblocks"
+	cmdInv        command = "inv"
+	cmdGetData    command = "getdata"
+	cmdBlock      command = "block"
+	cmdTx         command = "tx"
+	cmdGetHeaders command = "getheaders"
+	cmdHeaders    command = "headers"
+)
+
+// invType is the kind of object an inventory or data request refers to.
+type invType string
+
+const (
+	invBlock invType = "block"
+	invTx    invType = "tx"
+)
+
+// versionMsg is the handshake message. It tells the receiver how long the sender's chain is.
+type versionMsg struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string
+}
+
+// getBlocksMsg asks the receiver to announce all blocks it has.
+type getBlocksMsg struct {
+	AddrFrom string
+}
+
+// invMsg announces blocks or transactions the sender has.
+type invMsg struct {
+	AddrFrom string
+	Type     invType
+	Items    [][32]byte
+}
+
+// getDataMsg requests a single block or transaction from the receiver.
+// Without a sender address the requested block is sent back on the same connection.
+type getDataMsg struct {
+	AddrFrom string
+	Type     invType
+	ID       [32]byte
+}
+
+// getHeadersMsg asks the receiver for the headers of its active chain that follow the first block
+// of the locator it knows. It is answered on the same connection.
+type getHeadersMsg struct {
+	Locator [][32]byte
+}
+
+// headersMsg carries block headers, oldest first.
+type headersMsg struct {
+	Headers []headerEntry
+}
+
+// headerEntry is a serialized block header together with the block hash.
+type headerEntry struct {
+	Hash   [32]byte
+	Header []byte
+}
+
+// blockMsg carries a serialized block.
+type blockMsg struct {
+	AddrFrom string
+	Block    []byte
+}
+
+// txMsg carries a serialized transaction.
+type txMsg struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+// encodeMessage builds a message from a command and its payload.
+// The command is padded to commandLength bytes and followed by the gob encoded payload.
+func encodeMessage(cmd command, payload any) ([]byte, error) {
+	if len(cmd) > commandLength {
+		return nil, fmt.Errorf("command %q is too long", cmd)
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte(cmd))
+	buf.Write(make([]byte, commandLength-len(cmd)))
+
+	encoder := gob.NewEncoder(&buf)
+	if err := encoder.Encode(payload); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readMessage reads a message until the sender closes the connection, at most MaxMessageSize bytes of it.
+func readMessage(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrMessageTooLarge, MaxMessageSize)
+	}
+
+	return data, nil
+}
+
+// decodeMessage splits a message into its command and the encoded payload.
+func decodeMessage(data []byte) (command, []byte, error) {
+	if len(data) < commandLength {
+		return "", nil, errors.New("message too short")
+	}
+
+	cmd := bytes.TrimRight(data[:commandLength], "\x00")
+
+	return command(cmd), data[commandLength:], nil
+}
+
+// decodePayload decodes a gob encoded payload into v.
+func decodePayload(data []byte, v any) error {
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	return decoder.Decode(v)
+}
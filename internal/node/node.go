@@ -0,0 +1,519 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	readTimeout = 30 * time.Second
+)
+
+// Node is a peer in the blockchain network.
+// It listens for messages from other nodes over TCP and keeps its blockchain in sync with them.
+// Every message is sent over its own connection, which is closed once the message is written.
+// Requests of a PeerSource are the exception, they are answered on the connection they arrived on.
+type Node struct {
+	address string
+	bc      *blockchain.Blockchain
+	logger  *log.Logger
+
+	// mu serializes message handling, the blockchain is not safe for concurrent use.
+	mu              sync.Mutex
+	peers           map[string]struct{}
+	blocksInTransit []block.Hash
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// New creates a node that will listen on the given address and serve the given blockchain.
+func New(address string, bc *blockchain.Blockchain) *Node {
+	return &Node{
+		address: address,
+		bc:      bc,
+		logger:  log.Default(),
+		peers:   make(map[string]struct{}),
+	}
+}
+
+// SetLogger replaces the logger used to report network activity.
+func (n *Node) SetLogger(logger *log.Logger) {
+	n.logger = logger
+}
+
+// Address returns the address the node is reachable at.
+func (n *Node) Address() string {
+	return n.address
+}
+
+// Start starts listening for incoming connections.
+// If the configured port is 0, a free port is chosen and Address reports it.
+func (n *Node) Start() error {
+	ln, err := net.Listen("tcp", n.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", n.address, err)
+	}
+
+	host, port, err := net.SplitHostPort(n.address)
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("invalid address %s: %w", n.address, err)
+	}
+
+	if port == "0" {
+		_, port, err = net.SplitHostPort(ln.Addr().String())
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("invalid listener address: %w", err)
+		}
+		n.address = net.JoinHostPort(host, port)
+	}
+
+	n.listener = ln
+
+	n.wg.Add(1)
+	go n.serve()
+
+	return nil
+}
+
+// Close stops listening and waits for all pending messages to be handled.
+func (n *Node) Close() error {
+	if n.listener == nil {
+		return nil
+	}
+
+	err := n.listener.Close()
+	n.wg.Wait()
+
+	return err
+}
+
+// Connect adds a peer and starts the version handshake with it.
+func (n *Node) Connect(peer string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.peers[peer] = struct{}{}
+
+	return n.sendVersion(peer)
+}
+
+// Peers returns the addresses of all known peers.
+func (n *Node) Peers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	peers := make([]string, 0, len(n.peers))
+	for peer := range n.peers {
+		peers = append(peers, peer)
+	}
+	slices.Sort(peers)
+
+	return peers
+}
+
+// BestHeight returns the height of the node's blockchain.
+func (n *Node) BestHeight() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.bc.GetBestHeight()
+}
+
+// PendingTransactions returns the transactions that are waiting to be included in a block.
+func (n *Node) PendingTransactions() ([]*transaction.Tx, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.bc.PendingTransactions()
+}
+
+// SubmitTx validates a transaction, adds it to the mempool and announces it to all peers.
+func (n *Node) SubmitTx(tx *transaction.Tx) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.acceptTx(tx, "")
+}
+
+// BroadcastBlock announces a block, e.g. one that was mined locally, to all peers.
+func (n *Node) BroadcastBlock(b *block.Block) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.broadcastInv(invBlock, b.Hash, "")
+}
+
+// BroadcastTx announces a transaction that was added to the mempool without SubmitTx to all peers.
+func (n *Node) BroadcastTx(tx *transaction.Tx) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.broadcastInv(invTx, tx.ID, "")
+}
+
+// Do calls fn while no message is handled, so that fn can use the node's blockchain.
+func (n *Node) Do(fn func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	fn()
+}
+
+func (n *Node) serve() {
+	defer n.wg.Done()
+
+	for {
+		conn, err := n.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			n.logger.Printf("failed to accept connection: %v", err)
+			continue
+		}
+
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.handleConnection(conn)
+		}()
+	}
+}
+
+func (n *Node) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		n.logger.Printf("failed to set read deadline: %v", err)
+		return
+	}
+
+	data, err := readMessage(conn)
+	if err != nil {
+		n.logger.Printf("failed to read message: %v", err)
+		return
+	}
+
+	cmd, payload, err := decodeMessage(data)
+	if err != nil {
+		n.logger.Printf("failed to decode message: %v", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.handleMessage(cmd, payload, conn); err != nil {
+		n.logger.Printf("failed to handle %s message: %v", cmd, err)
+	}
+}
+
+// handleMessage handles a message, requests that expect an answer on the same connection are answered on reply.
+func (n *Node) handleMessage(cmd command, payload []byte, reply io.Writer) error {
+	switch cmd {
+	case cmdVersion:
+		return n.handleVersion(payload)
+	case cmdGetBlocks:
+		return n.handleGetBlocks(payload)
+	case cmdInv:
+		return n.handleInv(payload)
+	case cmdGetData:
+		return n.handleGetData(payload, reply)
+	case cmdBlock:
+		return n.handleBlock(payload)
+	case cmdTx:
+		return n.handleTx(payload)
+	case cmdGetHeaders:
+		return n.handleGetHeaders(payload, reply)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func (n *Node) handleVersion(payload []byte) error {
+	var msg versionMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	if msg.AddrFrom == n.address {
+		return nil
+	}
+
+	n.peers[msg.AddrFrom] = struct{}{}
+
+	bestHeight := n.bc.GetBestHeight()
+	switch {
+	case bestHeight < msg.BestHeight:
+		return n.send(msg.AddrFrom, cmdGetBlocks, getBlocksMsg{AddrFrom: n.address})
+	case bestHeight > msg.BestHeight:
+		return n.sendVersion(msg.AddrFrom)
+	default:
+		return nil
+	}
+}
+
+func (n *Node) handleGetBlocks(payload []byte) error {
+	var msg getBlocksMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	hashes := n.bc.GetBlockHashes()
+	items := make([][32]byte, 0, len(hashes))
+	for _, hash := range hashes {
+		items = append(items, hash)
+	}
+
+	return n.send(msg.AddrFrom, cmdInv, invMsg{AddrFrom: n.address, Type: invBlock, Items: items})
+}
+
+func (n *Node) handleInv(payload []byte) error {
+	var msg invMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case invBlock:
+		// Blocks are announced starting from the tip, request the missing ones oldest first
+		// so that each received block extends the chain.
+		var missing []block.Hash
+		for _, item := range slices.Backward(msg.Items) {
+			hash := block.Hash(item)
+			if !n.bc.HasBlock(hash) && !slices.Contains(n.blocksInTransit, hash) {
+				missing = append(missing, hash)
+			}
+		}
+
+		if len(missing) == 0 {
+			return nil
+		}
+
+		n.blocksInTransit = append(n.blocksInTransit, missing[1:]...)
+
+		return n.send(msg.AddrFrom, cmdGetData, getDataMsg{AddrFrom: n.address, Type: invBlock, ID: missing[0]})
+	case invTx:
+		for _, item := range msg.Items {
+			if _, err := n.bc.GetPendingTransaction(transaction.TxID(item)); err == nil {
+				continue
+			}
+
+			err := n.send(msg.AddrFrom, cmdGetData, getDataMsg{AddrFrom: n.address, Type: invTx, ID: item})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown inventory type %q", msg.Type)
+	}
+}
+
+func (n *Node) handleGetData(payload []byte, reply io.Writer) error {
+	var msg getDataMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case invBlock:
+		b, err := n.bc.GetBlock(block.Hash(msg.ID))
+		if err != nil {
+			return fmt.Errorf("failed to get block %x: %w", msg.ID, err)
+		}
+
+		if msg.AddrFrom == "" {
+			return writeMessage(reply, cmdBlock, blockMsg{AddrFrom: n.address, Block: b.Serialize()})
+		}
+
+		return n.send(msg.AddrFrom, cmdBlock, blockMsg{AddrFrom: n.address, Block: b.Serialize()})
+	case invTx:
+		tx, err := n.bc.GetPendingTransaction(transaction.TxID(msg.ID))
+		if err != nil {
+			return fmt.Errorf("failed to get transaction %x: %w", msg.ID, err)
+		}
+
+		return n.send(msg.AddrFrom, cmdTx, txMsg{AddrFrom: n.address, Transaction: tx.Serialize()})
+	default:
+		return fmt.Errorf("unknown inventory type %q", msg.Type)
+	}
+}
+
+func (n *Node) handleGetHeaders(payload []byte, reply io.Writer) error {
+	var msg getHeadersMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	locator := make([]block.Hash, 0, len(msg.Locator))
+	for _, hash := range msg.Locator {
+		locator = append(locator, hash)
+	}
+
+	headers, err := n.bc.GetHeaders(locator)
+	if err != nil {
+		return fmt.Errorf("failed to get headers: %w", err)
+	}
+
+	entries := make([]headerEntry, 0, len(headers))
+	for _, header := range headers {
+		entries = append(entries, headerEntry{Hash: header.Hash, Header: header.Header.Serialize()})
+	}
+
+	return writeMessage(reply, cmdHeaders, headersMsg{Headers: entries})
+}
+
+func (n *Node) handleBlock(payload []byte) error {
+	var msg blockMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	b := &block.Block{}
+	if err := b.Deserialize(msg.Block); err != nil {
+		return fmt.Errorf("failed to deserialize block: %w", err)
+	}
+
+	if b.PrevBlockHash != (block.Hash{}) && !n.bc.HasBlock(b.PrevBlockHash) {
+		// The parent is unknown, ask the sender for its chain so the missing blocks are fetched in order.
+		if err := n.send(msg.AddrFrom, cmdGetBlocks, getBlocksMsg{AddrFrom: n.address}); err != nil {
+			return err
+		}
+	} else if err := n.acceptBlock(b, msg.AddrFrom); err != nil {
+		return err
+	}
+
+	return n.requestNextBlock(msg.AddrFrom)
+}
+
+// acceptBlock adds a block to the blockchain and announces it to all peers except the sender.
+func (n *Node) acceptBlock(b *block.Block, from string) error {
+	if n.bc.HasBlock(b.Hash) {
+		return nil
+	}
+
+	if err := n.bc.AddBlock(b); err != nil {
+		return fmt.Errorf("failed to add block %x: %w", b.Hash, err)
+	}
+
+	n.logger.Printf("received block %x from %s", b.Hash, from)
+	n.broadcastInv(invBlock, b.Hash, from)
+
+	return nil
+}
+
+// requestNextBlock requests the next block that was announced but not yet received.
+func (n *Node) requestNextBlock(addr string) error {
+	if len(n.blocksInTransit) == 0 {
+		return nil
+	}
+
+	next := n.blocksInTransit[0]
+	n.blocksInTransit = n.blocksInTransit[1:]
+
+	return n.send(addr, cmdGetData, getDataMsg{AddrFrom: n.address, Type: invBlock, ID: next})
+}
+
+func (n *Node) handleTx(payload []byte) error {
+	var msg txMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+
+	tx := &transaction.Tx{}
+	if err := tx.Deserialize(msg.Transaction); err != nil {
+		return fmt.Errorf("failed to deserialize transaction: %w", err)
+	}
+
+	return n.acceptTx(tx, msg.AddrFrom)
+}
+
+// acceptTx adds a transaction to the mempool and announces it to all peers except the sender.
+func (n *Node) acceptTx(tx *transaction.Tx, from string) error {
+	if _, err := n.bc.GetPendingTransaction(tx.ID); err == nil {
+		return nil
+	}
+
+	if err := n.bc.SubmitTransaction(tx); err != nil {
+		return fmt.Errorf("failed to accept transaction %x: %w", tx.ID, err)
+	}
+
+	n.broadcastInv(invTx, tx.ID, from)
+
+	return nil
+}
+
+// broadcastInv announces an item to all known peers except the one it was received from.
+func (n *Node) broadcastInv(kind invType, id [32]byte, except string) {
+	for peer := range n.peers {
+		if peer == except {
+			continue
+		}
+
+		err := n.send(peer, cmdInv, invMsg{AddrFrom: n.address, Type: kind, Items: [][32]byte{id}})
+		if err != nil {
+			n.logger.Printf("failed to announce %s %x to %s: %v", kind, id, peer, err)
+		}
+	}
+}
+
+func (n *Node) sendVersion(addr string) error {
+	return n.send(addr, cmdVersion, versionMsg{
+		Version:    protocolVersion,
+		BestHeight: n.bc.GetBestHeight(),
+		AddrFrom:   n.address,
+	})
+}
+
+// send delivers a single message to the given address.
+// Peers that cannot be reached are forgotten.
+func (n *Node) send(addr string, cmd command, payload any) error {
+	data, err := encodeMessage(cmd, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", cmd, err)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		delete(n.peers, addr)
+		return fmt.Errorf("peer %s is not available: %w", addr, err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to send %s message to %s: %w", cmd, addr, err)
+	}
+
+	return nil
+}
+
+// writeMessage answers a request on the connection it arrived on.
+func writeMessage(w io.Writer, cmd command, payload any) error {
+	data, err := encodeMessage(cmd, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", cmd, err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to send %s message: %w", cmd, err)
+	}
+
+	return nil
+}
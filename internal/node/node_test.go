@@ -0,0 +1,191 @@
+package node_test
+
+import (
+	"io"
+	"log"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	waitFor = 5 * time.Second
+	tick    = 10 * time.Millisecond
+)
+
+func startNode(t *testing.T, bc *blockchain.Blockchain) *node.Node {
+	t.Helper()
+
+	n := node.New("127.0.0.1:0", bc)
+	n.SetLogger(log.New(io.Discard, "", 0))
+	require.NoError(t, n.Start())
+	t.Cleanup(func() { n.Close() })
+
+	return n
+}
+
+func emptyBlockchain() *blockchain.Blockchain {
+	storage := mock.NewStorage()
+	return blockchain.NewBlockchain(storage, mock.NewPoWFactory(), wallet.NewCollection(storage))
+}
+
+func tipOf(bc *blockchain.Blockchain) block.Hash {
+	hashes := bc.GetBlockHashes()
+	if len(hashes) == 0 {
+		return block.Hash{}
+	}
+	return hashes[0]
+}
+
+func TestSync(t *testing.T) {
+	storage := mock.NewStorage()
+	powFactory := mock.NewPoWFactory()
+	wallets := wallet.NewCollection(storage)
+
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+	address2, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bcA, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+	require.NoError(t, bcA.ReindexUTXOSet())
+
+	tx, err := bcA.NewUTXOTransaction(address1, address2, 4, 0)
+	require.NoError(t, err)
+	cbTx, err := transaction.NewCoinbaseTX(address1, "")
+	require.NoError(t, err)
+	_, err = bcA.MineBlock(t.Context(), []*transaction.Tx{cbTx, tx})
+	require.NoError(t, err)
+
+	bcB := emptyBlockchain()
+	bcC := emptyBlockchain()
+
+	nodeA := startNode(t, bcA)
+	nodeB := startNode(t, bcB)
+	nodeC := startNode(t, bcC)
+
+	t.Run("initial sync", func(t *testing.T) {
+		require.NoError(t, nodeB.Connect(nodeA.Address()))
+		require.NoError(t, nodeC.Connect(nodeB.Address()))
+
+		assert.Eventually(t, func() bool {
+			return nodeB.BestHeight() == 1 && nodeC.BestHeight() == 1
+		}, waitFor, tick)
+
+		assert.Equal(t, tipOf(bcA), tipOf(bcB))
+		assert.Equal(t, tipOf(bcA), tipOf(bcC))
+		assert.Contains(t, nodeA.Peers(), nodeB.Address())
+		assert.Contains(t, nodeB.Peers(), nodeC.Address())
+	})
+
+	var relayed *transaction.Tx
+	t.Run("transaction relay", func(t *testing.T) {
+		relayed, err = bcA.NewUTXOTransaction(address1, address2, 3, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, nodeA.SubmitTx(relayed))
+
+		assert.Eventually(t, func() bool {
+			return containsTx(t, nodeC, relayed.ID)
+		}, waitFor, tick)
+		assert.True(t, containsTx(t, nodeB, relayed.ID))
+	})
+
+	t.Run("block relay", func(t *testing.T) {
+		cbTx, err := transaction.NewCoinbaseTX(address1, "")
+		require.NoError(t, err)
+		b, err := bcA.MineBlock(t.Context(), []*transaction.Tx{cbTx, relayed})
+		require.NoError(t, err)
+
+		nodeA.BroadcastBlock(b)
+
+		assert.Eventually(t, func() bool {
+			return nodeC.BestHeight() == 2
+		}, waitFor, tick)
+
+		assert.Equal(t, b.Hash, tipOf(bcC))
+		assert.False(t, containsTx(t, nodeA, relayed.ID))
+		assert.False(t, containsTx(t, nodeC, relayed.ID))
+	})
+}
+
+func TestPeerSource(t *testing.T) {
+	storage := mock.NewStorage()
+	powFactory := mock.NewPoWFactory()
+	wallets := wallet.NewCollection(storage)
+
+	address, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address))
+	bcA, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	for range 3 {
+		cbTx, err := transaction.NewCoinbaseTX(address, "")
+		require.NoError(t, err)
+		_, err = bcA.MineBlock(t.Context(), []*transaction.Tx{cbTx})
+		require.NoError(t, err)
+	}
+
+	nodeA := startNode(t, bcA)
+
+	t.Run("sync", func(t *testing.T) {
+		bcB := emptyBlockchain()
+
+		added, err := bcB.Sync(t.Context(), node.NewPeerSource(nodeA.Address()))
+		require.NoError(t, err)
+
+		assert.Equal(t, 4, added)
+		assert.Equal(t, bcA.GetBlockHashes(), bcB.GetBlockHashes())
+	})
+
+	t.Run("unknown block", func(t *testing.T) {
+		_, err := node.NewPeerSource(nodeA.Address()).GetBlock(t.Context(), block.Hash{'x'})
+		assert.Error(t, err)
+	})
+}
+
+func TestPeerSourceMessageTooLarge(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	// The peer answers every request with more data than a message may have
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.Copy(io.Discard, conn)
+		_, _ = conn.Write(make([]byte, node.MaxMessageSize+1))
+	}()
+
+	_, err = node.NewPeerSource(listener.Addr().String()).GetBlock(t.Context(), block.Hash{'x'})
+	assert.ErrorIs(t, err, node.ErrMessageTooLarge)
+}
+
+func containsTx(t *testing.T, n *node.Node, id transaction.TxID) bool {
+	t.Helper()
+
+	txs, err := n.PendingTransactions()
+	require.NoError(t, err)
+
+	return slices.ContainsFunc(txs, func(tx *transaction.Tx) bool {
+		return tx.ID == id
+	})
+}
@@ -0,0 +1,119 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+)
+
+// PeerSource syncs a blockchain from a peer, see blockchain.Blockchain.Sync.
+// It does not need a running node: every request is sent over its own connection
+// and the peer answers on the same connection.
+type PeerSource struct {
+	address string
+}
+
+// NewPeerSource creates a source that requests headers and blocks from the peer at the given address.
+func NewPeerSource(address string) *PeerSource {
+	return &PeerSource{address: address}
+}
+
+func (p *PeerSource) GetHeaders(ctx context.Context, locator []block.Hash) ([]*block.Block, error) {
+	items := make([][32]byte, 0, len(locator))
+	for _, hash := range locator {
+		items = append(items, hash)
+	}
+
+	var msg headersMsg
+	err := p.request(ctx, cmdGetHeaders, getHeadersMsg{Locator: items}, cmdHeaders, &msg)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]*block.Block, 0, len(msg.Headers))
+	for _, entry := range msg.Headers {
+		header := &block.Block{Hash: entry.Hash}
+		if err := header.Header.Deserialize(entry.Header); err != nil {
+			return nil, fmt.Errorf("failed to deserialize header %x: %w", entry.Hash, err)
+		}
+
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
+
+func (p *PeerSource) GetBlock(ctx context.Context, hash block.Hash) (*block.Block, error) {
+	var msg blockMsg
+	err := p.request(ctx, cmdGetData, getDataMsg{Type: invBlock, ID: hash}, cmdBlock, &msg)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &block.Block{}
+	if err := b.Deserialize(msg.Block); err != nil {
+		return nil, fmt.Errorf("failed to deserialize block: %w", err)
+	}
+
+	return b, nil
+}
+
+// request sends a message to the peer and decodes its answer into v.
+// The write side of the connection is closed after the request, so that the peer knows the message is complete.
+func (p *PeerSource) request(ctx context.Context, cmd command, payload any, answer command, v any) error {
+	data, err := encodeMessage(cmd, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", cmd, err)
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return fmt.Errorf("peer %s is not available: %w", p.address, err)
+	}
+	defer conn.Close()
+
+	// Unblock reading when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := conn.SetDeadline(time.Now().Add(readTimeout)); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("failed to send %s message to %s: %w", cmd, p.address, err)
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.CloseWrite(); err != nil {
+			return fmt.Errorf("failed to send %s message to %s: %w", cmd, p.address, err)
+		}
+	}
+
+	response, err := readMessage(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to read answer to %s message: %w", cmd, err)
+	}
+
+	if len(response) == 0 {
+		return fmt.Errorf("peer %s did not answer the %s message", p.address, cmd)
+	}
+
+	got, answerPayload, err := decodeMessage(response)
+	if err != nil {
+		return fmt.Errorf("failed to decode answer to %s message: %w", cmd, err)
+	}
+
+	if got != answer {
+		return fmt.Errorf("unexpected answer %q to %s message", got, cmd)
+	}
+
+	return decodePayload(answerPayload, v)
+}
@@ -0,0 +1,854 @@
+package rpc
+
+import (
+	"cmp"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/node"
+)
+
+type method func(s *Server, ctx context.Context, params []json.RawMessage) (any, error)
+
+//nolint:gochecknoglobals // Method table
+var methods = map[string]method{
+	"getblockcount":        (*Server).getBlockCount,
+	"getblock":             (*Server).getBlock,
+	"gettransaction":       (*Server).getTransaction,
+	"getbalance":           (*Server).getBalance,
+	"sendtoaddress":        (*Server).sendToAddress,
+	"listunspent":          (*Server).listUnspent,
+	"getnewaddress":        (*Server).getNewAddress,
+	"listaddresses":        (*Server).listAddresses,
+	"importaddress":        (*Server).importAddress,
+	"submitblock":          (*Server).submitBlock,
+	"sendrawtransaction":   (*Server).sendRawTransaction,
+	"createrawtransaction": (*Server).createRawTransaction,
+	"signrawtransaction":   (*Server).signRawTransaction,
+	"listpending":          (*Server).listPending,
+	"droptransaction":      (*Server).dropTransaction,
+	"mine":                 (*Server).mine,
+	"rollback":             (*Server).rollback,
+	"sync":                 (*Server).sync,
+
+	"encryptwallet":          (*Server).encryptWallet,
+	"walletpassphrase":       (*Server).walletPassphrase,
+	"walletpassphrasechange": (*Server).walletPassphraseChange,
+	"walletlock":             (*Server).walletLock,
+	"createhdwallet":         (*Server).createHDWallet,
+	"restorewallet":          (*Server).restoreWallet,
+	"dumpprivkey":            (*Server).dumpPrivKey,
+	"importprivkey":          (*Server).importPrivKey,
+}
+
+// privileged are the methods that reveal, replace or use private keys, lock the owner out of them
+// or rewrite the chain, and sync, which connects to any given address.
+// They are only served to trusted clients, see Trusted.
+//
+//nolint:gochecknoglobals // Method table
+var privileged = map[string]bool{
+	"sendtoaddress":          true,
+	"signrawtransaction":     true,
+	"rollback":               true,
+	"sync":                   true,
+	"encryptwallet":          true,
+	"walletpassphrasechange": true,
+	"createhdwallet":         true,
+	"restorewallet":          true,
+	"dumpprivkey":            true,
+	"importprivkey":          true,
+}
+
+// unlocked are the methods that take long and use the blockchain only while they read or change it,
+// see Blockchain.SetLock. The others hold the blockchain for the whole request.
+//
+//nolint:gochecknoglobals // Method table
+var unlocked = map[string]bool{
+	"mine": true,
+	"sync": true,
+}
+
+// BlockResult is the JSON representation of a block.
+type BlockResult struct {
+	Hash          string     `json:"hash"`
+	Height        int        `json:"height"`
+	Confirmations int        `json:"confirmations"`
+	Version       uint32     `json:"version"`
+	PrevBlockHash string     `json:"previousblockhash"`
+	MerkleRoot    string     `json:"merkleroot"`
+	Time          int64      `json:"time"`
+	Bits          string     `json:"bits"`
+	Nonce         uint64     `json:"nonce"`
+	Transactions  []TxResult `json:"tx"`
+}
+
+// TxResult is the JSON representation of a transaction.
+// The block hash and confirmations are only set by gettransaction.
+type TxResult struct {
+	TxID          string         `json:"txid"`
+	Inputs        []InputResult  `json:"vin"`
+	Outputs       []OutputResult `json:"vout"`
+	Fee           int32          `json:"fee"`
+	BlockHash     string         `json:"blockhash,omitempty"`
+	Confirmations int            `json:"confirmations"`
+	Hex           string         `json:"hex"`
+}
+
+// InputResult is the JSON representation of a transaction input.
+type InputResult struct {
+	TxID     string `json:"txid,omitempty"`
+	Vout     int    `json:"vout"`
+	Coinbase bool   `json:"coinbase,omitempty"`
+}
+
+// OutputResult is the JSON representation of a transaction output.
+type OutputResult struct {
+	Value   int32  `json:"value"`
+	Address string `json:"address"`
+}
+
+// UnspentResult is the JSON representation of an unspent output.
+type UnspentResult struct {
+	TxID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Value         int32  `json:"value"`
+	Address       string `json:"address"`
+	Height        int    `json:"height"`
+	Confirmations int    `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+}
+
+// AddressResult is the JSON representation of an address of the wallets.
+type AddressResult struct {
+	Address   string `json:"address"`
+	WatchOnly bool   `json:"watchonly"`
+}
+
+// HDWalletResult is the JSON representation of a new HD wallet.
+type HDWalletResult struct {
+	Mnemonic string `json:"mnemonic"`
+	Address  string `json:"address"`
+}
+
+// ImportKeyResult is the JSON representation of an imported private key.
+type ImportKeyResult struct {
+	Address string `json:"address"`
+	Balance int    `json:"balance"`
+	Unspent int    `json:"unspent"`
+}
+
+// SyncResult is the JSON representation of the outcome of a sync.
+type SyncResult struct {
+	Added  int `json:"added"`
+	Height int `json:"height"`
+}
+
+// getBlockCount returns the height of the tip.
+func (s *Server) getBlockCount(_ context.Context, params []json.RawMessage) (any, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	return s.bc.GetBestHeight(), nil
+}
+
+// getBlock returns a block given by its hash or by its height in the active chain.
+func (s *Server) getBlock(_ context.Context, params []json.RawMessage) (any, error) {
+	var id json.RawMessage
+	if err := parseParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+
+	var hash block.Hash
+	if height, err := strconv.Atoi(string(id)); err == nil {
+		b, err := s.bc.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		hash = b.Hash
+	} else {
+		var hexHash string
+		if err := json.Unmarshal(id, &hexHash); err != nil {
+			return nil, invalidParams("block must be a hash or a height")
+		}
+
+		hash, err = parseHash(hexHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.newBlockResult(hash)
+}
+
+// getTransaction returns a transaction of the active chain or a pending transaction.
+func (s *Server) getTransaction(_ context.Context, params []json.RawMessage) (any, error) {
+	var txIDHex string
+	if err := parseParams(params, 1, &txIDHex); err != nil {
+		return nil, err
+	}
+
+	txID, err := parseHash(txIDHex)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.bc.GetTransaction(transaction.TxID(txID))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.newTxResult(info.Tx)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Confirmations = info.Confirmations
+	if info.Confirmations > 0 {
+		result.BlockHash = hex.EncodeToString(info.BlockHash[:])
+	}
+
+	return result, nil
+}
+
+// getBalance returns the sum of the unspent outputs of an address.
+func (s *Server) getBalance(_ context.Context, params []json.RawMessage) (any, error) {
+	var address string
+	if err := parseParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := s.bc.FindUnspentTxOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := 0
+	for _, out := range outputs {
+		balance += int(out.Value)
+	}
+
+	return balance, nil
+}
+
+// sendToAddress creates a transaction from a wallet address to another address
+// and adds it to the pending transactions. It returns the transaction ID.
+func (s *Server) sendToAddress(_ context.Context, params []json.RawMessage) (any, error) {
+	var (
+		from, to    string
+		amount, fee int32
+	)
+	if err := parseParams(params, 3, &from, &to, &amount, &fee); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseAddress(from); err != nil {
+		return nil, err
+	}
+	if _, err := parseAddress(to); err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, invalidParams("amount must be positive")
+	}
+	if fee < 0 {
+		return nil, invalidParams("fee must not be negative")
+	}
+
+	tx, err := s.bc.NewUTXOTransaction(from, to, amount, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.bc.SubmitTransaction(tx); err != nil {
+		return nil, err
+	}
+	s.announceTx(tx)
+
+	return hex.EncodeToString(tx.ID[:]), nil
+}
+
+// listUnspent returns the unspent outputs of an address, oldest first.
+func (s *Server) listUnspent(_ context.Context, params []json.RawMessage) (any, error) {
+	var address string
+	if err := parseParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos, err := s.bc.ListUnspent(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	bestHeight := s.bc.GetBestHeight()
+
+	result := make([]UnspentResult, 0, len(utxos))
+	for outpoint, entry := range utxos {
+		result = append(result, UnspentResult{
+			TxID:          hex.EncodeToString(outpoint.TxID[:]),
+			Vout:          outpoint.Vout,
+			Value:         entry.Output.Value,
+			Address:       address,
+			Height:        entry.Height,
+			Confirmations: bestHeight - entry.Height + 1,
+			Coinbase:      entry.Coinbase,
+		})
+	}
+
+	slices.SortFunc(result, func(a, b UnspentResult) int {
+		return cmp.Or(cmp.Compare(a.Height, b.Height), strings.Compare(a.TxID, b.TxID), cmp.Compare(a.Vout, b.Vout))
+	})
+
+	return result, nil
+}
+
+// getNewAddress creates a new wallet and returns its address.
+func (s *Server) getNewAddress(_ context.Context, params []json.RawMessage) (any, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	return s.wallets.AddWallet()
+}
+
+// listAddresses returns the addresses of all wallets and the watch-only addresses.
+func (s *Server) listAddresses(_ context.Context, params []json.RawMessage) (any, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.wallets.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]AddressResult, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, AddressResult{Address: entry.Address, WatchOnly: entry.WatchOnly})
+	}
+
+	return result, nil
+}
+
+// importAddress watches an address without its key.
+func (s *Server) importAddress(_ context.Context, params []json.RawMessage) (any, error) {
+	var address string
+	if err := parseParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseAddress(address); err != nil {
+		return nil, err
+	}
+
+	if err := s.wallets.AddWatchAddress(address); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// submitBlock adds a hex encoded serialized block to the blockchain and returns its hash.
+func (s *Server) submitBlock(_ context.Context, params []json.RawMessage) (any, error) {
+	var blockHex string
+	if err := parseParams(params, 1, &blockHex); err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(blockHex)
+	if err != nil {
+		return nil, invalidParams("invalid block data: %v", err)
+	}
+
+	b := &block.Block{}
+	if err := b.Deserialize(data); err != nil {
+		return nil, invalidParams("invalid block data: %v", err)
+	}
+
+	if err := s.bc.AddBlock(b); err != nil {
+		return nil, err
+	}
+	s.announceBlock(b)
+
+	return hex.EncodeToString(b.Hash[:]), nil
+}
+
+// sendRawTransaction adds a hex encoded serialized transaction to the pending transactions and returns its ID.
+func (s *Server) sendRawTransaction(_ context.Context, params []json.RawMessage) (any, error) {
+	var txHex string
+	if err := parseParams(params, 1, &txHex); err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, invalidParams("invalid transaction data: %v", err)
+	}
+
+	tx := &transaction.Tx{}
+	if err := tx.Deserialize(data); err != nil {
+		return nil, invalidParams("invalid transaction data: %v", err)
+	}
+
+	if err := s.bc.SubmitTransaction(tx); err != nil {
+		return nil, err
+	}
+	s.announceTx(tx)
+
+	return hex.EncodeToString(tx.ID[:]), nil
+}
+
+// createRawTransaction creates a transaction from any address to another address without signing it.
+// It returns the hex encoded unsigned transaction with the transactions it spends, see transaction.UnsignedTx.
+func (s *Server) createRawTransaction(_ context.Context, params []json.RawMessage) (any, error) {
+	var (
+		from, to    string
+		amount, fee int32
+	)
+	if err := parseParams(params, 3, &from, &to, &amount, &fee); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseAddress(from); err != nil {
+		return nil, err
+	}
+	if _, err := parseAddress(to); err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, invalidParams("amount must be positive")
+	}
+	if fee < 0 {
+		return nil, invalidParams("fee must not be negative")
+	}
+
+	unsigned, err := s.bc.NewUnsignedTransaction(from, to, amount, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(unsigned.Serialize()), nil
+}
+
+// signRawTransaction signs a hex encoded unsigned transaction with the wallets
+// and returns the hex encoded signed transaction, which can be sent with sendrawtransaction.
+func (s *Server) signRawTransaction(_ context.Context, params []json.RawMessage) (any, error) {
+	var txHex string
+	if err := parseParams(params, 1, &txHex); err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, invalidParams("invalid transaction data: %v", err)
+	}
+
+	unsigned := &transaction.UnsignedTx{}
+	if err := unsigned.Deserialize(data); err != nil {
+		return nil, invalidParams("invalid transaction data: %v", err)
+	}
+
+	tx, err := blockchain.SignTransaction(s.wallets, unsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(tx.Serialize()), nil
+}
+
+// listPending returns the transactions that are waiting to be mined.
+func (s *Server) listPending(_ context.Context, params []json.RawMessage) (any, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	txs, err := s.bc.PendingTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TxResult, 0, len(txs))
+	for _, tx := range txs {
+		txResult, err := s.newTxResult(tx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, txResult)
+	}
+
+	return result, nil
+}
+
+// dropTransaction removes a transaction from the pending transactions.
+func (s *Server) dropTransaction(_ context.Context, params []json.RawMessage) (any, error) {
+	var txIDHex string
+	if err := parseParams(params, 1, &txIDHex); err != nil {
+		return nil, err
+	}
+
+	txID, err := parseHash(txIDHex)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.bc.DropTransaction(transaction.TxID(txID)); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// mine mines a block from the pending transactions that pays its reward to an address.
+// The block size defaults to blockchain.DefaultMaxBlockSize.
+func (s *Server) mine(ctx context.Context, params []json.RawMessage) (any, error) {
+	var address string
+	maxBlockSize := blockchain.DefaultMaxBlockSize
+	if err := parseParams(params, 1, &address, &maxBlockSize); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseAddress(address); err != nil {
+		return nil, err
+	}
+
+	b, err := s.bc.Mine(ctx, address, maxBlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var result any
+	s.do(func() {
+		s.announceBlock(b)
+		result, err = s.newBlockResult(b.Hash)
+	})
+
+	return result, err
+}
+
+// rollback disconnects blocks from the tip until the given block is the tip.
+// It returns the hashes of the disconnected blocks, starting with the old tip.
+func (s *Server) rollback(_ context.Context, params []json.RawMessage) (any, error) {
+	var hashHex string
+	if err := parseParams(params, 1, &hashHex); err != nil {
+		return nil, err
+	}
+
+	hash, err := parseHash(hashHex)
+	if err != nil {
+		return nil, err
+	}
+
+	disconnected, err := s.bc.Rollback(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(disconnected))
+	for _, b := range disconnected {
+		result = append(result, hex.EncodeToString(b.Hash[:]))
+	}
+
+	return result, nil
+}
+
+// sync brings the blockchain up to date from a peer. The checkpoints, given as a map from
+// height to block hash, only apply to this sync.
+func (s *Server) sync(ctx context.Context, params []json.RawMessage) (any, error) {
+	var (
+		peer        string
+		checkpoints map[int]string
+	)
+	if err := parseParams(params, 1, &peer, &checkpoints); err != nil {
+		return nil, err
+	}
+
+	parsed := make(blockchain.Checkpoints, len(checkpoints))
+	for height, hashHex := range checkpoints {
+		hash, err := parseHash(hashHex)
+		if err != nil {
+			return nil, err
+		}
+		parsed[height] = hash
+	}
+	added, err := s.bc.SyncWithCheckpoints(ctx, node.NewPeerSource(peer), parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed after %d blocks: %w", added, err)
+	}
+
+	result := SyncResult{Added: added}
+	s.do(func() {
+		result.Height = s.bc.GetBestHeight()
+	})
+
+	return result, nil
+}
+
+// encryptWallet encrypts the wallets with a passphrase, which locks them.
+func (s *Server) encryptWallet(_ context.Context, params []json.RawMessage) (any, error) {
+	var passphrase string
+	if err := parseParams(params, 1, &passphrase); err != nil {
+		return nil, err
+	}
+
+	if err := s.wallets.Encrypt(passphrase); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// walletPassphrase unlocks the wallets for the given number of seconds.
+func (s *Server) walletPassphrase(_ context.Context, params []json.RawMessage) (any, error) {
+	var (
+		passphrase string
+		timeout    int
+	)
+	if err := parseParams(params, 2, &passphrase, &timeout); err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		return nil, invalidParams("timeout must be positive: %d", timeout)
+	}
+
+	if err := s.wallets.Unlock(passphrase, time.Duration(timeout)*time.Second); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// walletPassphraseChange encrypts the wallets with a new passphrase, which locks them.
+func (s *Server) walletPassphraseChange(_ context.Context, params []json.RawMessage) (any, error) {
+	var oldPassphrase, newPassphrase string
+	if err := parseParams(params, 2, &oldPassphrase, &newPassphrase); err != nil {
+		return nil, err
+	}
+
+	if err := s.wallets.ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// walletLock locks the wallets before their unlock timeout passes.
+func (s *Server) walletLock(_ context.Context, params []json.RawMessage) (any, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	s.wallets.Lock()
+
+	return true, nil
+}
+
+// createHDWallet sets the HD seed of the wallets to a new mnemonic and returns it with the first address derived from it.
+func (s *Server) createHDWallet(_ context.Context, params []json.RawMessage) (any, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	mnemonic, err := wallet.NewMnemonic()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.wallets.SetSeed(mnemonic); err != nil {
+		return nil, err
+	}
+
+	address, err := s.wallets.AddWallet()
+	if err != nil {
+		return nil, err
+	}
+
+	return HDWalletResult{Mnemonic: mnemonic, Address: address}, nil
+}
+
+// restoreWallet restores the HD wallets of a mnemonic and returns their addresses.
+// The gap limit defaults to wallet.DefaultGapLimit.
+func (s *Server) restoreWallet(_ context.Context, params []json.RawMessage) (any, error) {
+	var mnemonic string
+	gapLimit := wallet.DefaultGapLimit
+	if err := parseParams(params, 1, &mnemonic, &gapLimit); err != nil {
+		return nil, err
+	}
+
+	if gapLimit <= 0 {
+		return nil, invalidParams("gap limit must be positive: %d", gapLimit)
+	}
+
+	addresses, err := s.bc.RestoreWallets(mnemonic, gapLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if addresses == nil {
+		addresses = []string{}
+	}
+
+	return addresses, nil
+}
+
+// dumpPrivKey returns the private key of a wallet address in one of wallet.KeyFormats, base58 by default.
+func (s *Server) dumpPrivKey(_ context.Context, params []json.RawMessage) (any, error) {
+	var address string
+	format := wallet.KeyFormatBase58
+	if err := parseParams(params, 1, &address, &format); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseAddress(address); err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(wallet.KeyFormats, format) {
+		return nil, invalidParams("unknown key format %q, must be one of %v", format, wallet.KeyFormats)
+	}
+
+	wlt, err := s.wallets.GetWallet(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return wlt.EncodePrivateKey(format)
+}
+
+// importPrivKey adds a wallet with a private key in any of wallet.KeyFormats
+// and returns its address with the balance of its unspent outputs.
+func (s *Server) importPrivKey(_ context.Context, params []json.RawMessage) (any, error) {
+	var key string
+	if err := parseParams(params, 1, &key); err != nil {
+		return nil, err
+	}
+
+	wlt, err := wallet.DecodePrivateKey(key)
+	if err != nil {
+		return nil, invalidParams("%v", err)
+	}
+
+	address, unspent, err := s.bc.ImportWallet(wlt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ImportKeyResult{Address: address, Unspent: len(unspent)}
+	for _, entry := range unspent {
+		result.Balance += int(entry.Output.Value)
+	}
+
+	return result, nil
+}
+
+// newBlockResult looks up a block and its place in the blockchain.
+func (s *Server) newBlockResult(hash block.Hash) (BlockResult, error) {
+	info, err := s.bc.GetBlockInfo(hash)
+	if err != nil {
+		return BlockResult{}, err
+	}
+
+	b := info.Block
+	result := BlockResult{
+		Hash:          hex.EncodeToString(b.Hash[:]),
+		Height:        info.Height,
+		Confirmations: info.Confirmations,
+		Version:       b.Version,
+		PrevBlockHash: hex.EncodeToString(b.PrevBlockHash[:]),
+		MerkleRoot:    hex.EncodeToString(b.MerkleRoot[:]),
+		Time:          b.Timestamp,
+		Bits:          fmt.Sprintf("%08x", b.Bits),
+		Nonce:         b.Nonce,
+		Transactions:  make([]TxResult, 0, len(b.Transactions)),
+	}
+
+	for _, tx := range b.Transactions {
+		txResult, err := s.newTxResult(tx)
+		if err != nil {
+			return BlockResult{}, err
+		}
+		result.Transactions = append(result.Transactions, txResult)
+	}
+
+	return result, nil
+}
+
+// newTxResult converts a transaction and looks up its fee.
+func (s *Server) newTxResult(tx *transaction.Tx) (TxResult, error) {
+	fee, err := s.bc.TransactionFee(tx)
+	if err != nil {
+		return TxResult{}, fmt.Errorf("failed to get fee of transaction %x: %w", tx.ID, err)
+	}
+
+	result := TxResult{
+		TxID:    hex.EncodeToString(tx.ID[:]),
+		Inputs:  make([]InputResult, 0, len(tx.Vin)),
+		Outputs: make([]OutputResult, 0, len(tx.Vout)),
+		Fee:     fee,
+		Hex:     hex.EncodeToString(tx.Serialize()),
+	}
+
+	for _, in := range tx.Vin {
+		if tx.IsCoinbase() {
+			result.Inputs = append(result.Inputs, InputResult{Vout: in.Vout, Coinbase: true})
+			continue
+		}
+
+		result.Inputs = append(result.Inputs, InputResult{TxID: hex.EncodeToString(in.TxID[:]), Vout: in.Vout})
+	}
+
+	for _, out := range tx.Vout {
+		result.Outputs = append(result.Outputs, OutputResult{
+			Value:   out.Value,
+			Address: wallet.AddressFromPubKeyHash(out.PubKeyHash),
+		})
+	}
+
+	return result, nil
+}
+
+// parseHash decodes a hex encoded block hash or transaction ID.
+func parseHash(s string) (block.Hash, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != len(block.Hash{}) {
+		return block.Hash{}, invalidParams("invalid hash %q", s)
+	}
+
+	return block.Hash(data), nil
+}
+
+// parseAddress validates an address and returns its public key hash.
+func parseAddress(address string) ([]byte, error) {
+	if err := wallet.ValidateAddress(address); err != nil {
+		return nil, invalidParams("invalid address %s: %v", address, err)
+	}
+
+	pubKeyHash, err := wallet.GetHashFromAddress([]byte(address))
+	if err != nil {
+		return nil, invalidParams("invalid address %s: %v", address, err)
+	}
+
+	return pubKeyHash, nil
+}
@@ -0,0 +1,280 @@
+// Package rpc exposes a blockchain and its wallets over HTTP with JSON-RPC 2.0.
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+)
+
+const (
+	jsonRPCVersion = "2.0"
+	maxRequestSize = 4 << 20 // Large enough for a serialized block of the default maximum size
+)
+
+// Error codes defined by the JSON-RPC 2.0 specification, codeServerError for failed calls
+// and codeUntrusted for privileged methods called by clients that are not trusted.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerError    = -32000
+	codeUntrusted      = -32001
+)
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// invalidParams returns an error for parameters a method cannot use.
+func invalidParams(format string, args ...any) *Error {
+	return &Error{Code: codeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+type request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"` // Always set on success, null for a nil result
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Network is a peer-to-peer network that shares the blockchain of a server.
+// It is implemented by node.Node.
+type Network interface {
+	// Do calls fn while the network does not use the blockchain.
+	Do(fn func())
+	// BroadcastTx announces a transaction that was added to the mempool.
+	BroadcastTx(tx *transaction.Tx)
+	// BroadcastBlock announces a block that was added to the blockchain.
+	BroadcastBlock(b *block.Block)
+}
+
+// Server answers JSON-RPC requests sent with HTTP POST.
+// Requests use the blockchain one at a time, as it is not safe for concurrent use.
+// Mining and syncing only hold it while they read or change the blockchain, see Blockchain.SetLock.
+type Server struct {
+	bc      *blockchain.Blockchain
+	wallets *wallet.Collection
+	network Network
+
+	mu sync.Mutex // Held while a request uses the blockchain
+	// Transactions and blocks created by the current request, announced to the network once it is handled
+	newTxs    []*transaction.Tx
+	newBlocks []*block.Block
+}
+
+// NewServer creates a server for the given blockchain and wallets.
+// The server sets the lock of the blockchain, so the blockchain must not be shared with another server.
+func NewServer(bc *blockchain.Blockchain, wallets *wallet.Collection) *Server {
+	s := &Server{bc: bc, wallets: wallets}
+	bc.SetLock(s.do)
+
+	return s
+}
+
+// SetNetwork shares the blockchain with a network. Requests are handled while the network does not use it,
+// and the transactions and blocks they create are announced to the network.
+func (s *Server) SetNetwork(network Network) {
+	s.network = network
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req request
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req)
+	if err != nil {
+		writeResponse(w, response{Error: &Error{Code: codeParseError, Message: err.Error()}, ID: json.RawMessage("null")})
+		return
+	}
+
+	if req.JSONRPC != jsonRPCVersion || req.Method == "" {
+		writeResponse(w, response{Error: &Error{Code: codeInvalidRequest, Message: "invalid request"}, ID: req.ID})
+		return
+	}
+
+	result, err := s.call(r.Context(), req.Method, req.Params)
+
+	if req.ID == nil {
+		w.WriteHeader(http.StatusNoContent) // Notifications are not answered
+		return
+	}
+
+	resp := response{ID: req.ID}
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: codeServerError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	}
+
+	writeResponse(w, resp)
+}
+
+// trustedKey is the context key that marks the requests of trusted clients.
+type trustedKey struct{}
+
+// Trusted marks the requests passed to next as coming from a trusted client,
+// e.g. one connected to a Unix socket that only the owner of the server can connect to.
+// Privileged methods, which reveal or replace private keys or connect to other hosts,
+// are refused to clients that are not trusted.
+func Trusted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trustedKey{}, true)))
+	})
+}
+
+// isTrusted reports whether a request was marked by Trusted.
+func isTrusted(ctx context.Context) bool {
+	trusted, _ := ctx.Value(trustedKey{}).(bool)
+	return trusted
+}
+
+// BasicAuth requires every request to authenticate with the given username and password before it is passed to next.
+// Authenticated requests are trusted, see Trusted.
+func BasicAuth(next http.Handler, username, password string) http.Handler {
+	next = Trusted(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUsername, gotPassword, ok := r.BasicAuth()
+
+		usernameOK := subtle.ConstantTimeCompare([]byte(gotUsername), []byte(username)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(gotPassword), []byte(password)) == 1
+
+		if !ok || !usernameOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="blockchain"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// call runs a method with its positional parameters.
+// The method is cancelled with the context, e.g. when a client stops waiting for a block to be mined.
+func (s *Server) call(ctx context.Context, method string, params []json.RawMessage) (any, error) {
+	handler, ok := methods[method]
+	if !ok {
+		return nil, &Error{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", method)}
+	}
+
+	if privileged[method] && !isTrusted(ctx) {
+		return nil, &Error{Code: codeUntrusted, Message: fmt.Sprintf("method %q requires authentication", method)}
+	}
+
+	var (
+		result any
+		err    error
+	)
+	if unlocked[method] {
+		result, err = handler(s, ctx, params)
+	} else {
+		s.do(func() {
+			result, err = handler(s, ctx, params)
+		})
+	}
+
+	s.broadcast()
+
+	return result, err
+}
+
+// do runs fn while neither other requests nor the network use the blockchain.
+func (s *Server) do(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.network == nil {
+		fn()
+		return
+	}
+
+	s.network.Do(fn)
+}
+
+// broadcast announces the queued transactions and blocks to the network.
+func (s *Server) broadcast() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tx := range s.newTxs {
+		s.network.BroadcastTx(tx)
+	}
+	for _, b := range s.newBlocks {
+		s.network.BroadcastBlock(b)
+	}
+	s.newTxs, s.newBlocks = nil, nil
+}
+
+// announceTx queues a transaction added to the mempool to be announced to the network.
+// It must be called while the server uses the blockchain, see do.
+func (s *Server) announceTx(tx *transaction.Tx) {
+	if s.network != nil {
+		s.newTxs = append(s.newTxs, tx)
+	}
+}
+
+// announceBlock queues a block added to the blockchain to be announced to the network.
+// It must be called while the server uses the blockchain, see do.
+func (s *Server) announceBlock(b *block.Block) {
+	if s.network != nil {
+		s.newBlocks = append(s.newBlocks, b)
+	}
+}
+
+func writeResponse(w http.ResponseWriter, resp response) {
+	resp.JSONRPC = jsonRPCVersion
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// parseParams decodes the positional parameters into dst. The first required parameters must be present.
+func parseParams(params []json.RawMessage, required int, dst ...any) error {
+	if len(params) < required || len(params) > len(dst) {
+		if required == len(dst) {
+			return invalidParams("expected %d parameters, got %d", required, len(params))
+		}
+		return invalidParams("expected %d to %d parameters, got %d", required, len(dst), len(params))
+	}
+
+	for i, param := range params {
+		if err := json.Unmarshal(param, dst[i]); err != nil {
+			return invalidParams("invalid parameter %d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package rpc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/blockchain"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/block"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/mock"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/transaction"
+	"github.com/jleipus/learn-blockchain/internal/blockchain/wallet"
+	"github.com/jleipus/learn-blockchain/internal/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpc.Error      `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// call sends a JSON-RPC request and decodes the result into result, if there is no error.
+func call(t *testing.T, url, method string, result any, params ...any) *rpc.Error {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+	require.NoError(t, err)
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body)) //nolint:noctx // Test request
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var rpcResp rpcResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResp))
+	assert.Equal(t, "2.0", rpcResp.JSONRPC)
+	assert.JSONEq(t, "1", string(rpcResp.ID))
+
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+
+	if result != nil {
+		require.NoError(t, json.Unmarshal(rpcResp.Result, result))
+	}
+
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	storage := mock.NewStorage()
+	powFactory := mock.NewPoWFactory()
+	wallets := wallet.NewCollection(storage)
+
+	address1, err := wallets.AddWallet()
+	require.NoError(t, err)
+
+	require.NoError(t, blockchain.CreateBlockchain(t.Context(), storage, powFactory, address1))
+	bc, err := blockchain.LoadBlockchain(storage, powFactory, wallets)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(rpc.Trusted(rpc.NewServer(bc, wallets)))
+	t.Cleanup(srv.Close)
+
+	var address2 string
+	t.Run("getnewaddress", func(t *testing.T) {
+		require.Nil(t, call(t, srv.URL, "getnewaddress", &address2))
+		require.NoError(t, wallet.ValidateAddress(address2))
+	})
+
+	var txID string
+	t.Run("sendtoaddress", func(t *testing.T) {
+		require.Nil(t, call(t, srv.URL, "sendtoaddress", &txID, address1, address2, 4, 1))
+
+		pending, err := bc.PendingTransactions()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, hex.EncodeToString(pending[0].ID[:]), txID)
+
+		var tx rpc.TxResult
+		require.Nil(t, call(t, srv.URL, "gettransaction", &tx, txID))
+		assert.Equal(t, 0, tx.Confirmations)
+		assert.Empty(t, tx.BlockHash)
+	})
+
+	var mined *block.Block
+	t.Run("submitblock", func(t *testing.T) {
+		template, err := bc.NewBlockTemplate(address1, blockchain.DefaultMaxBlockSize)
+		require.NoError(t, err)
+
+		prev, err := bc.GetBlockByHeight(0)
+		require.NoError(t, err)
+
+		mined = &block.Block{
+			Header:       block.Header{Version: block.HeaderVersion, PrevBlockHash: prev.Hash, Timestamp: prev.Timestamp},
+			Transactions: template.Transactions,
+			Hash:         block.Hash{'s', 'u', 'b', 'm', 'i', 't'},
+		}
+		mined.MerkleRoot = mined.ComputeMerkleRoot()
+
+		var hash string
+		require.Nil(t, call(t, srv.URL, "submitblock", &hash, hex.EncodeToString(mined.Serialize())))
+		assert.Equal(t, hex.EncodeToString(mined.Hash[:]), hash)
+	})
+
+	t.Run("getblockcount", func(t *testing.T) {
+		var count int
+		require.Nil(t, call(t, srv.URL, "getblockcount", &count))
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("getblock", func(t *testing.T) {
+		var byHeight, byHash rpc.BlockResult
+		require.Nil(t, call(t, srv.URL, "getblock", &byHeight, 1))
+		require.Nil(t, call(t, srv.URL, "getblock", &byHash, hex.EncodeToString(mined.Hash[:])))
+
+		assert.Equal(t, byHeight, byHash)
+		assert.Equal(t, 1, byHash.Height)
+		assert.Equal(t, 1, byHash.Confirmations)
+		require.Len(t, byHash.Transactions, 2)
+		assert.Equal(t, txID, byHash.Transactions[1].TxID)
+	})
+
+	t.Run("gettransaction", func(t *testing.T) {
+		var tx rpc.TxResult
+		require.Nil(t, call(t, srv.URL, "gettransaction", &tx, txID))
+
+		assert.Equal(t, hex.EncodeToString(mined.Hash[:]), tx.BlockHash)
+		assert.Equal(t, 1, tx.Confirmations)
+
+		data, err := hex.DecodeString(tx.Hex)
+		require.NoError(t, err)
+		var decoded transaction.Tx
+		require.NoError(t, decoded.Deserialize(data))
+		assert.Equal(t, txID, hex.EncodeToString(decoded.ID[:]))
+	})
+
+	t.Run("getbalance", func(t *testing.T) {
+		var balance int
+		require.Nil(t, call(t, srv.URL, "getbalance", &balance, address2))
+		assert.Equal(t, 4, balance)
+
+		// Change 5, block reward 10 and fee 1
+		require.Nil(t, call(t, srv.URL, "getbalance", &balance, address1))
+		assert.Equal(t, 16, balance)
+	})
+
+	t.Run("listunspent", func(t *testing.T) {
+		var unspent []rpc.UnspentResult
+		require.Nil(t, call(t, srv.URL, "listunspent", &unspent, address2))
+
+		require.Len(t, unspent, 1)
+		assert.Equal(t, txID, unspent[0].TxID)
+		assert.Equal(t, int32(4), unspent[0].Value)
+		assert.Equal(t, address2, unspent[0].Address)
+		assert.Equal(t, 1, unspent[0].Height)
+		assert.Equal(t, 1, unspent[0].Confirmations)
+		assert.False(t, unspent[0].Coinbase)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		err := call(t, srv.URL, "nosuchmethod", nil)
+		require.NotNil(t, err)
+		assert.Equal(t, -32601, err.Code)
+
+		err = call(t, srv.URL, "getbalance", nil)
+		require.NotNil(t, err)
+		assert.Equal(t, -32602, err.Code)
+
+		err = call(t, srv.URL, "getbalance", nil, "invalid")
+		require.NotNil(t, err)
+		assert.Equal(t, -32602, err.Code)
+
+		err = call(t, srv.URL, "sendtoaddress", nil, address2, address1, 100)
+		require.NotNil(t, err)
+		assert.Equal(t, -32000, err.Code)
+		assert.Contains(t, err.Message, "not enough funds")
+	})
+
+	t.Run("privileged methods", func(t *testing.T) {
+		untrusted := httptest.NewServer(rpc.NewServer(bc, wallets))
+		t.Cleanup(untrusted.Close)
+
+		for _, method := range []string{
+			"sendtoaddress", "signrawtransaction", "rollback", "sync", "encryptwallet", "walletpassphrasechange",
+			"createhdwallet", "restorewallet", "dumpprivkey", "importprivkey",
+		} {
+			err := call(t, untrusted.URL, method, nil)
+			require.NotNil(t, err, method)
+			assert.Equal(t, -32001, err.Code, method)
+		}
+
+		var count int
+		require.Nil(t, call(t, untrusted.URL, "getblockcount", &count))
+
+		var key string
+		require.Nil(t, call(t, srv.URL, "dumpprivkey", &key, address1))
+		assert.NotEmpty(t, key)
+	})
+}
+
+func TestServerRequests(t *testing.T) {
+	storage := mock.NewStorage()
+	wallets := wallet.NewCollection(storage)
+	bc := blockchain.NewBlockchain(storage, mock.NewPoWFactory(), wallets)
+
+	srv := httptest.NewServer(rpc.BasicAuth(rpc.NewServer(bc, wallets), "user", "secret"))
+	t.Cleanup(srv.Close)
+
+	send := func(t *testing.T, method, username, password, body string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(t.Context(), method, srv.URL, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.SetBasicAuth(username, password)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+	post := func(t *testing.T, username, password, body string) *http.Response {
+		t.Helper()
+		return send(t, http.MethodPost, username, password, body)
+	}
+
+	request := `{"jsonrpc":"2.0","method":"getblockcount","id":"a"}`
+
+	t.Run("authorized", func(t *testing.T) {
+		resp := post(t, "user", "secret", request)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var rpcResp rpcResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResp))
+		assert.Nil(t, rpcResp.Error)
+		assert.JSONEq(t, "-1", string(rpcResp.Result))
+		assert.JSONEq(t, `"a"`, string(rpcResp.ID))
+	})
+
+	t.Run("authorized privileged method", func(t *testing.T) {
+		resp := post(t, "user", "secret", `{"jsonrpc":"2.0","method":"dumpprivkey","params":["invalid"],"id":"a"}`)
+
+		var rpcResp rpcResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResp))
+		require.NotNil(t, rpcResp.Error)
+		assert.Equal(t, -32602, rpcResp.Error.Code)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		resp := post(t, "user", "wrong", request)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("not post", func(t *testing.T) {
+		resp := send(t, http.MethodGet, "user", "secret", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		resp := post(t, "user", "secret", `{`)
+
+		var rpcResp rpcResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResp))
+		require.NotNil(t, rpcResp.Error)
+		assert.Equal(t, -32700, rpcResp.Error.Code)
+	})
+
+	// A response has exactly one of result and error, a nil result is sent as null
+	t.Run("result or error", func(t *testing.T) {
+		var members map[string]json.RawMessage
+		require.NoError(t, json.NewDecoder(post(t, "user", "secret", request).Body).Decode(&members))
+		assert.Contains(t, members, "result")
+		assert.NotContains(t, members, "error")
+
+		members = nil
+		require.NoError(t, json.NewDecoder(post(t, "user", "secret", `{`).Body).Decode(&members))
+		assert.NotContains(t, members, "result")
+		assert.Contains(t, members, "error")
+	})
+
+	t.Run("notification", func(t *testing.T) {
+		resp := post(t, "user", "secret", `{"jsonrpc":"2.0","method":"getblockcount"}`)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+}
@@ -23,12 +23,11 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
-		if b == 0x00 {
-			result = append([]byte{b58Alphabet[0]}, result...)
-		} else {
+	for _, b := range input {
+		if b != 0x00 {
 			break
 		}
+		result = append([]byte{b58Alphabet[0]}, result...) // Leading zero bytes are kept as leading '1's
 	}
 
 	return result
@@ -39,10 +38,11 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
@@ -0,0 +1,28 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/jleipus/learn-blockchain/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase58RoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		encoded string
+	}{
+		{name: "no leading zeros", input: []byte{0x01, 0x02, 0x03}, encoded: "Ldp"},
+		{name: "one leading zero", input: []byte{0x00, 0x01, 0x02, 0x03}, encoded: "1Ldp"},
+		{name: "two leading zeros", input: []byte{0x00, 0x00, 0x01, 0x02, 0x03}, encoded: "11Ldp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := utils.Base58Encode(tt.input)
+			assert.Equal(t, tt.encoded, string(encoded))
+			assert.Equal(t, tt.input, utils.Base58Decode(encoded))
+		})
+	}
+}